@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// inventory lists the migrations of a batch.
+type inventory struct {
+	Migrations []inventoryEntry `yaml:"migrations"`
+}
+
+// inventoryEntry is one migration of a batch: a record set, or an ELB whose
+// record sets are looked up in every hosted zone.
+type inventoryEntry struct {
+	Zone       string `yaml:"zone"`
+	CNAME      string `yaml:"cname"`
+	RecordType string `yaml:"recordType"`
+	SourceElb  string `yaml:"sourceElb"`
+	TargetElb  string `yaml:"targetElb"`
+	// Env overrides --env for this migration.
+	Env string `yaml:"env"`
+}
+
+func (entry inventoryEntry) name() string {
+	if entry.CNAME != "" {
+		return entry.CNAME
+	}
+	return entry.SourceElb
+}
+
+func loadInventory(path string) (*inventory, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading inventory: %v", err)
+	}
+
+	inv := &inventory{}
+	if err := yaml.UnmarshalStrict(data, inv); err != nil {
+		return nil, fmt.Errorf("parsing inventory %s: %v", path, err)
+	}
+	if len(inv.Migrations) == 0 {
+		return nil, fmt.Errorf("inventory %s lists no migrations", path)
+	}
+	for i, entry := range inv.Migrations {
+		hasRecord := entry.Zone != "" || entry.CNAME != ""
+		if hasRecord && (entry.Zone == "" || entry.CNAME == "") {
+			return nil, fmt.Errorf("inventory %s: migrations[%d]: zone and cname go together", path, i)
+		}
+		if !hasRecord && entry.SourceElb == "" {
+			return nil, fmt.Errorf("inventory %s: migrations[%d]: zone and cname, or sourceElb, are required", path, i)
+		}
+	}
+
+	return inv, nil
+}
+
+// Statuses of the migrations of a batch.
+const (
+	batchPlanned    = "planned"
+	batchPlanFailed = "plan failed"
+	batchNotStarted = "not started"
+	batchMigrated   = "migrated"
+	batchFailed     = "failed"
+	batchRolledBack = "rolled back"
+	batchHalted     = "halted"
+	batchReverted   = "reverted"
+)
+
+// batchResult is the outcome of one migration of a batch.
+type batchResult struct {
+	Name           string   `json:"name"`
+	Zone           string   `json:"zone,omitempty"`
+	CNAME          string   `json:"cname,omitempty"`
+	Status         string   `json:"status"`
+	Step           string   `json:"step,omitempty"`
+	Error          string   `json:"error,omitempty"`
+	PlannedChanges int      `json:"plannedChanges"`
+	Warnings       []string `json:"warnings,omitempty"`
+	StateFile      string   `json:"stateFile,omitempty"`
+	Seconds        float64  `json:"seconds,omitempty"`
+
+	opts *options
+}
+
+// runBatch plans every migration of the inventory as a dry run, and unless
+// running dry, runs the ones that planned cleanly after a single
+// confirmation, at most --concurrency at a time. Every migration saves its
+// own state file, so a failed one can be resumed on its own.
+func runBatch(ctx context.Context, c *clients, opts *options) error {
+	inv, err := loadInventory(opts.inventoryPath)
+	if err != nil {
+		return err
+	}
+
+	results := make([]*batchResult, len(inv.Migrations))
+	claimed := map[string]string{}
+	combined := &plan{}
+	for i, entry := range inv.Migrations {
+		result := &batchResult{Name: entry.name(), Status: batchPlanned}
+		results[i] = result
+		fmt.Fprintf(stdout(ctx), "==> planning %s (%d of %d)\n", result.Name, i+1, len(inv.Migrations))
+
+		entryOpts, err := opts.forEntry(ctx, c, entry)
+		if err == nil {
+			result.Zone, result.CNAME, result.StateFile, result.opts = entryOpts.zone, entryOpts.cname, entryOpts.statePath, entryOpts
+			err = claimBatchResources(claimed, result.Name, entryOpts)
+		}
+		if err == nil {
+			entryPlan := &plan{}
+			err = runPlan(withPlan(ctx, entryPlan), c, entryOpts)
+			result.PlannedChanges = len(entryPlan.Changes)
+			result.Warnings = entryPlan.Warnings
+			combined.Changes = append(combined.Changes, entryPlan.Changes...)
+			combined.Warnings = append(combined.Warnings, entryPlan.Warnings...)
+			if planOf(ctx) == nil {
+				entryPlan.print(stdout(ctx))
+			}
+		}
+		if err != nil {
+			result.Status = batchPlanFailed
+			result.Error = err.Error()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	// The batch's plan only gets the changes once every migration has been
+	// planned, so an interrupted batch leaves no partial plan behind.
+	if dryRun := planOf(ctx); dryRun != nil {
+		dryRun.Changes = append(dryRun.Changes, combined.Changes...)
+		dryRun.Warnings = append(dryRun.Warnings, combined.Warnings...)
+		return finishBatch(ctx, opts, results)
+	}
+
+	var runnable []*batchResult
+	for _, result := range results {
+		if result.Status == batchPlanned {
+			result.Status = batchNotStarted
+			runnable = append(runnable, result)
+		}
+	}
+	printBatchReport(stdout(ctx), results)
+	if len(runnable) == 0 {
+		return finishBatch(ctx, opts, results)
+	}
+	warnings := 0
+	for _, result := range runnable {
+		warnings += len(result.Warnings)
+	}
+	question := fmt.Sprintf("Proceed with %d migrations, %d at a time? ", len(runnable), opts.concurrency)
+	if warnings > 0 {
+		question = fmt.Sprintf("Proceed with %d migrations, %d at a time, despite the %d warnings above? ", len(runnable), opts.concurrency, warnings)
+	}
+	if err := replConfirmation(ctx, question); err != nil {
+		return err
+	}
+	// The migrations run side by side, so they cannot ask for confirmation.
+	// The questions they would ask are the ones their warnings, listed in
+	// the report above, stand for.
+	ctx = withConfirmed(ctx)
+
+	var wg sync.WaitGroup
+	var outputMu sync.Mutex
+	slots := make(chan struct{}, opts.concurrency)
+	for _, result := range runnable {
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(result *batchResult) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			out := &prefixWriter{mu: &outputMu, w: stdout(ctx), prefix: "[" + result.Name + "] "}
+			defer out.flush()
+			result.run(withStdout(ctx, out), c)
+		}(result)
+	}
+	wg.Wait()
+
+	return finishBatch(ctx, opts, results)
+}
+
+// forEntry returns the options of one migration of a batch: the batch's own,
+// with the entry's record set, ELBs and environment. An entry that only names
+// an ELB migrates every record set that points at it, the first one it finds
+// taking the place of --cname.
+func (opts *options) forEntry(ctx context.Context, c *clients, entry inventoryEntry) (*options, error) {
+	entryOpts := *opts
+	entryOpts.command = "migrate"
+	entryOpts.zone = entry.Zone
+	entryOpts.cname = entry.CNAME
+	entryOpts.recordType = entry.RecordType
+	entryOpts.sourceElb = entry.SourceElb
+	entryOpts.targetElb = entry.TargetElb
+	entryOpts.statePath = ""
+	if entry.Env != "" {
+		if _, err := opts.config.environment(entry.Env); err != nil {
+			return nil, err
+		}
+		entryOpts.environment = entry.Env
+		entryOpts.defaults = opts.config.defaults(entry.Env)
+	}
+
+	if entryOpts.cname == "" {
+		description, err := getElbDescription(ctx, c.elb, entryOpts.sourceElb)
+		if err != nil {
+			return nil, err
+		}
+		entryOpts.allRecords = true
+		pairs, err := findBlueGreens(ctx, c, &entryOpts, nil, *description.DNSName, "")
+		if err != nil {
+			return nil, err
+		}
+		if len(pairs) == 0 {
+			return nil, fmt.Errorf("no record set in any hosted zone points at ELB %s", entryOpts.sourceElb)
+		}
+		entryOpts.zone = *pairs[0].zone.Name
+		entryOpts.cname = *pairs[0].blue.Name
+		entryOpts.recordType = *pairs[0].blue.Type
+	}
+
+	if err := entryOpts.validate(); err != nil {
+		return nil, err
+	}
+	entryOpts.statePath = defaultStatePath(&entryOpts)
+	// The source ELB is resolved once, so that entries whose record sets
+	// point at the same ELB are found before either is migrated.
+	sourceElb, err := findSourceElbName(ctx, c, &entryOpts)
+	if err != nil {
+		return nil, err
+	}
+	entryOpts.sourceElb = sourceElb
+	return &entryOpts, nil
+}
+
+// claimBatchResources records that the batch migration name moves the record
+// set of opts from its source ELB to its replica, unless an earlier migration
+// of the batch already moves any of them.
+func claimBatchResources(claimed map[string]string, name string, opts *options) error {
+	resources := []string{"record set " + opts.cname, "source ELB " + opts.sourceElb, "replica " + opts.replicaName(opts.sourceElb)}
+	for _, resource := range resources {
+		if other, ok := claimed[resource]; ok {
+			return fmt.Errorf("migrates the same %s as %s", resource, other)
+		}
+	}
+	for _, resource := range resources {
+		claimed[resource] = name
+	}
+	return nil
+}
+
+// run migrates the record set of a batch entry and records the outcome.
+func (result *batchResult) run(ctx context.Context, c *clients) {
+	started := time.Now()
+	err := runMigrate(ctx, c, result.opts)
+	result.Seconds = time.Since(started).Round(time.Second).Seconds()
+	if err == nil {
+		result.Status = batchMigrated
+		return
+	}
+
+	result.Error = err.Error()
+	var stepErr *stepError
+	if errors.As(err, &stepErr) {
+		result.Step = stepErr.Step
+		if err == error(stepErr) {
+			result.Error = stepErr.Err.Error()
+		}
+	}
+	switch state, stateErr := loadState(result.opts.statePath); {
+	case errors.Is(err, errShiftHalted):
+		result.Status = batchHalted
+	case errors.Is(err, errShiftReverted):
+		result.Status = batchReverted
+	case stateErr == nil && state.RolledBack:
+		result.Status = batchRolledBack
+	default:
+		result.Status = batchFailed
+	}
+}
+
+// prefixWriter starts every line with prefix and writes only whole lines to
+// w, under a mutex shared by the migrations of a batch, so that their lines
+// do not interleave.
+type prefixWriter struct {
+	mu      *sync.Mutex
+	w       io.Writer
+	prefix  string
+	partial []byte
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.partial = append(p.partial, data...)
+	end := bytes.LastIndexByte(p.partial, '\n')
+	if end < 0 {
+		return len(data), nil
+	}
+	var lines []byte
+	for _, line := range bytes.SplitAfter(p.partial[:end+1], []byte("\n")) {
+		if len(line) > 0 {
+			lines = append(append(lines, p.prefix...), line...)
+		}
+	}
+	p.partial = append([]byte(nil), p.partial[end+1:]...)
+	if _, err := p.w.Write(lines); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// flush writes what is left of an unfinished line.
+func (p *prefixWriter) flush() {
+	if len(p.partial) > 0 {
+		p.Write([]byte("\n"))
+	}
+}
+
+// finishBatch prints the report, writes it to --report if given, and fails
+// unless every migration went through.
+func finishBatch(ctx context.Context, opts *options, results []*batchResult) error {
+	printBatchReport(stdout(ctx), results)
+	if opts.reportPath != "" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(opts.reportPath, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout(ctx), "Report written to", opts.reportPath)
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Status != batchMigrated && result.Status != batchPlanned {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d migrations did not complete", failed, len(results))
+	}
+	return nil
+}
+
+func printBatchReport(w io.Writer, results []*batchResult) {
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Batch report:")
+	for _, result := range results {
+		var details []string
+		if result.Status == batchPlanned || result.Status == batchNotStarted {
+			details = append(details, fmt.Sprintf("%d planned changes", result.PlannedChanges))
+		}
+		if result.Seconds > 0 {
+			details = append(details, fmt.Sprintf("%.0fs", result.Seconds))
+		}
+		if result.Step != "" {
+			details = append(details, "in step "+result.Step)
+		}
+		if result.Error != "" {
+			details = append(details, result.Error)
+		}
+		line := fmt.Sprintf("  %-40s %-12s %s", result.Name, result.Status, strings.Join(details, ", "))
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+		if result.Status == batchPlanned || result.Status == batchNotStarted {
+			for _, warning := range result.Warnings {
+				fmt.Fprintln(w, "      warning: "+warning)
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// useInventory writes an inventory with the test record set and points
+// --inventory at it. The state files of the batch go to a temporary working
+// directory.
+func (tm *testMigration) useInventory(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	tm.opts.command = "batch"
+	tm.opts.inventoryPath = filepath.Join(dir, "inventory.yaml")
+	tm.opts.reportPath = filepath.Join(dir, "report.json")
+	data := "migrations:\n  - zone: " + testZone + "\n    cname: " + testCNAME + "\n"
+	if err := ioutil.WriteFile(tm.opts.inventoryPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchDryRunCollectsWarnings(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useInventory(t)
+	tm.opts.targetType = targetTypeNLB
+	tm.addTCPListener()
+	dryRun := &plan{}
+
+	if err := runBatch(withPlan(context.Background(), dryRun), tm.c, tm.opts); err != nil {
+		t.Fatalf("runBatch: %v", err)
+	}
+
+	if len(dryRun.Changes) == 0 {
+		t.Error("the dry run planned no changes")
+	}
+	want := []string{"idle timeout 60s is not copied", "an NLB cannot reproduce these settings of app"}
+	if len(dryRun.Warnings) != len(want) {
+		t.Fatalf("warnings = %q, want the idle timeout and the settings the NLB cannot reproduce", dryRun.Warnings)
+	}
+	for i, warning := range dryRun.Warnings {
+		if !strings.HasPrefix(warning, want[i]) {
+			t.Errorf("warnings[%d] = %q, want %q...", i, warning, want[i])
+		}
+	}
+	if len(tm.elbv2.loadBalancers) != 0 {
+		t.Error("the dry run created an NLB")
+	}
+}
+
+func TestBatchWarningsDeclined(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useInventory(t)
+	tm.opts.targetType = targetTypeNLB
+	tm.addTCPListener()
+	stdin = bufio.NewReader(strings.NewReader("n\n"))
+
+	if err := runBatch(context.Background(), tm.c, tm.opts); !errors.Is(err, errAborted) {
+		t.Fatalf("runBatch = %v, want errAborted", err)
+	}
+	if len(tm.elbv2.loadBalancers) != 0 {
+		t.Error("an NLB was created although the batch was declined")
+	}
+}
+
+func TestBatchMigratesAfterConfirmation(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useInventory(t)
+	tm.opts.targetType = targetTypeNLB
+	tm.addTCPListener()
+	// The batch is confirmed once, the migration does not ask again.
+	stdin = bufio.NewReader(strings.NewReader("y\n"))
+
+	if err := runBatch(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runBatch: %v", err)
+	}
+
+	if _, ok := tm.elbv2.loadBalancers["app-r"]; !ok {
+		t.Error("NLB app-r was not created")
+	}
+	data, err := ioutil.ReadFile(tm.opts.reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []*batchResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != batchMigrated || len(results[0].Warnings) != 2 {
+		t.Errorf("report = %s, want app migrated with its warnings", data)
+	}
+}
+
+func TestBatchInterruptedWhilePlanning(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useInventory(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dryRun := &plan{}
+
+	if err := runBatch(withPlan(ctx, dryRun), tm.c, tm.opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("runBatch = %v, want context.Canceled", err)
+	}
+	if len(dryRun.Changes) != 0 {
+		t.Errorf("the interrupted batch left %d planned changes", len(dryRun.Changes))
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	out := &prefixWriter{mu: &sync.Mutex{}, w: &buf, prefix: "[app] "}
+	for _, data := range []string{"one\ntw", "o\n", "\nthree"} {
+		if n, err := out.Write([]byte(data)); n != len(data) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", data, n, err)
+		}
+	}
+	if want := "[app] one\n[app] two\n[app] \n"; buf.String() != want {
+		t.Errorf("written %q, want %q", buf.String(), want)
+	}
+	out.flush()
+	if want := "[app] one\n[app] two\n[app] \n[app] three\n"; buf.String() != want {
+		t.Errorf("written after flush %q, want %q", buf.String(), want)
+	}
+}
+
+func TestBatchPrefixesMigrationOutput(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useInventory(t)
+	var buf bytes.Buffer
+
+	if err := runBatch(withStdout(context.Background(), &buf), tm.c, tm.opts); err != nil {
+		t.Fatalf("runBatch: %v", err)
+	}
+
+	// Everything between the batch's confirmation and the blank line before
+	// its report comes from the migration.
+	lines := strings.Split(buf.String(), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "Proceed with 1 migrations") {
+			lines = lines[i+1:]
+			break
+		}
+	}
+	for _, line := range lines {
+		if line == "" {
+			break
+		}
+		if !strings.HasPrefix(line, "["+testCNAME+"] ") {
+			t.Errorf("line %q of the migration is not prefixed with its name", line)
+		}
+	}
+	if !strings.Contains(buf.String(), "["+testCNAME+"] ==> shift") {
+		t.Errorf("output = %s, want the migration's steps", buf.String())
+	}
+}
+
+func TestBatchRefusesEntriesOfOneELB(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useInventory(t)
+	tm.route53.mu.Lock()
+	tm.route53.recordSets[*tm.zone.Id] = append(tm.route53.recordSets[*tm.zone.Id], &route53.ResourceRecordSet{
+		Name:            aws.String("www." + testZone),
+		Type:            aws.String(route53.RRTypeCname),
+		TTL:             aws.Int64(60),
+		SetIdentifier:   aws.String("app"),
+		Weight:          aws.Int64(100),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(testSourceDNSName)}},
+	})
+	tm.route53.sortRecordSets(*tm.zone.Id)
+	tm.route53.mu.Unlock()
+	data := "migrations:\n" +
+		"  - zone: " + testZone + "\n    cname: " + testCNAME + "\n" +
+		"  - zone: " + testZone + "\n    cname: www." + testZone + "\n" +
+		"  - zone: " + testZone + "\n    cname: " + testCNAME + "\n    targetElb: app-green\n" +
+		"  - sourceElb: other\n    targetElb: app-r\n"
+	if err := ioutil.WriteFile(tm.opts.inventoryPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tm.elb.addLoadBalancer(&elb.LoadBalancerDescription{
+		LoadBalancerName: aws.String("other"),
+		DNSName:          aws.String("other-1234567890.us-west-2.elb.amazonaws.com"),
+		Policies:         &elb.Policies{},
+	}, nil, nil)
+	tm.route53.addHostedZone("Z2", "other.example.com.", &route53.ResourceRecordSet{
+		Name:            aws.String("other.other.example.com."),
+		Type:            aws.String(route53.RRTypeCname),
+		TTL:             aws.Int64(60),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("other-1234567890.us-west-2.elb.amazonaws.com")}},
+	})
+
+	if err := runBatch(withPlan(context.Background(), &plan{}), tm.c, tm.opts); err == nil || !strings.Contains(err.Error(), "3 of 4 migrations did not complete") {
+		t.Fatalf("runBatch = %v, want the three later entries refused", err)
+	}
+
+	report, err := ioutil.ReadFile(tm.opts.reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []*batchResult
+	if err := json.Unmarshal(report, &results); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ status, err string }{
+		{batchPlanned, ""},
+		{batchPlanFailed, "migrates the same source ELB app as " + testCNAME},
+		{batchPlanFailed, "migrates the same record set " + testCNAME + " as " + testCNAME},
+		{batchPlanFailed, "migrates the same replica app-r as " + testCNAME},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("report = %s, want %d results", report, len(want))
+	}
+	for i, result := range results {
+		if result.Status != want[i].status || result.Error != want[i].err {
+			t.Errorf("results[%d] = %s, %q, want %s, %q", i, result.Status, result.Error, want[i].status, want[i].err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// certificateLookupACM finds the replica's certificates in ACM by domain name.
+const certificateLookupACM = "acm"
+
+// replicaCertificates replaces the certificate of every HTTPS and SSL listener
+// of description with the one the replica uses: the one mappings.certificates
+// names, one found in ACM by domain name with certificateLookup: acm, or the
+// source's own. A replica in another region or account cannot use the
+// source's own. Every certificate has to exist and stay valid for at least
+// certificateMinDays.
+func replicaCertificates(ctx context.Context, c *clients, opts *options, description *elb.LoadBalancerDescription) error {
+	translated := map[string]string{}
+	for _, listenerDescription := range description.ListenerDescriptions {
+		listener := listenerDescription.Listener
+		certificateArn := aws.StringValue(listener.SSLCertificateId)
+		if certificateArn == "" {
+			continue
+		}
+		replicaArn, ok := translated[certificateArn]
+		if !ok {
+			var err error
+			replicaArn, err = translateCertificate(c, opts, certificateArn)
+			if err != nil {
+				return err
+			}
+			if err := checkCertificate(c.forReplica(), replicaArn, opts.defaults.CertificateMinDays); err != nil {
+				return err
+			}
+			translated[certificateArn] = replicaArn
+		}
+		if replicaArn != certificateArn {
+			warn(ctx, "listener %d uses certificate %s instead of %s", *listener.LoadBalancerPort, replicaArn, certificateArn)
+		}
+		listener.SSLCertificateId = aws.String(replicaArn)
+	}
+	return nil
+}
+
+func translateCertificate(c *clients, opts *options, certificateArn string) (string, error) {
+	if replicaArn, ok := opts.config.mappings(opts.environment).Certificates[certificateArn]; ok {
+		return replicaArn, nil
+	}
+	if opts.defaults.CertificateLookup == certificateLookupACM {
+		domain, err := certificateDomain(c, certificateArn)
+		if err != nil {
+			return "", err
+		}
+		return findACMCertificate(c.forReplica().acm, domain)
+	}
+	if opts.crossTarget() {
+		return "", fmt.Errorf("certificate %s is not available in %s, map it under mappings.certificates or set certificateLookup: acm", certificateArn, opts.replicaRegion())
+	}
+	return certificateArn, nil
+}
+
+// checkCertificate fails unless the ACM or IAM certificate exists, is issued
+// and does not expire within minDays.
+func checkCertificate(c *clients, certificateArn string, minDays int64) error {
+	var notAfter time.Time
+	if isIAMCertificate(certificateArn) {
+		metadata, err := getServerCertificateMetadata(c.iam, certificateArn)
+		if err != nil {
+			return err
+		}
+		notAfter = aws.TimeValue(metadata.Expiration)
+	} else {
+		certificate, err := describeACMCertificate(c.acm, certificateArn)
+		if err != nil {
+			return err
+		}
+		if status := aws.StringValue(certificate.Status); status != acm.CertificateStatusIssued {
+			return fmt.Errorf("certificate %s is %s, not %s", certificateArn, status, acm.CertificateStatusIssued)
+		}
+		notAfter = aws.TimeValue(certificate.NotAfter)
+	}
+
+	if notAfter.IsZero() {
+		return nil
+	}
+	if remaining := time.Until(notAfter); remaining < time.Duration(minDays)*24*time.Hour {
+		return fmt.Errorf("certificate %s expires on %s, within %d days", certificateArn, notAfter.Format("2006-01-02"), minDays)
+	}
+	return nil
+}
+
+// certificateDomain returns the domain name of the source's certificate.
+func certificateDomain(c *clients, certificateArn string) (string, error) {
+	if !isIAMCertificate(certificateArn) {
+		certificate, err := describeACMCertificate(c.acm, certificateArn)
+		if err != nil {
+			return "", err
+		}
+		return aws.StringValue(certificate.DomainName), nil
+	}
+
+	serverCertificate, err := getServerCertificate(c.iam, certificateArn)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode([]byte(aws.StringValue(serverCertificate.CertificateBody)))
+	if block == nil {
+		return "", fmt.Errorf("certificate %s: no PEM certificate in its body", certificateArn)
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("certificate %s: %v", certificateArn, err)
+	}
+	if certificate.Subject.CommonName != "" {
+		return certificate.Subject.CommonName, nil
+	}
+	if len(certificate.DNSNames) > 0 {
+		return certificate.DNSNames[0], nil
+	}
+	return "", fmt.Errorf("certificate %s names no domain", certificateArn)
+}
+
+func isIAMCertificate(certificateArn string) bool {
+	return strings.Contains(certificateArn, ":server-certificate/")
+}
+
+// serverCertificateName returns the name of an IAM server certificate, the
+// last part of its ARN's path.
+func serverCertificateName(certificateArn string) string {
+	return certificateArn[strings.LastIndex(certificateArn, "/")+1:]
+}
+
+func describeACMCertificate(svc acmiface.ACMAPI, certificateArn string) (*acm.CertificateDetail, error) {
+	input := &acm.DescribeCertificateInput{
+		CertificateArn: aws.String(certificateArn),
+	}
+
+	result, err := svc.DescribeCertificate(input)
+	if err != nil {
+		return nil, newAWSError("DescribeCertificate", certificateArn, err)
+	}
+
+	return result.Certificate, nil
+}
+
+// findACMCertificate returns the issued ACM certificate for domain that is
+// valid the longest.
+func findACMCertificate(svc acmiface.ACMAPI, domain string) (string, error) {
+	input := &acm.ListCertificatesInput{
+		CertificateStatuses: aws.StringSlice([]string{acm.CertificateStatusIssued}),
+	}
+
+	var found *acm.CertificateSummary
+	err := svc.ListCertificatesPages(input, func(page *acm.ListCertificatesOutput, lastPage bool) bool {
+		for _, summary := range page.CertificateSummaryList {
+			if aws.StringValue(summary.DomainName) != domain {
+				continue
+			}
+			if found == nil || aws.TimeValue(summary.NotAfter).After(aws.TimeValue(found.NotAfter)) {
+				found = summary
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", newAWSError("ListCertificates", domain, err)
+	}
+	if found == nil {
+		return "", fmt.Errorf("no issued ACM certificate for %s", domain)
+	}
+
+	return aws.StringValue(found.CertificateArn), nil
+}
+
+func getServerCertificate(svc iamiface.IAMAPI, certificateArn string) (*iam.ServerCertificate, error) {
+	input := &iam.GetServerCertificateInput{
+		ServerCertificateName: aws.String(serverCertificateName(certificateArn)),
+	}
+
+	result, err := svc.GetServerCertificate(input)
+	if err != nil {
+		return nil, newAWSError("GetServerCertificate", certificateArn, err)
+	}
+
+	return result.ServerCertificate, nil
+}
+
+func getServerCertificateMetadata(svc iamiface.IAMAPI, certificateArn string) (*iam.ServerCertificateMetadata, error) {
+	serverCertificate, err := getServerCertificate(svc, certificateArn)
+	if err != nil {
+		return nil, err
+	}
+	return serverCertificate.ServerCertificateMetadata, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+const (
+	testCertificateArn      = "arn:aws:acm:us-west-2:123456789012:certificate/source"
+	testOtherCertificateArn = "arn:aws:acm:us-west-2:123456789012:certificate/other"
+)
+
+// addHTTPSListener gives the source ELB an HTTPS listener on port 443 that
+// uses certificateArn.
+func (tm *testMigration) addHTTPSListener(certificateArn string) {
+	source := tm.elb.loadBalancers["app"]
+	source.ListenerDescriptions = append(source.ListenerDescriptions, &elb.ListenerDescription{
+		Listener: &elb.Listener{
+			Protocol:         aws.String("HTTPS"),
+			LoadBalancerPort: aws.Int64(443),
+			InstanceProtocol: aws.String("HTTP"),
+			InstancePort:     aws.Int64(8080),
+			SSLCertificateId: aws.String(certificateArn),
+		},
+	})
+}
+
+func TestCheckCertificate(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.acm.addCertificate(testCertificateArn, "app.example.com", time.Now().Add(90*24*time.Hour))
+	tm.acm.addCertificate(testOtherCertificateArn, "app.example.com", time.Now().Add(90*24*time.Hour))
+	tm.acm.certificates[testOtherCertificateArn].Status = aws.String(acm.CertificateStatusPendingValidation)
+	tests := []struct {
+		certificateArn string
+		minDays        int64
+		want           string
+	}{
+		{testCertificateArn, 30, ""},
+		{testCertificateArn, 120, "within 120 days"},
+		{testOtherCertificateArn, 30, "is PENDING_VALIDATION, not ISSUED"},
+		{"arn:aws:acm:us-west-2:123456789012:certificate/missing", 30, "Could not find certificate"},
+	}
+	for _, test := range tests {
+		err := checkCertificate(tm.c, test.certificateArn, test.minDays)
+		if test.want == "" && err != nil || test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+			t.Errorf("checkCertificate(%s, %d) = %v, want %q", test.certificateArn, test.minDays, err, test.want)
+		}
+	}
+}
+
+func TestTranslateCertificate(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.acm.addCertificate(testCertificateArn, "app.example.com", time.Now().Add(90*24*time.Hour))
+	tm.acm.addCertificate(testOtherCertificateArn, "app.example.com", time.Now().Add(300*24*time.Hour))
+	tm.acm.addCertificate("arn:aws:acm:us-west-2:123456789012:certificate/shorter", "app.example.com", time.Now().Add(200*24*time.Hour))
+
+	if got, err := translateCertificate(tm.c, tm.opts, testCertificateArn); err != nil || got != testCertificateArn {
+		t.Errorf("translateCertificate = %q, %v, want the source's own", got, err)
+	}
+
+	tm.opts.defaults.CertificateLookup = certificateLookupACM
+	if got, err := translateCertificate(tm.c, tm.opts, testCertificateArn); err != nil || got != testOtherCertificateArn {
+		t.Errorf("translateCertificate with certificateLookup: acm = %q, %v, want the one valid the longest", got, err)
+	}
+
+	tm.opts.defaults.CertificateLookup = ""
+	tm.opts.targetRegion = "eu-west-1"
+	if _, err := translateCertificate(tm.c, tm.opts, testCertificateArn); err == nil || !strings.Contains(err.Error(), "not available in eu-west-1") {
+		t.Errorf("translateCertificate into another region = %v, want a request for a mapping", err)
+	}
+
+	mapped := "arn:aws:acm:eu-west-1:123456789012:certificate/mapped"
+	tm.opts.config.Environments["test"].Mappings.Certificates = map[string]string{testCertificateArn: mapped}
+	if got, err := translateCertificate(tm.c, tm.opts, testCertificateArn); err != nil || got != mapped {
+		t.Errorf("translateCertificate with a mapping = %q, %v, want %s", got, err, mapped)
+	}
+}
+
+func TestReplicateElbUsesLookedUpCertificate(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.addHTTPSListener(testCertificateArn)
+	tm.acm.addCertificate(testCertificateArn, "app.example.com", time.Now().Add(10*24*time.Hour))
+	tm.acm.addCertificate(testOtherCertificateArn, "app.example.com", time.Now().Add(300*24*time.Hour))
+	tm.opts.defaults.CertificateLookup = certificateLookupACM
+
+	if _, err := replicateElb(context.Background(), tm.c, tm.opts, "app", "app-r"); err != nil {
+		t.Fatalf("replicateElb: %v", err)
+	}
+
+	listener := tm.elb.loadBalancers["app-r"].ListenerDescriptions[1].Listener
+	if got := aws.StringValue(listener.SSLCertificateId); got != testOtherCertificateArn {
+		t.Errorf("certificate of listener 443 = %s, want %s", got, testOtherCertificateArn)
+	}
+}
+
+func TestReplicateElbRefusesExpiringCertificate(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.addHTTPSListener(testCertificateArn)
+	tm.acm.addCertificate(testCertificateArn, "app.example.com", time.Now().Add(10*24*time.Hour))
+
+	_, err := replicateElb(context.Background(), tm.c, tm.opts, "app", "app-r")
+	if err == nil || !strings.Contains(err.Error(), "within 30 days") {
+		t.Fatalf("replicateElb = %v, want a refusal of the expiring certificate", err)
+	}
+	if tm.hasLoadBalancer("app-r") {
+		t.Error("a replica was created with an expiring certificate")
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// options holds the values supplied on the command line for a single run.
+type options struct {
+	command     string
+	environment string
+	region      string
+	zone        string
+	cname       string
+	recordType  string
+	sourceElb   string
+	targetElb   string
+	targetType  string
+	configPath  string
+
+	// targetRegion and targetRoleArn create the replica in another region
+	// or account than the source ELB.
+	targetRegion  string
+	targetRoleArn string
+
+	// greenWeight is the weight the weight command gives green.
+	greenWeight int64
+	// allRecords also migrates every other record set of the account's
+	// hosted zones that points at the source ELB.
+	allRecords bool
+
+	// inventoryPath, concurrency and reportPath configure the batch command.
+	inventoryPath string
+	concurrency   int
+	reportPath    string
+
+	dryRun       bool
+	planJSONPath string
+	noRollback   bool
+	statePath    string
+
+	config   *migrationConfig
+	defaults environmentDefaults
+}
+
+// Kinds of load balancer a source ELB can be replicated to.
+const (
+	targetTypeClassic = "classic"
+	targetTypeALB     = "alb"
+	targetTypeNLB     = "nlb"
+)
+
+var targetTypes = []string{targetTypeClassic, targetTypeALB, targetTypeNLB}
+
+// isTargetTypeV2 reports whether replicas of targetType are elbv2 load
+// balancers.
+func isTargetTypeV2(targetType string) bool {
+	return targetType == targetTypeALB || targetType == targetTypeNLB
+}
+
+type command struct {
+	name        string
+	description string
+	run         func(ctx context.Context, c *clients, opts *options) error
+}
+
+var commands = []command{
+	{"migrate", "replicate the ELB, shift traffic to the replica and delete the original", runMigrate},
+	{"replicate", "replicate the source ELB to a new ELB", runReplicate},
+	{"shift", "create the green record set and shift traffic from blue to green", runShift},
+	{"delete", "delete the source ELB and its blue record set", runDelete},
+	{"plan", "show what a migration would do without changing anything", runPlan},
+	{"rollback", "shift traffic back to the source ELB and delete the replica", runRollback},
+	{"resume", "continue an interrupted migration from its state file", runResume},
+	{"weight", "give green --green-weight percent of the traffic and blue the rest", runWeight},
+	{"forward", "move green to the next weight of the shift strategy", runForward},
+	{"back", "move green to the previous weight of the shift strategy", runBack},
+	{"revert", "send all traffic back to blue, keeping green and the replica", runRevert},
+	{"batch", "plan and run the migrations listed in --inventory, several at a time", runBatch},
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: aws-elb-auto <command> [flags]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.description)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Run 'aws-elb-auto <command> -h' for the flags of a command.")
+}
+
+func parseOptions(args []string) (*options, error) {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return nil, fmt.Errorf("no command given")
+	}
+	if args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage(os.Stdout)
+		os.Exit(0)
+	}
+	if findCommand(args[0]) == nil {
+		usage(os.Stderr)
+		return nil, fmt.Errorf("unknown command %q", args[0])
+	}
+
+	opts := &options{command: args[0]}
+	flags := flag.NewFlagSet(opts.command, flag.ContinueOnError)
+	flags.StringVar(&opts.environment, "env", "", "environment used to look up security groups")
+	flags.StringVar(&opts.configPath, "config", "elb-auto.yaml", "YAML or JSON file with the environment configuration")
+	flags.StringVar(&opts.region, "region", os.Getenv("AWS_REGION"), "AWS region of the ELBs")
+	flags.StringVar(&opts.zone, "zone", "", "hosted zone name, e.g. test.example.com.")
+	flags.StringVar(&opts.cname, "cname", "", "CNAME record pointing at the source ELB, e.g. some-app.test.example.com")
+	flags.StringVar(&opts.recordType, "record-type", "", "type of the record set named by --cname: CNAME, or A or AAAA for alias records (detected when empty)")
+	flags.StringVar(&opts.sourceElb, "source-elb", "", "name of the source ELB (discovered from --cname when empty)")
+	flags.StringVar(&opts.targetElb, "target-elb", "", "name of the replica ELB (defaults to the source name with a -r suffix)")
+	flags.StringVar(&opts.targetType, "target-type", targetTypeClassic, "kind of load balancer to replicate to: "+strings.Join(targetTypes, ", "))
+	flags.StringVar(&opts.targetRegion, "target-region", "", "region to create the replica in (defaults to --region)")
+	flags.StringVar(&opts.targetRoleArn, "target-role-arn", "", "IAM role to assume for creating the replica in another account")
+	flags.BoolVar(&opts.allRecords, "all-records", false, "also move every other record set in the account's hosted zones that points at the source ELB")
+	flags.Int64Var(&opts.greenWeight, "green-weight", -1, "percent of the traffic the weight command sends to green")
+	flags.StringVar(&opts.inventoryPath, "inventory", "", "YAML or JSON file listing the migrations of the batch command")
+	flags.IntVar(&opts.concurrency, "concurrency", 4, "how many migrations the batch command runs at a time")
+	flags.StringVar(&opts.reportPath, "report", "", "also write the batch report as JSON to this file")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "print the ELB and Route53 changes instead of making them")
+	flags.StringVar(&opts.planJSONPath, "plan-json", "", "also write the dry run plan as JSON to this file")
+	flags.BoolVar(&opts.noRollback, "no-rollback", false, "leave the changes of a failed or interrupted run in place")
+	flags.StringVar(&opts.statePath, "state-file", "", "file the progress of a migration is saved to (defaults to one named after --cname)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	if opts.command == "plan" {
+		opts.dryRun = true
+	}
+	if opts.command == "resume" {
+		if err := opts.fromState(); err != nil {
+			return nil, err
+		}
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.statePath == "" {
+		opts.statePath = defaultStatePath(opts)
+	}
+	if opts.environment != "" {
+		config, err := loadConfig(opts.configPath)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := config.environment(opts.environment); err != nil {
+			return nil, err
+		}
+		opts.config = config
+	}
+	opts.defaults = opts.config.defaults(opts.environment)
+
+	return opts, nil
+}
+
+func (opts *options) validate() error {
+	if opts.region == "" {
+		return fmt.Errorf("--region is required")
+	}
+	// Route53 returns fully qualified names, so compare against those.
+	if opts.zone != "" && !strings.HasSuffix(opts.zone, ".") {
+		opts.zone += "."
+	}
+	if opts.cname != "" && !strings.HasSuffix(opts.cname, ".") {
+		opts.cname += "."
+	}
+	opts.recordType = strings.ToUpper(opts.recordType)
+	if opts.recordType != "" && !isSupportedRecordType(opts.recordType) {
+		return fmt.Errorf("--record-type must be one of %s", strings.Join(supportedRecordTypes, ", "))
+	}
+
+	validTargetType := false
+	for _, targetType := range targetTypes {
+		validTargetType = validTargetType || opts.targetType == targetType
+	}
+	if !validTargetType {
+		return fmt.Errorf("--target-type must be one of %s", strings.Join(targetTypes, ", "))
+	}
+
+	if opts.targetRoleArn != "" && !strings.HasPrefix(opts.targetRoleArn, "arn:") {
+		return fmt.Errorf("--target-role-arn must be a role ARN, got %q", opts.targetRoleArn)
+	}
+
+	needsRecord := opts.command != "replicate" && opts.command != "batch"
+	needsEnvironment := opts.command == "migrate" || opts.command == "replicate" || opts.command == "plan" || opts.command == "resume" || opts.command == "batch"
+	if needsRecord && (opts.zone == "" || opts.cname == "") {
+		return fmt.Errorf("%s requires --zone and --cname", opts.command)
+	}
+	if opts.command == "replicate" && opts.sourceElb == "" && (opts.zone == "" || opts.cname == "") {
+		return fmt.Errorf("replicate requires --source-elb or --zone and --cname")
+	}
+	if needsEnvironment && opts.environment == "" {
+		return fmt.Errorf("%s requires --env", opts.command)
+	}
+	if opts.command == "batch" && opts.inventoryPath == "" {
+		return fmt.Errorf("batch requires --inventory")
+	}
+	if opts.concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	if opts.command == "weight" && (opts.greenWeight < 0 || opts.greenWeight > 100) {
+		return fmt.Errorf("weight requires --green-weight between 0 and 100")
+	}
+
+	return nil
+}
+
+// fromState fills in the options of the migration being resumed from its
+// state file, found by --state-file or by --cname.
+func (opts *options) fromState() error {
+	if opts.statePath == "" {
+		if opts.cname == "" {
+			return fmt.Errorf("resume requires --state-file or --cname")
+		}
+		opts.statePath = defaultStatePath(opts)
+	}
+	state, err := loadState(opts.statePath)
+	if err != nil {
+		return err
+	}
+	opts.environment = state.Environment
+	opts.region = state.Region
+	opts.zone = state.Zone
+	opts.cname = state.CNAME
+	opts.recordType = state.RecordType
+	opts.sourceElb = state.SourceElb
+	opts.targetElb = state.ReplicaElb
+	opts.targetType = state.TargetType
+	opts.targetRegion = state.TargetRegion
+	opts.targetRoleArn = state.TargetRoleArn
+	opts.allRecords = state.AllRecords
+	if opts.targetType == "" {
+		opts.targetType = targetTypeClassic
+	}
+	return nil
+}
+
+// crossTarget reports whether the replica is created in another region or
+// account than the source ELB.
+func (opts *options) crossTarget() bool {
+	return opts.replicaRegion() != opts.region || opts.targetRoleArn != ""
+}
+
+// replicaRegion returns the region the replica is created in.
+func (opts *options) replicaRegion() string {
+	if opts.targetRegion != "" {
+		return opts.targetRegion
+	}
+	return opts.region
+}
+
+// replicaName returns the name of the replica ELB for the given source ELB.
+func (opts *options) replicaName(sourceElbName string) string {
+	if opts.targetElb != "" {
+		return opts.targetElb
+	}
+	return sourceElbName + "-r"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestConfig writes a configuration with the environment test and
+// returns its path.
+func writeTestConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "elb-auto.yaml")
+	data := "version: 1\nenvironments:\n  test:\n    regions:\n      us-west-2:\n        vpc-1: [sg-1]\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseOptions(t *testing.T) {
+	config := writeTestConfig(t)
+	opts, err := parseOptions([]string{"migrate", "--env", "test", "--config", config, "--region", "us-west-2",
+		"--zone", "test.example.com", "--cname", "app.test.example.com", "--record-type", "a",
+		"--target-type", "alb", "--all-records"})
+	if err != nil {
+		t.Fatalf("parseOptions: %v", err)
+	}
+	if opts.zone != testZone || opts.cname != testCNAME {
+		t.Errorf("zone, cname = %q, %q, want them fully qualified", opts.zone, opts.cname)
+	}
+	if opts.recordType != "A" || opts.targetType != targetTypeALB || !opts.allRecords {
+		t.Errorf("record type, target type, all records = %q, %q, %v, want A, alb, true", opts.recordType, opts.targetType, opts.allRecords)
+	}
+	if opts.statePath != "elb-auto-app.test.example.com.state.json" {
+		t.Errorf("state path = %q, want one named after the CNAME", opts.statePath)
+	}
+	if opts.config == nil || opts.defaults.SecurityGroups != securityGroupsConfig {
+		t.Errorf("config = %v, defaults = %+v, want the loaded config and its defaults", opts.config, opts.defaults)
+	}
+
+	opts, err = parseOptions([]string{"plan", "--env", "test", "--config", config, "--region", "us-west-2",
+		"--zone", testZone, "--cname", testCNAME})
+	if err != nil || !opts.dryRun {
+		t.Errorf("plan = %+v, %v, want a dry run", opts, err)
+	}
+}
+
+func TestParseOptionsRejected(t *testing.T) {
+	config := writeTestConfig(t)
+	record := []string{"--region", "us-west-2", "--zone", testZone, "--cname", testCNAME}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "no command given"},
+		{[]string{"unknown"}, `unknown command "unknown"`},
+		{[]string{"migrate", "--env", "test", "--config", config, "--zone", testZone, "--cname", testCNAME, "--region", ""}, "--region is required"},
+		{append([]string{"migrate", "--env", "test", "--config", config}, record...), ""},
+		{append([]string{"migrate", "--env", "test", "--config", config, "extra"}, record...), "unexpected arguments: extra"},
+		{append([]string{"migrate"}, record...), "migrate requires --env"},
+		{[]string{"migrate", "--env", "test", "--config", config, "--region", "us-west-2", "--zone", testZone}, "migrate requires --zone and --cname"},
+		{[]string{"replicate", "--env", "test", "--config", config, "--region", "us-west-2"}, "replicate requires --source-elb or --zone and --cname"},
+		{[]string{"replicate", "--env", "test", "--config", config, "--region", "us-west-2", "--source-elb", "app"}, ""},
+		{append([]string{"migrate", "--env", "test", "--config", config, "--record-type", "MX"}, record...), "--record-type must be one of"},
+		{append([]string{"migrate", "--env", "test", "--config", config, "--target-type", "gwlb"}, record...), "--target-type must be one of"},
+		{append([]string{"migrate", "--env", "test", "--config", config, "--target-role-arn", "role/deploy"}, record...), "--target-role-arn must be a role ARN"},
+		{append([]string{"migrate", "--env", "other", "--config", config}, record...), "other"},
+		{append([]string{"migrate", "--env", "test", "--config", filepath.Join(t.TempDir(), "missing.yaml")}, record...), "reading config"},
+		{append([]string{"weight"}, record...), "weight requires --green-weight between 0 and 100"},
+		{append([]string{"weight", "--green-weight", "101"}, record...), "weight requires --green-weight between 0 and 100"},
+		{append([]string{"weight", "--green-weight", "30"}, record...), ""},
+		{[]string{"batch", "--env", "test", "--config", config, "--region", "us-west-2"}, "batch requires --inventory"},
+		{[]string{"batch", "--env", "test", "--config", config, "--region", "us-west-2", "--inventory", "inventory.yaml", "--concurrency", "0"}, "--concurrency must be at least 1"},
+		{[]string{"resume", "--region", "us-west-2"}, "resume requires --state-file or --cname"},
+	}
+	for _, test := range tests {
+		_, err := parseOptions(test.args)
+		if test.want == "" && err != nil || test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+			t.Errorf("parseOptions(%q) = %v, want %q", test.args, err, test.want)
+		}
+	}
+}
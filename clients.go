@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+)
+
+// clients are the AWS services a migration talks to. Every step receives
+// them instead of creating its own session, so they can be swapped for
+// fakes.
+type clients struct {
+	elb     elbiface.ELBAPI
+	elbv2   elbv2iface.ELBV2API
+	route53 route53iface.Route53API
+	ec2     ec2iface.EC2API
+	acm     acmiface.ACMAPI
+	iam     iamiface.IAMAPI
+
+	cloudwatch cloudwatchiface.CloudWatchAPI
+
+	// target talks to the account and region the replica is created in when
+	// they differ from the source's.
+	target *clients
+}
+
+func newClients(opts *options) *clients {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(opts.region)}))
+	c := clientsFor(sess)
+	if opts.crossTarget() {
+		config := &aws.Config{Region: aws.String(opts.replicaRegion())}
+		if opts.targetRoleArn != "" {
+			config.Credentials = stscreds.NewCredentials(sess, opts.targetRoleArn)
+		}
+		c.target = clientsFor(session.Must(session.NewSession(config)))
+	}
+	return c
+}
+
+func clientsFor(sess *session.Session) *clients {
+	return &clients{
+		elb:     elb.New(sess),
+		elbv2:   elbv2.New(sess),
+		route53: route53.New(sess),
+		ec2:     ec2.New(sess),
+		acm:     acm.New(sess),
+		iam:     iam.New(sess),
+
+		cloudwatch: cloudwatch.New(sess),
+	}
+}
+
+// forReplica returns the clients that manage the replica.
+func (c *clients) forReplica() *clients {
+	if c.target != nil {
+		return c.target
+	}
+	return c
+}
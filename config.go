@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"gopkg.in/yaml.v2"
+)
+
+// configVersion is the only configuration file version this build understands.
+const configVersion = 1
+
+// migrationConfig is the environment -> region -> VPC -> security group map
+// that replicas are created with, loaded from a YAML or JSON file.
+type migrationConfig struct {
+	Version      int                           `yaml:"version"`
+	Environments map[string]*environmentConfig `yaml:"environments"`
+}
+
+type environmentConfig struct {
+	Defaults environmentDefaults `yaml:"defaults"`
+	// Regions maps a region to the security groups to use for each VPC in it.
+	Regions map[string]map[string][]string `yaml:"regions"`
+	// Mappings translate the source's resources when the replica is created
+	// in another region or account.
+	Mappings targetMappings `yaml:"mappings"`
+}
+
+// targetMappings map the ids of the source's resources to the ids of their
+// counterparts where the replica is created.
+type targetMappings struct {
+	VPCs           map[string]string `yaml:"vpcs"`
+	Subnets        map[string]string `yaml:"subnets"`
+	SecurityGroups map[string]string `yaml:"securityGroups"`
+	Certificates   map[string]string `yaml:"certificates"`
+	Instances      map[string]string `yaml:"instances"`
+}
+
+type environmentDefaults struct {
+	// Scheme of the replica: keep for the source's, internal or
+	// internet-facing.
+	Scheme string `yaml:"scheme"`
+	// SecurityGroups chooses the replica's security groups: config for the
+	// ones configured for the source's VPC under regions, keep for the
+	// source's. AddSecurityGroups and RemoveSecurityGroups are applied to
+	// either.
+	SecurityGroups       string   `yaml:"securityGroups"`
+	AddSecurityGroups    []string `yaml:"addSecurityGroups"`
+	RemoveSecurityGroups []string `yaml:"removeSecurityGroups"`
+	// SubnetMap names the subnet of the replica in each availability zone.
+	// SubnetTags selects the subnets of the source's VPC that carry all of
+	// the tags instead. Without either the replica uses the source's
+	// subnets.
+	SubnetMap  map[string]string `yaml:"subnetMap"`
+	SubnetTags map[string]string `yaml:"subnetTags"`
+	// StickinessDuration replaces the cookie expiration of the source's load
+	// balancer cookie stickiness policies when set; otherwise the replica
+	// keeps the source's.
+	StickinessDuration int64 `yaml:"stickinessDuration"`
+	// SSLPolicyName replaces the security policy of the source's listeners
+	// when set; otherwise the replica keeps the source's.
+	SSLPolicyName string `yaml:"sslPolicyName"`
+	// CertificateLookup set to acm finds the replica's certificates in ACM
+	// by the domain name of the source's, for those mappings.certificates
+	// does not name.
+	CertificateLookup string `yaml:"certificateLookup"`
+	// CertificateMinDays is how many days a certificate of the replica has
+	// to remain valid for.
+	CertificateMinDays int64 `yaml:"certificateMinDays"`
+	// ShiftStrategy decides the weights green is given in turn: linear in
+	// steps of BleedStep, exponential, the ShiftSteps list, or canary
+	// with CanaryWeight and then all. Each weight is kept for BakeTime
+	// seconds, but at least the TTL of the record set. BleedStep and
+	// CanaryWeight are nil when not set.
+	ShiftStrategy string  `yaml:"shiftStrategy"`
+	BleedStep     *int64  `yaml:"bleedStep"`
+	ShiftSteps    []int64 `yaml:"shiftSteps"`
+	CanaryWeight  *int64  `yaml:"canaryWeight"`
+	BakeTime      int64   `yaml:"bakeTime"`
+	// OnUnhealthy is what shifting does when the replica becomes unhealthy:
+	// halt, hold or revert. It is unhealthy when fewer than
+	// MinHealthyPercent of its instances are healthy, or when its 5XX
+	// responses or average latency in seconds over the last five minutes
+	// exceed Max5XX or MaxLatency, which are not checked when 0.
+	// MinHealthyPercent is nil when not set, as 0 is a valid setting.
+	OnUnhealthy       string  `yaml:"onUnhealthy"`
+	MinHealthyPercent *int64  `yaml:"minHealthyPercent"`
+	Max5XX            int64   `yaml:"max5xx"`
+	MaxLatency        float64 `yaml:"maxLatency"`
+	// HoldTimeout is how many seconds hold waits for the replica to
+	// recover before it halts.
+	HoldTimeout int64 `yaml:"holdTimeout"`
+}
+
+// builtinDefaults are used for any default an environment does not set.
+var builtinDefaults = environmentDefaults{
+	Scheme:             schemeInternetFacing,
+	SecurityGroups:     securityGroupsConfig,
+	CertificateMinDays: 30,
+	ShiftStrategy:      shiftLinear,
+	BleedStep:          aws.Int64(20),
+	CanaryWeight:       aws.Int64(5),
+	OnUnhealthy:        onUnhealthyHalt,
+	MinHealthyPercent:  aws.Int64(100),
+	HoldTimeout:        600,
+}
+
+// loadConfig reads and validates the configuration file at path. JSON files
+// are accepted as well since JSON is valid YAML.
+func loadConfig(path string) (*migrationConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %v", err)
+	}
+
+	config := &migrationConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %v", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+func (config *migrationConfig) validate() error {
+	if config.Version != configVersion {
+		return fmt.Errorf("version: expected %d, got %d", configVersion, config.Version)
+	}
+	if len(config.Environments) == 0 {
+		return fmt.Errorf("environments: at least one environment is required")
+	}
+
+	for _, env := range sortedKeys(config.Environments) {
+		envConfig := config.Environments[env]
+		path := "environments." + env
+		if envConfig == nil {
+			return fmt.Errorf("%s: empty environment", path)
+		}
+		if err := envConfig.Defaults.validate(); err != nil {
+			return fmt.Errorf("%s.defaults.%v", path, err)
+		}
+		if err := envConfig.Mappings.validate(); err != nil {
+			return fmt.Errorf("%s.mappings.%v", path, err)
+		}
+		for region, vpcs := range envConfig.Regions {
+			for vpc, securityGroups := range vpcs {
+				vpcPath := fmt.Sprintf("%s.regions.%s.%s", path, region, vpc)
+				if !strings.HasPrefix(vpc, "vpc-") {
+					return fmt.Errorf("%s: %q is not a VPC id", vpcPath, vpc)
+				}
+				for _, sg := range securityGroups {
+					if !strings.HasPrefix(sg, "sg-") {
+						return fmt.Errorf("%s: %q is not a security group id", vpcPath, sg)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (mappings targetMappings) validate() error {
+	prefixes := []struct {
+		name    string
+		mapping map[string]string
+		prefix  string
+	}{
+		{"vpcs", mappings.VPCs, "vpc-"},
+		{"subnets", mappings.Subnets, "subnet-"},
+		{"securityGroups", mappings.SecurityGroups, "sg-"},
+		{"certificates", mappings.Certificates, "arn:"},
+		{"instances", mappings.Instances, "i-"},
+	}
+	for _, p := range prefixes {
+		for from, to := range p.mapping {
+			if !strings.HasPrefix(from, p.prefix) || !strings.HasPrefix(to, p.prefix) {
+				return fmt.Errorf("%s: %s -> %s must both start with %s", p.name, from, to, p.prefix)
+			}
+		}
+	}
+	return nil
+}
+
+func (defaults environmentDefaults) validate() error {
+	switch defaults.Scheme {
+	case "", schemeKeep, schemeInternal, schemeInternetFacing:
+	default:
+		return fmt.Errorf("scheme: must be keep, internal or internet-facing, got %q", defaults.Scheme)
+	}
+	switch defaults.SecurityGroups {
+	case "", securityGroupsConfig, securityGroupsKeep:
+	default:
+		return fmt.Errorf("securityGroups: must be config or keep, got %q", defaults.SecurityGroups)
+	}
+	for _, sg := range append(append([]string{}, defaults.AddSecurityGroups...), defaults.RemoveSecurityGroups...) {
+		if !strings.HasPrefix(sg, "sg-") {
+			return fmt.Errorf("addSecurityGroups, removeSecurityGroups: %q is not a security group id", sg)
+		}
+	}
+	if len(defaults.SubnetMap) > 0 && len(defaults.SubnetTags) > 0 {
+		return fmt.Errorf("subnetMap, subnetTags: only one of them can be set")
+	}
+	for zone, subnet := range defaults.SubnetMap {
+		if !strings.HasPrefix(subnet, "subnet-") {
+			return fmt.Errorf("subnetMap.%s: %q is not a subnet id", zone, subnet)
+		}
+	}
+	switch defaults.CertificateLookup {
+	case "", certificateLookupACM:
+	default:
+		return fmt.Errorf("certificateLookup: must be acm or left out, got %q", defaults.CertificateLookup)
+	}
+	if defaults.CertificateMinDays < 0 {
+		return fmt.Errorf("certificateMinDays: must not be negative")
+	}
+	if defaults.StickinessDuration < 0 {
+		return fmt.Errorf("stickinessDuration: must not be negative")
+	}
+	switch defaults.OnUnhealthy {
+	case "", onUnhealthyHalt, onUnhealthyHold, onUnhealthyRevert:
+	default:
+		return fmt.Errorf("onUnhealthy: must be one of %s, got %q", strings.Join(onUnhealthyActions, ", "), defaults.OnUnhealthy)
+	}
+	if percent := defaults.MinHealthyPercent; percent != nil && (*percent < 0 || *percent > 100) {
+		return fmt.Errorf("minHealthyPercent: must be between 0 and 100, got %d", *percent)
+	}
+	if defaults.Max5XX < 0 || defaults.MaxLatency < 0 || defaults.HoldTimeout < 0 {
+		return fmt.Errorf("max5xx, maxLatency, holdTimeout: must not be negative")
+	}
+	if step := defaults.BleedStep; step != nil && (*step < 1 || *step > 100) {
+		return fmt.Errorf("bleedStep: must be between 1 and 100, got %d", *step)
+	}
+	switch defaults.ShiftStrategy {
+	case "", shiftLinear, shiftExponential, shiftCanary:
+	case shiftSteps:
+		if len(defaults.ShiftSteps) == 0 {
+			return fmt.Errorf("shiftSteps: required by shiftStrategy steps")
+		}
+	default:
+		return fmt.Errorf("shiftStrategy: must be one of %s, got %q", strings.Join(shiftStrategies, ", "), defaults.ShiftStrategy)
+	}
+	if err := validateShiftSteps(defaults.ShiftSteps); err != nil {
+		return fmt.Errorf("shiftSteps: %v", err)
+	}
+	if weight := defaults.CanaryWeight; weight != nil && (*weight < 1 || *weight > 99) {
+		return fmt.Errorf("canaryWeight: must be between 1 and 99, got %d", *weight)
+	}
+	if defaults.BakeTime < 0 {
+		return fmt.Errorf("bakeTime: must not be negative")
+	}
+
+	return nil
+}
+
+func (config *migrationConfig) environment(env string) (*environmentConfig, error) {
+	envConfig, ok := config.Environments[env]
+	if !ok {
+		return nil, fmt.Errorf("environment %q is not configured, known environments: %s", env, strings.Join(sortedKeys(config.Environments), ", "))
+	}
+	return envConfig, nil
+}
+
+// defaults returns the defaults of env with unset values filled in from
+// builtinDefaults.
+func (config *migrationConfig) defaults(env string) environmentDefaults {
+	defaults := builtinDefaults
+	if config == nil {
+		return defaults
+	}
+	envConfig, ok := config.Environments[env]
+	if !ok {
+		return defaults
+	}
+	if envConfig.Defaults.Scheme != "" {
+		defaults.Scheme = envConfig.Defaults.Scheme
+	}
+	if envConfig.Defaults.SecurityGroups != "" {
+		defaults.SecurityGroups = envConfig.Defaults.SecurityGroups
+	}
+	if envConfig.Defaults.AddSecurityGroups != nil {
+		defaults.AddSecurityGroups = envConfig.Defaults.AddSecurityGroups
+	}
+	if envConfig.Defaults.RemoveSecurityGroups != nil {
+		defaults.RemoveSecurityGroups = envConfig.Defaults.RemoveSecurityGroups
+	}
+	if envConfig.Defaults.SubnetMap != nil {
+		defaults.SubnetMap = envConfig.Defaults.SubnetMap
+	}
+	if envConfig.Defaults.SubnetTags != nil {
+		defaults.SubnetTags = envConfig.Defaults.SubnetTags
+	}
+	if envConfig.Defaults.StickinessDuration != 0 {
+		defaults.StickinessDuration = envConfig.Defaults.StickinessDuration
+	}
+	if envConfig.Defaults.SSLPolicyName != "" {
+		defaults.SSLPolicyName = envConfig.Defaults.SSLPolicyName
+	}
+	if envConfig.Defaults.CertificateLookup != "" {
+		defaults.CertificateLookup = envConfig.Defaults.CertificateLookup
+	}
+	if envConfig.Defaults.CertificateMinDays != 0 {
+		defaults.CertificateMinDays = envConfig.Defaults.CertificateMinDays
+	}
+	if envConfig.Defaults.ShiftStrategy != "" {
+		defaults.ShiftStrategy = envConfig.Defaults.ShiftStrategy
+	}
+	if envConfig.Defaults.BleedStep != nil {
+		defaults.BleedStep = envConfig.Defaults.BleedStep
+	}
+	if envConfig.Defaults.ShiftSteps != nil {
+		defaults.ShiftSteps = envConfig.Defaults.ShiftSteps
+	}
+	if envConfig.Defaults.CanaryWeight != nil {
+		defaults.CanaryWeight = envConfig.Defaults.CanaryWeight
+	}
+	if envConfig.Defaults.BakeTime != 0 {
+		defaults.BakeTime = envConfig.Defaults.BakeTime
+	}
+	if envConfig.Defaults.OnUnhealthy != "" {
+		defaults.OnUnhealthy = envConfig.Defaults.OnUnhealthy
+	}
+	if envConfig.Defaults.MinHealthyPercent != nil {
+		defaults.MinHealthyPercent = envConfig.Defaults.MinHealthyPercent
+	}
+	if envConfig.Defaults.Max5XX != 0 {
+		defaults.Max5XX = envConfig.Defaults.Max5XX
+	}
+	if envConfig.Defaults.MaxLatency != 0 {
+		defaults.MaxLatency = envConfig.Defaults.MaxLatency
+	}
+	if envConfig.Defaults.HoldTimeout != 0 {
+		defaults.HoldTimeout = envConfig.Defaults.HoldTimeout
+	}
+	return defaults
+}
+
+// mappings returns the target mappings of env.
+func (config *migrationConfig) mappings(env string) targetMappings {
+	if config == nil || config.Environments[env] == nil {
+		return targetMappings{}
+	}
+	return config.Environments[env].Mappings
+}
+
+// securityGroups returns the security groups configured for vpc, or an error
+// naming the missing piece of configuration.
+func (config *migrationConfig) securityGroups(env string, region string, vpc string) ([]string, error) {
+	envConfig, err := config.environment(env)
+	if err != nil {
+		return nil, err
+	}
+	vpcs, ok := envConfig.Regions[region]
+	if !ok {
+		return nil, fmt.Errorf("region %s is not configured for environment %s", region, env)
+	}
+	securityGroups := vpcs[vpc]
+	if len(securityGroups) == 0 {
+		return nil, fmt.Errorf("no security groups configured for %s in environment %s, region %s", vpc, env, region)
+	}
+	return securityGroups, nil
+}
+
+func sortedKeys(m map[string]*environmentConfig) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"gopkg.in/yaml.v2"
+)
+
+// parseTestConfig parses and validates a configuration whose test
+// environment has the given defaults.
+func parseTestConfig(defaults string) (*migrationConfig, error) {
+	data := "version: 1\nenvironments:\n  test:\n    defaults: {" + defaults + "}\n"
+	config := &migrationConfig{}
+	if err := yaml.UnmarshalStrict([]byte(data), config); err != nil {
+		return nil, err
+	}
+	return config, config.validate()
+}
+
+func TestConfigDefaults(t *testing.T) {
+	tests := []struct {
+		defaults          string
+		bleedStep         int64
+		canaryWeight      int64
+		minHealthyPercent int64
+	}{
+		{"", 20, 5, 100},
+		{"bleedStep: 100, canaryWeight: 1, minHealthyPercent: 50", 100, 1, 50},
+		{"minHealthyPercent: 0", 20, 5, 0},
+	}
+	for _, test := range tests {
+		config, err := parseTestConfig(test.defaults)
+		if err != nil {
+			t.Errorf("config with {%s}: %v", test.defaults, err)
+			continue
+		}
+		defaults := config.defaults("test")
+		if aws.Int64Value(defaults.BleedStep) != test.bleedStep ||
+			aws.Int64Value(defaults.CanaryWeight) != test.canaryWeight ||
+			aws.Int64Value(defaults.MinHealthyPercent) != test.minHealthyPercent {
+			t.Errorf("defaults of {%s} = bleedStep %d, canaryWeight %d, minHealthyPercent %d, want %d, %d, %d", test.defaults,
+				aws.Int64Value(defaults.BleedStep), aws.Int64Value(defaults.CanaryWeight), aws.Int64Value(defaults.MinHealthyPercent),
+				test.bleedStep, test.canaryWeight, test.minHealthyPercent)
+		}
+	}
+}
+
+func TestConfigRejectsOutOfRangeDefaults(t *testing.T) {
+	tests := []struct {
+		defaults string
+		want     string
+	}{
+		{"bleedStep: 0", "bleedStep: must be between 1 and 100, got 0"},
+		{"bleedStep: 101", "bleedStep: must be between 1 and 100, got 101"},
+		{"canaryWeight: 0", "canaryWeight: must be between 1 and 99, got 0"},
+		{"canaryWeight: 100", "canaryWeight: must be between 1 and 99, got 100"},
+		{"minHealthyPercent: -1", "minHealthyPercent: must be between 0 and 100, got -1"},
+		{"minHealthyPercent: 101", "minHealthyPercent: must be between 0 and 100, got 101"},
+	}
+	for _, test := range tests {
+		if _, err := parseTestConfig(test.defaults); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("config with {%s} = %v, want %q", test.defaults, err, test.want)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"config.yaml", "version: 1\nenvironments:\n  test:\n    regions:\n      us-west-2:\n        vpc-1: [sg-1, sg-2]\n", ""},
+		{"config.json", `{"version": 1, "environments": {"test": {"regions": {"us-west-2": {"vpc-1": ["sg-1", "sg-2"]}}}}}`, ""},
+		{"unknown-key.yaml", "version: 1\nenvironments:\n  test:\n    region: us-west-2\n", "field region not found"},
+		{"version.yaml", "version: 2\nenvironments:\n  test: {}\n", "version: expected 1, got 2"},
+		{"no-environments.yaml", "version: 1\n", "at least one environment is required"},
+		{"empty-environment.yaml", "version: 1\nenvironments:\n  test:\n", "environments.test: empty environment"},
+		{"vpc.yaml", "version: 1\nenvironments:\n  test:\n    regions:\n      us-west-2:\n        default: [sg-1]\n", `environments.test.regions.us-west-2.default: "default" is not a VPC id`},
+		{"security-group.yaml", "version: 1\nenvironments:\n  test:\n    regions:\n      us-west-2:\n        vpc-1: [web]\n", `"web" is not a security group id`},
+		{"defaults.yaml", "version: 1\nenvironments:\n  test:\n    defaults:\n      securityGroups: all\n", "environments.test.defaults.securityGroups: must be config or keep"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := ioutil.WriteFile(path, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := loadConfig(path)
+		if test.want != "" {
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("loadConfig(%s) = %v, want %q", test.name, err, test.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadConfig(%s): %v", test.name, err)
+			continue
+		}
+		if got, err := config.securityGroups("test", "us-west-2", "vpc-1"); err != nil || !reflect.DeepEqual(got, []string{"sg-1", "sg-2"}) {
+			t.Errorf("security groups of %s = %v, %v, want sg-1 and sg-2", test.name, got, err)
+		}
+	}
+}
+
+func TestConfigSecurityGroups(t *testing.T) {
+	config, err := parseTestConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Environments["test"].Regions = map[string]map[string][]string{"us-west-2": {"vpc-1": {"sg-1"}}}
+	tests := []struct {
+		env, region, vpc string
+		want             string
+	}{
+		{"test", "us-west-2", "vpc-1", ""},
+		{"other", "us-west-2", "vpc-1", `environment "other" is not configured, known environments: test`},
+		{"test", "eu-west-1", "vpc-1", "region eu-west-1 is not configured for environment test"},
+		{"test", "us-west-2", "vpc-2", "no security groups configured for vpc-2"},
+	}
+	for _, test := range tests {
+		_, err := config.securityGroups(test.env, test.region, test.vpc)
+		if test.want == "" && err != nil || test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+			t.Errorf("securityGroups(%s, %s, %s) = %v, want %q", test.env, test.region, test.vpc, err, test.want)
+		}
+	}
+}
+
+func TestConfigDefaultsMerge(t *testing.T) {
+	config, err := parseTestConfig("securityGroups: keep, addSecurityGroups: [sg-2], sslPolicyName: ELBSecurityPolicy-TLS-1-2-2017-01, bakeTime: 120")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := config.defaults("test")
+	if defaults.SecurityGroups != securityGroupsKeep || !reflect.DeepEqual(defaults.AddSecurityGroups, []string{"sg-2"}) {
+		t.Errorf("security groups = %s +%v, want keep +[sg-2]", defaults.SecurityGroups, defaults.AddSecurityGroups)
+	}
+	if defaults.SSLPolicyName != "ELBSecurityPolicy-TLS-1-2-2017-01" || defaults.BakeTime != 120 {
+		t.Errorf("sslPolicyName, bakeTime = %q, %d, want the environment's", defaults.SSLPolicyName, defaults.BakeTime)
+	}
+	// Whatever the environment leaves out comes from the built-in defaults.
+	if defaults.CertificateMinDays != 30 || defaults.ShiftStrategy != shiftLinear || defaults.OnUnhealthy != onUnhealthyHalt {
+		t.Errorf("certificateMinDays, shiftStrategy, onUnhealthy = %d, %s, %s, want the built-in defaults",
+			defaults.CertificateMinDays, defaults.ShiftStrategy, defaults.OnUnhealthy)
+	}
+	if defaults.Scheme != schemeInternetFacing {
+		t.Errorf("scheme = %q, want internet-facing unless the environment opts into keep", defaults.Scheme)
+	}
+	config, err = parseTestConfig("scheme: keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheme := config.defaults("test").Scheme; scheme != schemeKeep {
+		t.Errorf("scheme = %q, want the environment's keep", scheme)
+	}
+	if got := config.defaults("other"); !reflect.DeepEqual(got, builtinDefaults) {
+		t.Errorf("defaults of an unknown environment = %+v, want the built-in defaults", got)
+	}
+}
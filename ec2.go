@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// describeSecurityGroups returns the security groups with the given ids. An
+// id that does not exist is reported by name.
+func describeSecurityGroups(svc ec2iface.EC2API, groupIds []string) ([]*ec2.SecurityGroup, error) {
+	input := &ec2.DescribeSecurityGroupsInput{
+		GroupIds: aws.StringSlice(groupIds),
+	}
+
+	var securityGroups []*ec2.SecurityGroup
+	err := svc.DescribeSecurityGroupsPages(input, func(page *ec2.DescribeSecurityGroupsOutput, lastPage bool) bool {
+		securityGroups = append(securityGroups, page.SecurityGroups...)
+		return true
+	})
+	if err != nil {
+		if isAWSErrorCode(err, "InvalidGroup.NotFound") {
+			return nil, fmt.Errorf("security groups %s: %v", strings.Join(groupIds, ", "), err)
+		}
+		return nil, newAWSError("DescribeSecurityGroups", strings.Join(groupIds, ","), err)
+	}
+
+	return securityGroups, nil
+}
+
+func describeSubnets(svc ec2iface.EC2API, input *ec2.DescribeSubnetsInput) ([]*ec2.Subnet, error) {
+	var subnets []*ec2.Subnet
+	err := svc.DescribeSubnetsPages(input, func(page *ec2.DescribeSubnetsOutput, lastPage bool) bool {
+		subnets = append(subnets, page.Subnets...)
+		return true
+	})
+	if err != nil {
+		return nil, newAWSError("DescribeSubnets", strings.Join(aws.StringValueSlice(input.SubnetIds), ","), err)
+	}
+
+	return subnets, nil
+}
+
+// describeInstanceZones returns the availability zones the given instances
+// run in, sorted.
+func describeInstanceZones(svc ec2iface.EC2API, instanceIds []string) ([]string, error) {
+	if len(instanceIds) == 0 {
+		return nil, nil
+	}
+	input := &ec2.DescribeInstancesInput{
+		InstanceIds: aws.StringSlice(instanceIds),
+	}
+
+	zones := map[string]bool{}
+	err := svc.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				if instance.Placement != nil {
+					zones[aws.StringValue(instance.Placement.AvailabilityZone)] = true
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, newAWSError("DescribeInstances", strings.Join(instanceIds, ","), err)
+	}
+
+	sorted := make([]string, 0, len(zones))
+	for zone := range zones {
+		sorted = append(sorted, zone)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
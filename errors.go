@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+var (
+	errHostedZoneNotFound   = errors.New("hosted zone not found")
+	errRecordSetNotFound    = errors.New("record set not found")
+	errLoadBalancerNotFound = errors.New("load balancer not found")
+	errAborted              = errors.New("stopped by user")
+	errShiftHalted          = errors.New("shift halted")
+	errShiftReverted        = errors.New("shift reverted")
+)
+
+// awsError wraps an error returned by an AWS API call with the operation
+// that failed and the resource it was made against.
+type awsError struct {
+	Operation string
+	Resource  string
+	Err       error
+}
+
+func newAWSError(operation string, resource string, err error) error {
+	return &awsError{Operation: operation, Resource: resource, Err: err}
+}
+
+func (e *awsError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Operation, e.Resource, e.Err)
+}
+
+func (e *awsError) Unwrap() error {
+	return e.Err
+}
+
+// Code returns the AWS error code, or "" if the error did not come from AWS.
+func (e *awsError) Code() string {
+	var aerr awserr.Error
+	if errors.As(e.Err, &aerr) {
+		return aerr.Code()
+	}
+	return ""
+}
+
+// isAWSErrorCode reports whether err was caused by an AWS error with code.
+func isAWSErrorCode(err error, code string) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == code
+}
+
+// stepError is returned by a migration when one of its steps fails.
+type stepError struct {
+	Step string
+	Err  error
+}
+
+func (e *stepError) Error() string {
+	return fmt.Sprintf("step %s failed: %v", e.Step, e.Err)
+}
+
+func (e *stepError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,1098 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+)
+
+// fakeELB is an in-memory implementation of the parts of elbiface.ELBAPI the
+// migration uses. Calls to any other method panic through the nil embedded
+// interface.
+type fakeELB struct {
+	elbiface.ELBAPI
+
+	mu            sync.Mutex
+	region        string
+	loadBalancers map[string]*elb.LoadBalancerDescription
+	policies      map[string][]*elb.PolicyDescription
+	tags          map[string][]*elb.Tag
+	attributes    map[string]*elb.LoadBalancerAttributes
+	// instanceStates overrides the InService state reported for an instance.
+	instanceStates map[string]string
+}
+
+func newFakeELB(region string) *fakeELB {
+	return &fakeELB{
+		region:         region,
+		loadBalancers:  map[string]*elb.LoadBalancerDescription{},
+		policies:       map[string][]*elb.PolicyDescription{},
+		tags:           map[string][]*elb.Tag{},
+		attributes:     map[string]*elb.LoadBalancerAttributes{},
+		instanceStates: map[string]string{},
+	}
+}
+
+// addLoadBalancer seeds the fake with an existing load balancer.
+func (f *fakeELB) addLoadBalancer(description *elb.LoadBalancerDescription, policies []*elb.PolicyDescription, tags []*elb.Tag) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := *description.LoadBalancerName
+	f.loadBalancers[name] = description
+	f.policies[name] = policies
+	f.tags[name] = tags
+}
+
+func (f *fakeELB) get(name *string) (*elb.LoadBalancerDescription, error) {
+	description, ok := f.loadBalancers[aws.StringValue(name)]
+	if !ok {
+		return nil, awserr.New(elb.ErrCodeAccessPointNotFoundException, "There is no ACTIVE Load Balancer named '"+aws.StringValue(name)+"'", nil)
+	}
+	return description, nil
+}
+
+func (f *fakeELB) DescribeLoadBalancers(input *elb.DescribeLoadBalancersInput) (*elb.DescribeLoadBalancersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	output := &elb.DescribeLoadBalancersOutput{}
+	names := aws.StringValueSlice(input.LoadBalancerNames)
+	if len(names) == 0 {
+		for name := range f.loadBalancers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		description, err := f.get(aws.String(name))
+		if err != nil {
+			return nil, err
+		}
+		output.LoadBalancerDescriptions = append(output.LoadBalancerDescriptions, awsutil.CopyOf(description).(*elb.LoadBalancerDescription))
+	}
+	return output, nil
+}
+
+func (f *fakeELB) DescribeLoadBalancersPages(input *elb.DescribeLoadBalancersInput, fn func(*elb.DescribeLoadBalancersOutput, bool) bool) error {
+	output, err := f.DescribeLoadBalancers(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (f *fakeELB) CreateLoadBalancer(input *elb.CreateLoadBalancerInput) (*elb.CreateLoadBalancerOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.StringValue(input.LoadBalancerName)
+	if _, ok := f.loadBalancers[name]; ok {
+		return nil, awserr.New(elb.ErrCodeDuplicateAccessPointNameException, "Load Balancer named '"+name+"' already exists", nil)
+	}
+
+	dnsName := fmt.Sprintf("%s-1234567890.%s.elb.amazonaws.com", name, f.region)
+	if aws.StringValue(input.Scheme) == "internal" {
+		dnsName = "internal-" + dnsName
+	}
+	description := &elb.LoadBalancerDescription{
+		LoadBalancerName:          aws.String(name),
+		DNSName:                   aws.String(dnsName),
+		CanonicalHostedZoneNameID: aws.String("Z1FAKEELBZONE"),
+		Scheme:                    input.Scheme,
+		SecurityGroups:            input.SecurityGroups,
+		Subnets:                   input.Subnets,
+		AvailabilityZones:         input.AvailabilityZones,
+		Policies:                  &elb.Policies{},
+	}
+	for _, listener := range input.Listeners {
+		description.ListenerDescriptions = append(description.ListenerDescriptions, &elb.ListenerDescription{
+			Listener:    awsutil.CopyOf(listener).(*elb.Listener),
+			PolicyNames: []*string{},
+		})
+	}
+	f.loadBalancers[name] = description
+	f.tags[name] = input.Tags
+
+	return &elb.CreateLoadBalancerOutput{DNSName: aws.String(dnsName)}, nil
+}
+
+func (f *fakeELB) DeleteLoadBalancer(input *elb.DeleteLoadBalancerInput) (*elb.DeleteLoadBalancerOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Deleting a load balancer that does not exist succeeds, as it does in AWS.
+	delete(f.loadBalancers, aws.StringValue(input.LoadBalancerName))
+	delete(f.policies, aws.StringValue(input.LoadBalancerName))
+	delete(f.tags, aws.StringValue(input.LoadBalancerName))
+	delete(f.attributes, aws.StringValue(input.LoadBalancerName))
+	return &elb.DeleteLoadBalancerOutput{}, nil
+}
+
+func (f *fakeELB) DescribeTags(input *elb.DescribeTagsInput) (*elb.DescribeTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	output := &elb.DescribeTagsOutput{}
+	for _, name := range input.LoadBalancerNames {
+		if _, err := f.get(name); err != nil {
+			return nil, err
+		}
+		output.TagDescriptions = append(output.TagDescriptions, &elb.TagDescription{
+			LoadBalancerName: name,
+			Tags:             f.tags[*name],
+		})
+	}
+	return output, nil
+}
+
+func (f *fakeELB) DescribeLoadBalancerAttributes(input *elb.DescribeLoadBalancerAttributesInput) (*elb.DescribeLoadBalancerAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.get(input.LoadBalancerName); err != nil {
+		return nil, err
+	}
+	attributes, ok := f.attributes[*input.LoadBalancerName]
+	if !ok {
+		// The attributes of a new load balancer.
+		attributes = &elb.LoadBalancerAttributes{
+			AccessLog:              &elb.AccessLog{Enabled: aws.Bool(false)},
+			ConnectionDraining:     &elb.ConnectionDraining{Enabled: aws.Bool(false), Timeout: aws.Int64(300)},
+			ConnectionSettings:     &elb.ConnectionSettings{IdleTimeout: aws.Int64(60)},
+			CrossZoneLoadBalancing: &elb.CrossZoneLoadBalancing{Enabled: aws.Bool(false)},
+		}
+	}
+	return &elb.DescribeLoadBalancerAttributesOutput{
+		LoadBalancerAttributes: awsutil.CopyOf(attributes).(*elb.LoadBalancerAttributes),
+	}, nil
+}
+
+func (f *fakeELB) ModifyLoadBalancerAttributes(input *elb.ModifyLoadBalancerAttributesInput) (*elb.ModifyLoadBalancerAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.get(input.LoadBalancerName); err != nil {
+		return nil, err
+	}
+	f.attributes[*input.LoadBalancerName] = awsutil.CopyOf(input.LoadBalancerAttributes).(*elb.LoadBalancerAttributes)
+	return &elb.ModifyLoadBalancerAttributesOutput{
+		LoadBalancerName:       input.LoadBalancerName,
+		LoadBalancerAttributes: input.LoadBalancerAttributes,
+	}, nil
+}
+
+func (f *fakeELB) ConfigureHealthCheck(input *elb.ConfigureHealthCheckInput) (*elb.ConfigureHealthCheckOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	description, err := f.get(input.LoadBalancerName)
+	if err != nil {
+		return nil, err
+	}
+	description.HealthCheck = input.HealthCheck
+	return &elb.ConfigureHealthCheckOutput{HealthCheck: input.HealthCheck}, nil
+}
+
+func (f *fakeELB) addPolicy(name string, policy *elb.PolicyDescription) error {
+	for _, existing := range f.policies[name] {
+		if *existing.PolicyName == *policy.PolicyName {
+			return awserr.New(elb.ErrCodeDuplicatePolicyNameException, "Policy '"+*policy.PolicyName+"' already exists", nil)
+		}
+	}
+	f.policies[name] = append(f.policies[name], policy)
+	return nil
+}
+
+func (f *fakeELB) CreateLBCookieStickinessPolicy(input *elb.CreateLBCookieStickinessPolicyInput) (*elb.CreateLBCookieStickinessPolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	description, err := f.get(input.LoadBalancerName)
+	if err != nil {
+		return nil, err
+	}
+	policy := &elb.PolicyDescription{
+		PolicyName:     input.PolicyName,
+		PolicyTypeName: aws.String("LBCookieStickinessPolicyType"),
+		PolicyAttributeDescriptions: []*elb.PolicyAttributeDescription{
+			{AttributeName: aws.String("CookieExpirationPeriod"), AttributeValue: aws.String(fmt.Sprint(aws.Int64Value(input.CookieExpirationPeriod)))},
+		},
+	}
+	if err := f.addPolicy(*input.LoadBalancerName, policy); err != nil {
+		return nil, err
+	}
+	description.Policies.LBCookieStickinessPolicies = append(description.Policies.LBCookieStickinessPolicies, &elb.LBCookieStickinessPolicy{
+		PolicyName:             input.PolicyName,
+		CookieExpirationPeriod: input.CookieExpirationPeriod,
+	})
+	return &elb.CreateLBCookieStickinessPolicyOutput{}, nil
+}
+
+func (f *fakeELB) CreateAppCookieStickinessPolicy(input *elb.CreateAppCookieStickinessPolicyInput) (*elb.CreateAppCookieStickinessPolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	description, err := f.get(input.LoadBalancerName)
+	if err != nil {
+		return nil, err
+	}
+	policy := &elb.PolicyDescription{
+		PolicyName:     input.PolicyName,
+		PolicyTypeName: aws.String("AppCookieStickinessPolicyType"),
+		PolicyAttributeDescriptions: []*elb.PolicyAttributeDescription{
+			{AttributeName: aws.String("CookieName"), AttributeValue: input.CookieName},
+		},
+	}
+	if err := f.addPolicy(*input.LoadBalancerName, policy); err != nil {
+		return nil, err
+	}
+	description.Policies.AppCookieStickinessPolicies = append(description.Policies.AppCookieStickinessPolicies, &elb.AppCookieStickinessPolicy{
+		PolicyName: input.PolicyName,
+		CookieName: input.CookieName,
+	})
+	return &elb.CreateAppCookieStickinessPolicyOutput{}, nil
+}
+
+func (f *fakeELB) CreateLoadBalancerPolicy(input *elb.CreateLoadBalancerPolicyInput) (*elb.CreateLoadBalancerPolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	description, err := f.get(input.LoadBalancerName)
+	if err != nil {
+		return nil, err
+	}
+	policy := &elb.PolicyDescription{
+		PolicyName:     input.PolicyName,
+		PolicyTypeName: input.PolicyTypeName,
+	}
+	for _, attribute := range input.PolicyAttributes {
+		policy.PolicyAttributeDescriptions = append(policy.PolicyAttributeDescriptions, &elb.PolicyAttributeDescription{
+			AttributeName:  attribute.AttributeName,
+			AttributeValue: attribute.AttributeValue,
+		})
+	}
+	if err := f.addPolicy(*input.LoadBalancerName, policy); err != nil {
+		return nil, err
+	}
+	description.Policies.OtherPolicies = append(description.Policies.OtherPolicies, input.PolicyName)
+	return &elb.CreateLoadBalancerPolicyOutput{}, nil
+}
+
+func (f *fakeELB) DescribeLoadBalancerPolicies(input *elb.DescribeLoadBalancerPoliciesInput) (*elb.DescribeLoadBalancerPoliciesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.get(input.LoadBalancerName); err != nil {
+		return nil, err
+	}
+	policies := f.policies[*input.LoadBalancerName]
+	if len(input.PolicyNames) == 0 {
+		return &elb.DescribeLoadBalancerPoliciesOutput{PolicyDescriptions: policies}, nil
+	}
+	output := &elb.DescribeLoadBalancerPoliciesOutput{}
+	for _, name := range input.PolicyNames {
+		found := false
+		for _, policy := range policies {
+			if *policy.PolicyName == *name {
+				output.PolicyDescriptions = append(output.PolicyDescriptions, policy)
+				found = true
+			}
+		}
+		if !found {
+			return nil, awserr.New(elb.ErrCodePolicyNotFoundException, "There is no policy named '"+*name+"'", nil)
+		}
+	}
+	return output, nil
+}
+
+func (f *fakeELB) SetLoadBalancerPoliciesOfListener(input *elb.SetLoadBalancerPoliciesOfListenerInput) (*elb.SetLoadBalancerPoliciesOfListenerOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	description, err := f.get(input.LoadBalancerName)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range input.PolicyNames {
+		found := false
+		for _, policy := range f.policies[*input.LoadBalancerName] {
+			found = found || *policy.PolicyName == *name
+		}
+		if !found {
+			return nil, awserr.New(elb.ErrCodePolicyNotFoundException, "There is no policy named '"+*name+"'", nil)
+		}
+	}
+	for _, listener := range description.ListenerDescriptions {
+		if *listener.Listener.LoadBalancerPort == *input.LoadBalancerPort {
+			listener.PolicyNames = input.PolicyNames
+			return &elb.SetLoadBalancerPoliciesOfListenerOutput{}, nil
+		}
+	}
+	return nil, awserr.New(elb.ErrCodeListenerNotFoundException, fmt.Sprintf("There is no listener on port %d", *input.LoadBalancerPort), nil)
+}
+
+func (f *fakeELB) SetLoadBalancerPoliciesForBackendServer(input *elb.SetLoadBalancerPoliciesForBackendServerInput) (*elb.SetLoadBalancerPoliciesForBackendServerOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	description, err := f.get(input.LoadBalancerName)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range input.PolicyNames {
+		found := false
+		for _, policy := range f.policies[*input.LoadBalancerName] {
+			found = found || *policy.PolicyName == *name
+		}
+		if !found {
+			return nil, awserr.New(elb.ErrCodePolicyNotFoundException, "There is no policy named '"+*name+"'", nil)
+		}
+	}
+	for _, backend := range description.BackendServerDescriptions {
+		if *backend.InstancePort == *input.InstancePort {
+			backend.PolicyNames = input.PolicyNames
+			return &elb.SetLoadBalancerPoliciesForBackendServerOutput{}, nil
+		}
+	}
+	description.BackendServerDescriptions = append(description.BackendServerDescriptions, &elb.BackendServerDescription{
+		InstancePort: input.InstancePort,
+		PolicyNames:  input.PolicyNames,
+	})
+	return &elb.SetLoadBalancerPoliciesForBackendServerOutput{}, nil
+}
+
+func (f *fakeELB) RegisterInstancesWithLoadBalancer(input *elb.RegisterInstancesWithLoadBalancerInput) (*elb.RegisterInstancesWithLoadBalancerOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	description, err := f.get(input.LoadBalancerName)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range input.Instances {
+		registered := false
+		for _, existing := range description.Instances {
+			registered = registered || *existing.InstanceId == *instance.InstanceId
+		}
+		if !registered {
+			description.Instances = append(description.Instances, &elb.Instance{InstanceId: instance.InstanceId})
+		}
+	}
+	return &elb.RegisterInstancesWithLoadBalancerOutput{Instances: description.Instances}, nil
+}
+
+func (f *fakeELB) DeregisterInstancesFromLoadBalancer(input *elb.DeregisterInstancesFromLoadBalancerInput) (*elb.DeregisterInstancesFromLoadBalancerOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	description, err := f.get(input.LoadBalancerName)
+	if err != nil {
+		return nil, err
+	}
+	var remaining []*elb.Instance
+	for _, existing := range description.Instances {
+		deregister := false
+		for _, instance := range input.Instances {
+			deregister = deregister || *existing.InstanceId == *instance.InstanceId
+		}
+		if !deregister {
+			remaining = append(remaining, existing)
+		}
+	}
+	description.Instances = remaining
+	return &elb.DeregisterInstancesFromLoadBalancerOutput{Instances: remaining}, nil
+}
+
+func (f *fakeELB) DescribeInstanceHealth(input *elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	description, err := f.get(input.LoadBalancerName)
+	if err != nil {
+		return nil, err
+	}
+	output := &elb.DescribeInstanceHealthOutput{}
+	for _, instance := range description.Instances {
+		state, ok := f.instanceStates[*instance.InstanceId]
+		if !ok {
+			state = "InService"
+		}
+		output.InstanceStates = append(output.InstanceStates, &elb.InstanceState{
+			InstanceId: instance.InstanceId,
+			State:      aws.String(state),
+		})
+	}
+	return output, nil
+}
+
+// fakeELBV2 is an in-memory implementation of the parts of
+// elbv2iface.ELBV2API the migration uses. Deleting a load balancer deletes its
+// listeners at once, so its target groups can be deleted right after.
+type fakeELBV2 struct {
+	elbv2iface.ELBV2API
+
+	mu            sync.Mutex
+	region        string
+	loadBalancers map[string]*elbv2.LoadBalancer
+	targetGroups  map[string]*elbv2.TargetGroup
+	listeners     map[string][]*elbv2.Listener
+	attributes    map[string][]*elbv2.TargetGroupAttribute
+	lbAttributes  map[string][]*elbv2.LoadBalancerAttribute
+	targets       map[string][]*elbv2.TargetDescription
+	// targetStates overrides the healthy state reported for a target.
+	targetStates map[string]string
+}
+
+func newFakeELBV2(region string) *fakeELBV2 {
+	return &fakeELBV2{
+		region:        region,
+		loadBalancers: map[string]*elbv2.LoadBalancer{},
+		targetGroups:  map[string]*elbv2.TargetGroup{},
+		listeners:     map[string][]*elbv2.Listener{},
+		attributes:    map[string][]*elbv2.TargetGroupAttribute{},
+		lbAttributes:  map[string][]*elbv2.LoadBalancerAttribute{},
+		targets:       map[string][]*elbv2.TargetDescription{},
+		targetStates:  map[string]string{},
+	}
+}
+
+func (f *fakeELBV2) arn(kind string, name string) string {
+	return fmt.Sprintf("arn:aws:elasticloadbalancing:%s:123456789012:%s/%s/0123456789abcdef", f.region, kind, name)
+}
+
+func (f *fakeELBV2) DescribeLoadBalancers(input *elbv2.DescribeLoadBalancersInput) (*elbv2.DescribeLoadBalancersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	output := &elbv2.DescribeLoadBalancersOutput{}
+	for _, name := range input.Names {
+		loadBalancer, ok := f.loadBalancers[*name]
+		if !ok {
+			return nil, awserr.New(elbv2.ErrCodeLoadBalancerNotFoundException, "Load balancers '["+*name+"]' not found", nil)
+		}
+		output.LoadBalancers = append(output.LoadBalancers, awsutil.CopyOf(loadBalancer).(*elbv2.LoadBalancer))
+	}
+	return output, nil
+}
+
+func (f *fakeELBV2) CreateLoadBalancer(input *elbv2.CreateLoadBalancerInput) (*elbv2.CreateLoadBalancerOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.StringValue(input.Name)
+	if _, ok := f.loadBalancers[name]; ok {
+		return nil, awserr.New(elbv2.ErrCodeDuplicateLoadBalancerNameException, "A load balancer with the same name '"+name+"' exists", nil)
+	}
+	dnsName := fmt.Sprintf("%s-1234567890.%s.elb.amazonaws.com", name, f.region)
+	if aws.StringValue(input.Scheme) == elbv2.LoadBalancerSchemeEnumInternal {
+		dnsName = "internal-" + dnsName
+	}
+	kind, zoneID := "loadbalancer/app", "Z1FAKEALBZONE"
+	if aws.StringValue(input.Type) == elbv2.LoadBalancerTypeEnumNetwork {
+		dnsName = fmt.Sprintf("%s-0123456789abcdef.elb.%s.amazonaws.com", name, f.region)
+		kind, zoneID = "loadbalancer/net", "Z1FAKENLBZONE"
+	}
+	loadBalancer := &elbv2.LoadBalancer{
+		LoadBalancerName:      aws.String(name),
+		LoadBalancerArn:       aws.String(f.arn(kind, name)),
+		DNSName:               aws.String(dnsName),
+		CanonicalHostedZoneId: aws.String(zoneID),
+		Scheme:                input.Scheme,
+		Type:                  input.Type,
+		SecurityGroups:        input.SecurityGroups,
+	}
+	f.loadBalancers[name] = loadBalancer
+	return &elbv2.CreateLoadBalancerOutput{LoadBalancers: []*elbv2.LoadBalancer{awsutil.CopyOf(loadBalancer).(*elbv2.LoadBalancer)}}, nil
+}
+
+func (f *fakeELBV2) DeleteLoadBalancer(input *elbv2.DeleteLoadBalancerInput) (*elbv2.DeleteLoadBalancerOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, loadBalancer := range f.loadBalancers {
+		if *loadBalancer.LoadBalancerArn == *input.LoadBalancerArn {
+			delete(f.loadBalancers, name)
+			delete(f.listeners, *input.LoadBalancerArn)
+		}
+	}
+	return &elbv2.DeleteLoadBalancerOutput{}, nil
+}
+
+func (f *fakeELBV2) ModifyLoadBalancerAttributes(input *elbv2.ModifyLoadBalancerAttributesInput) (*elbv2.ModifyLoadBalancerAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	found := false
+	for _, loadBalancer := range f.loadBalancers {
+		found = found || *loadBalancer.LoadBalancerArn == *input.LoadBalancerArn
+	}
+	if !found {
+		return nil, awserr.New(elbv2.ErrCodeLoadBalancerNotFoundException, "One or more load balancers not found", nil)
+	}
+	f.lbAttributes[*input.LoadBalancerArn] = append(f.lbAttributes[*input.LoadBalancerArn], input.Attributes...)
+	return &elbv2.ModifyLoadBalancerAttributesOutput{Attributes: f.lbAttributes[*input.LoadBalancerArn]}, nil
+}
+
+func (f *fakeELBV2) CreateTargetGroup(input *elbv2.CreateTargetGroupInput) (*elbv2.CreateTargetGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.StringValue(input.Name)
+	if len(name) > 32 {
+		return nil, awserr.New("ValidationError", "Target group name '"+name+"' cannot be longer than '32' characters", nil)
+	}
+	arn := f.arn("targetgroup", name)
+	if _, ok := f.targetGroups[arn]; ok {
+		return nil, awserr.New(elbv2.ErrCodeDuplicateTargetGroupNameException, "A target group with the same name '"+name+"' exists", nil)
+	}
+	targetGroup := &elbv2.TargetGroup{
+		TargetGroupName:     aws.String(name),
+		TargetGroupArn:      aws.String(arn),
+		Protocol:            input.Protocol,
+		Port:                input.Port,
+		VpcId:               input.VpcId,
+		TargetType:          input.TargetType,
+		HealthCheckProtocol: input.HealthCheckProtocol,
+		HealthCheckPort:     input.HealthCheckPort,
+		HealthCheckPath:     input.HealthCheckPath,
+	}
+	f.targetGroups[arn] = targetGroup
+	return &elbv2.CreateTargetGroupOutput{TargetGroups: []*elbv2.TargetGroup{awsutil.CopyOf(targetGroup).(*elbv2.TargetGroup)}}, nil
+}
+
+func (f *fakeELBV2) DescribeTargetGroupsPages(input *elbv2.DescribeTargetGroupsInput, fn func(*elbv2.DescribeTargetGroupsOutput, bool) bool) error {
+	f.mu.Lock()
+	output := &elbv2.DescribeTargetGroupsOutput{}
+	var arns []string
+	for _, listener := range f.listeners[aws.StringValue(input.LoadBalancerArn)] {
+		for _, action := range listener.DefaultActions {
+			arns = append(arns, *action.TargetGroupArn)
+		}
+	}
+	sort.Strings(arns)
+	for i, arn := range arns {
+		if i > 0 && arns[i-1] == arn {
+			continue
+		}
+		output.TargetGroups = append(output.TargetGroups, awsutil.CopyOf(f.targetGroups[arn]).(*elbv2.TargetGroup))
+	}
+	f.mu.Unlock()
+	fn(output, true)
+	return nil
+}
+
+// inUse reports whether a listener of an existing load balancer forwards to
+// the target group.
+func (f *fakeELBV2) inUse(targetGroupArn string) bool {
+	for _, listeners := range f.listeners {
+		for _, listener := range listeners {
+			for _, action := range listener.DefaultActions {
+				if *action.TargetGroupArn == targetGroupArn {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (f *fakeELBV2) DeleteTargetGroup(input *elbv2.DeleteTargetGroupInput) (*elbv2.DeleteTargetGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.inUse(*input.TargetGroupArn) {
+		return nil, awserr.New(elbv2.ErrCodeResourceInUseException, "Target group '"+*input.TargetGroupArn+"' is currently in use by a listener or a rule", nil)
+	}
+	delete(f.targetGroups, *input.TargetGroupArn)
+	delete(f.attributes, *input.TargetGroupArn)
+	delete(f.targets, *input.TargetGroupArn)
+	return &elbv2.DeleteTargetGroupOutput{}, nil
+}
+
+func (f *fakeELBV2) getTargetGroup(arn *string) (*elbv2.TargetGroup, error) {
+	targetGroup, ok := f.targetGroups[aws.StringValue(arn)]
+	if !ok {
+		return nil, awserr.New(elbv2.ErrCodeTargetGroupNotFoundException, "One or more target groups not found", nil)
+	}
+	return targetGroup, nil
+}
+
+func (f *fakeELBV2) ModifyTargetGroupAttributes(input *elbv2.ModifyTargetGroupAttributesInput) (*elbv2.ModifyTargetGroupAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.getTargetGroup(input.TargetGroupArn); err != nil {
+		return nil, err
+	}
+	f.attributes[*input.TargetGroupArn] = append(f.attributes[*input.TargetGroupArn], input.Attributes...)
+	return &elbv2.ModifyTargetGroupAttributesOutput{Attributes: f.attributes[*input.TargetGroupArn]}, nil
+}
+
+func (f *fakeELBV2) CreateListener(input *elbv2.CreateListenerInput) (*elbv2.CreateListenerOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	found := false
+	for _, loadBalancer := range f.loadBalancers {
+		found = found || *loadBalancer.LoadBalancerArn == *input.LoadBalancerArn
+	}
+	if !found {
+		return nil, awserr.New(elbv2.ErrCodeLoadBalancerNotFoundException, "One or more load balancers not found", nil)
+	}
+	for _, listener := range f.listeners[*input.LoadBalancerArn] {
+		if *listener.Port == *input.Port {
+			return nil, awserr.New(elbv2.ErrCodeDuplicateListenerException, "A listener already exists on this port for this load balancer", nil)
+		}
+	}
+	for _, action := range input.DefaultActions {
+		if _, err := f.getTargetGroup(action.TargetGroupArn); err != nil {
+			return nil, err
+		}
+	}
+	listener := &elbv2.Listener{
+		LoadBalancerArn: input.LoadBalancerArn,
+		Protocol:        input.Protocol,
+		Port:            input.Port,
+		Certificates:    input.Certificates,
+		SslPolicy:       input.SslPolicy,
+		DefaultActions:  input.DefaultActions,
+	}
+	f.listeners[*input.LoadBalancerArn] = append(f.listeners[*input.LoadBalancerArn], listener)
+	return &elbv2.CreateListenerOutput{Listeners: []*elbv2.Listener{listener}}, nil
+}
+
+func (f *fakeELBV2) RegisterTargets(input *elbv2.RegisterTargetsInput) (*elbv2.RegisterTargetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.getTargetGroup(input.TargetGroupArn); err != nil {
+		return nil, err
+	}
+	f.targets[*input.TargetGroupArn] = append(f.targets[*input.TargetGroupArn], input.Targets...)
+	return &elbv2.RegisterTargetsOutput{}, nil
+}
+
+func (f *fakeELBV2) DescribeTargetHealth(input *elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.getTargetGroup(input.TargetGroupArn); err != nil {
+		return nil, err
+	}
+	output := &elbv2.DescribeTargetHealthOutput{}
+	for _, target := range f.targets[*input.TargetGroupArn] {
+		state, ok := f.targetStates[*target.Id]
+		if !ok {
+			state = elbv2.TargetHealthStateEnumHealthy
+		}
+		output.TargetHealthDescriptions = append(output.TargetHealthDescriptions, &elbv2.TargetHealthDescription{
+			Target:       target,
+			TargetHealth: &elbv2.TargetHealth{State: aws.String(state)},
+		})
+	}
+	return output, nil
+}
+
+// fakeRoute53 is an in-memory implementation of the parts of
+// route53iface.Route53API the migration uses. Changes are applied atomically
+// per batch and validated the way Route53 does for CREATE and DELETE.
+type fakeRoute53 struct {
+	route53iface.Route53API
+
+	mu         sync.Mutex
+	zones      []*route53.HostedZone
+	recordSets map[string][]*route53.ResourceRecordSet
+	changes    int
+	// failChange makes the ChangeResourceRecordSets call with this number,
+	// counting from one, fail. Zero disables it.
+	failChange int
+	calls      int
+	// batches lists the applied change batches, each change as its action,
+	// name, type and set identifier.
+	batches [][]string
+}
+
+func newFakeRoute53() *fakeRoute53 {
+	return &fakeRoute53{recordSets: map[string][]*route53.ResourceRecordSet{}}
+}
+
+// addHostedZone seeds the fake with a hosted zone and its record sets.
+func (f *fakeRoute53) addHostedZone(id string, name string, recordSets ...*route53.ResourceRecordSet) *route53.HostedZone {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	zone := &route53.HostedZone{Id: aws.String(id), Name: aws.String(name)}
+	f.zones = append(f.zones, zone)
+	sort.Slice(f.zones, func(i, j int) bool { return *f.zones[i].Name < *f.zones[j].Name })
+	f.recordSets[id] = recordSets
+	f.sortRecordSets(id)
+	return zone
+}
+
+func (f *fakeRoute53) sortRecordSets(zoneID string) {
+	recordSets := f.recordSets[zoneID]
+	sort.Slice(recordSets, func(i, j int) bool {
+		a, b := recordSets[i], recordSets[j]
+		if *a.Name != *b.Name {
+			return *a.Name < *b.Name
+		}
+		if *a.Type != *b.Type {
+			return *a.Type < *b.Type
+		}
+		return aws.StringValue(a.SetIdentifier) < aws.StringValue(b.SetIdentifier)
+	})
+}
+
+func (f *fakeRoute53) ListHostedZonesByName(input *route53.ListHostedZonesByNameInput) (*route53.ListHostedZonesByNameOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	output := &route53.ListHostedZonesByNameOutput{DNSName: input.DNSName}
+	for _, zone := range f.zones {
+		if *zone.Name >= aws.StringValue(input.DNSName) {
+			output.HostedZones = append(output.HostedZones, zone)
+		}
+	}
+	return output, nil
+}
+
+func (f *fakeRoute53) ListHostedZonesPages(input *route53.ListHostedZonesInput, fn func(*route53.ListHostedZonesOutput, bool) bool) error {
+	f.mu.Lock()
+	zones := append([]*route53.HostedZone{}, f.zones...)
+	f.mu.Unlock()
+	fn(&route53.ListHostedZonesOutput{HostedZones: zones, IsTruncated: aws.Bool(false)}, true)
+	return nil
+}
+
+func (f *fakeRoute53) ListResourceRecordSets(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	recordSets, ok := f.recordSets[aws.StringValue(input.HostedZoneId)]
+	if !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, "No hosted zone found with ID: "+aws.StringValue(input.HostedZoneId), nil)
+	}
+	maxItems := 100
+	if input.MaxItems != nil {
+		fmt.Sscan(*input.MaxItems, &maxItems)
+	}
+
+	output := &route53.ListResourceRecordSetsOutput{IsTruncated: aws.Bool(false)}
+	for _, recordSet := range recordSets {
+		if input.StartRecordName != nil && *recordSet.Name < *input.StartRecordName {
+			continue
+		}
+		if input.StartRecordName != nil && *recordSet.Name == *input.StartRecordName && input.StartRecordType != nil && *recordSet.Type < *input.StartRecordType {
+			continue
+		}
+		if len(output.ResourceRecordSets) == maxItems {
+			output.IsTruncated = aws.Bool(true)
+			output.NextRecordName = recordSet.Name
+			output.NextRecordType = recordSet.Type
+			output.NextRecordIdentifier = recordSet.SetIdentifier
+			break
+		}
+		output.ResourceRecordSets = append(output.ResourceRecordSets, awsutil.CopyOf(recordSet).(*route53.ResourceRecordSet))
+	}
+	return output, nil
+}
+
+func (f *fakeRoute53) ListResourceRecordSetsPages(input *route53.ListResourceRecordSetsInput, fn func(*route53.ListResourceRecordSetsOutput, bool) bool) error {
+	input = awsutil.CopyOf(input).(*route53.ListResourceRecordSetsInput)
+	for {
+		output, err := f.ListResourceRecordSets(input)
+		if err != nil {
+			return err
+		}
+		lastPage := !*output.IsTruncated
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		input.StartRecordName = output.NextRecordName
+		input.StartRecordType = output.NextRecordType
+	}
+}
+
+func (f *fakeRoute53) ChangeResourceRecordSets(input *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	zoneID := aws.StringValue(input.HostedZoneId)
+	current, ok := f.recordSets[zoneID]
+	if !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, "No hosted zone found with ID: "+zoneID, nil)
+	}
+	f.calls++
+	if f.calls == f.failChange {
+		return nil, awserr.New(route53.ErrCodePriorRequestNotComplete, "fake change failure", nil)
+	}
+
+	recordSets := append([]*route53.ResourceRecordSet{}, current...)
+	var batch []string
+	for _, change := range input.ChangeBatch.Changes {
+		recordSet := awsutil.CopyOf(change.ResourceRecordSet).(*route53.ResourceRecordSet)
+		batch = append(batch, strings.TrimSpace(*change.Action+" "+*recordSet.Name+" "+*recordSet.Type+" "+aws.StringValue(recordSet.SetIdentifier)))
+		index := -1
+		for i, existing := range recordSets {
+			if strings.EqualFold(*existing.Name, *recordSet.Name) && *existing.Type == *recordSet.Type &&
+				aws.StringValue(existing.SetIdentifier) == aws.StringValue(recordSet.SetIdentifier) {
+				index = i
+			}
+		}
+		switch *change.Action {
+		case route53.ChangeActionCreate:
+			if index >= 0 {
+				return nil, awserr.New(route53.ErrCodeInvalidChangeBatch, "Tried to create resource record set "+*recordSet.Name+" but it already exists", nil)
+			}
+			recordSets = append(recordSets, recordSet)
+		case route53.ChangeActionDelete:
+			if index < 0 || !awsutil.DeepEqual(recordSets[index], recordSet) {
+				return nil, awserr.New(route53.ErrCodeInvalidChangeBatch, "Tried to delete resource record set "+*recordSet.Name+" but it was not found", nil)
+			}
+			recordSets = append(recordSets[:index], recordSets[index+1:]...)
+		case route53.ChangeActionUpsert:
+			if index >= 0 {
+				recordSets[index] = recordSet
+			} else {
+				recordSets = append(recordSets, recordSet)
+			}
+		}
+	}
+	f.recordSets[zoneID] = recordSets
+	f.sortRecordSets(zoneID)
+	f.changes++
+	f.batches = append(f.batches, batch)
+
+	return &route53.ChangeResourceRecordSetsOutput{
+		ChangeInfo: &route53.ChangeInfo{
+			Id:     aws.String(fmt.Sprintf("/change/C%d", f.changes)),
+			Status: aws.String(route53.ChangeStatusPending),
+		},
+	}, nil
+}
+
+func (f *fakeRoute53) GetChange(input *route53.GetChangeInput) (*route53.GetChangeOutput, error) {
+	return &route53.GetChangeOutput{
+		ChangeInfo: &route53.ChangeInfo{
+			Id:     input.Id,
+			Status: aws.String(route53.ChangeStatusInsync),
+		},
+	}, nil
+}
+
+// fakeEC2 is an in-memory implementation of the parts of ec2iface.EC2API the
+// migration uses.
+type fakeEC2 struct {
+	ec2iface.EC2API
+
+	mu             sync.Mutex
+	securityGroups map[string]*ec2.SecurityGroup
+	subnets        map[string]*ec2.Subnet
+	// instanceZones maps an instance to its availability zone.
+	instanceZones map[string]string
+}
+
+func newFakeEC2() *fakeEC2 {
+	return &fakeEC2{
+		securityGroups: map[string]*ec2.SecurityGroup{},
+		subnets:        map[string]*ec2.Subnet{},
+		instanceZones:  map[string]string{},
+	}
+}
+
+// addSubnet seeds the fake with an existing subnet.
+func (f *fakeEC2) addSubnet(subnetID string, vpcID string, zone string, tags map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subnet := &ec2.Subnet{SubnetId: aws.String(subnetID), VpcId: aws.String(vpcID), AvailabilityZone: aws.String(zone)}
+	for key, value := range tags {
+		subnet.Tags = append(subnet.Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	f.subnets[subnetID] = subnet
+}
+
+// addInstance seeds the fake with an instance running in zone.
+func (f *fakeEC2) addInstance(instanceID string, zone string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instanceZones[instanceID] = zone
+}
+
+// addSecurityGroup seeds the fake with an existing security group.
+func (f *fakeEC2) addSecurityGroup(groupID string, vpcID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.securityGroups[groupID] = &ec2.SecurityGroup{GroupId: aws.String(groupID), VpcId: aws.String(vpcID)}
+}
+
+func (f *fakeEC2) DescribeSecurityGroupsPages(input *ec2.DescribeSecurityGroupsInput, fn func(*ec2.DescribeSecurityGroupsOutput, bool) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	output := &ec2.DescribeSecurityGroupsOutput{}
+	for _, groupID := range aws.StringValueSlice(input.GroupIds) {
+		securityGroup, ok := f.securityGroups[groupID]
+		if !ok {
+			return awserr.New("InvalidGroup.NotFound", "The security group '"+groupID+"' does not exist", nil)
+		}
+		output.SecurityGroups = append(output.SecurityGroups, securityGroup)
+	}
+	fn(output, true)
+	return nil
+}
+
+func (f *fakeEC2) DescribeSubnetsPages(input *ec2.DescribeSubnetsInput, fn func(*ec2.DescribeSubnetsOutput, bool) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := aws.StringValueSlice(input.SubnetIds)
+	if len(ids) == 0 {
+		for subnetID := range f.subnets {
+			ids = append(ids, subnetID)
+		}
+		sort.Strings(ids)
+	}
+	output := &ec2.DescribeSubnetsOutput{}
+	for _, subnetID := range ids {
+		subnet, ok := f.subnets[subnetID]
+		if !ok {
+			return awserr.New("InvalidSubnetID.NotFound", "The subnet ID '"+subnetID+"' does not exist", nil)
+		}
+		if fakeFiltersMatch(input.Filters, subnet) {
+			output.Subnets = append(output.Subnets, subnet)
+		}
+	}
+	fn(output, true)
+	return nil
+}
+
+// fakeFiltersMatch supports the vpc-id and tag:<key> filters.
+func fakeFiltersMatch(filters []*ec2.Filter, subnet *ec2.Subnet) bool {
+	for _, filter := range filters {
+		name := aws.StringValue(filter.Name)
+		value := ""
+		switch {
+		case name == "vpc-id":
+			value = aws.StringValue(subnet.VpcId)
+		case strings.HasPrefix(name, "tag:"):
+			for _, tag := range subnet.Tags {
+				if aws.StringValue(tag.Key) == strings.TrimPrefix(name, "tag:") {
+					value = aws.StringValue(tag.Value)
+				}
+			}
+		default:
+			panic("fakeEC2: unsupported filter " + name)
+		}
+		matched := false
+		for _, wanted := range aws.StringValueSlice(filter.Values) {
+			matched = matched || value == wanted
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeEC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reservation := &ec2.Reservation{}
+	for _, instanceID := range aws.StringValueSlice(input.InstanceIds) {
+		zone, ok := f.instanceZones[instanceID]
+		if !ok {
+			return awserr.New("InvalidInstanceID.NotFound", "The instance ID '"+instanceID+"' does not exist", nil)
+		}
+		reservation.Instances = append(reservation.Instances, &ec2.Instance{
+			InstanceId: aws.String(instanceID),
+			Placement:  &ec2.Placement{AvailabilityZone: aws.String(zone)},
+		})
+	}
+	fn(&ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{reservation}}, true)
+	return nil
+}
+
+// fakeACM is an in-memory implementation of the parts of acmiface.ACMAPI the
+// migration uses.
+type fakeACM struct {
+	acmiface.ACMAPI
+
+	mu           sync.Mutex
+	certificates map[string]*acm.CertificateDetail
+}
+
+func newFakeACM() *fakeACM {
+	return &fakeACM{certificates: map[string]*acm.CertificateDetail{}}
+}
+
+// addCertificate seeds the fake with an issued certificate.
+func (f *fakeACM) addCertificate(certificateArn string, domain string, notAfter time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.certificates[certificateArn] = &acm.CertificateDetail{
+		CertificateArn: aws.String(certificateArn),
+		DomainName:     aws.String(domain),
+		Status:         aws.String(acm.CertificateStatusIssued),
+		NotAfter:       aws.Time(notAfter),
+	}
+}
+
+func (f *fakeACM) DescribeCertificate(input *acm.DescribeCertificateInput) (*acm.DescribeCertificateOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	certificate, ok := f.certificates[aws.StringValue(input.CertificateArn)]
+	if !ok {
+		return nil, awserr.New(acm.ErrCodeResourceNotFoundException, "Could not find certificate "+aws.StringValue(input.CertificateArn), nil)
+	}
+	return &acm.DescribeCertificateOutput{Certificate: certificate}, nil
+}
+
+func (f *fakeACM) ListCertificatesPages(input *acm.ListCertificatesInput, fn func(*acm.ListCertificatesOutput, bool) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	arns := make([]string, 0, len(f.certificates))
+	for certificateArn := range f.certificates {
+		arns = append(arns, certificateArn)
+	}
+	sort.Strings(arns)
+	output := &acm.ListCertificatesOutput{}
+	for _, certificateArn := range arns {
+		certificate := f.certificates[certificateArn]
+		statusMatches := len(input.CertificateStatuses) == 0
+		for _, status := range aws.StringValueSlice(input.CertificateStatuses) {
+			statusMatches = statusMatches || status == aws.StringValue(certificate.Status)
+		}
+		if statusMatches {
+			output.CertificateSummaryList = append(output.CertificateSummaryList, &acm.CertificateSummary{
+				CertificateArn: certificate.CertificateArn,
+				DomainName:     certificate.DomainName,
+				NotAfter:       certificate.NotAfter,
+				Status:         certificate.Status,
+			})
+		}
+	}
+	fn(output, true)
+	return nil
+}
+
+// fakeCloudWatch is an in-memory implementation of the parts of
+// cloudwatchiface.CloudWatchAPI the migration uses. Every call for a metric
+// returns the next of its values as a single datapoint, repeating the last
+// one; a metric without values has no datapoints.
+type fakeCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+
+	mu     sync.Mutex
+	values map[string][]float64
+}
+
+func newFakeCloudWatch() *fakeCloudWatch {
+	return &fakeCloudWatch{values: map[string][]float64{}}
+}
+
+func (f *fakeCloudWatch) GetMetricStatistics(input *cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	output := &cloudwatch.GetMetricStatisticsOutput{Label: input.MetricName}
+	values := f.values[aws.StringValue(input.MetricName)]
+	if len(values) == 0 {
+		return output, nil
+	}
+	value := values[0]
+	if len(values) > 1 {
+		f.values[aws.StringValue(input.MetricName)] = values[1:]
+	}
+	output.Datapoints = []*cloudwatch.Datapoint{{
+		Timestamp: input.EndTime,
+		Sum:       aws.Float64(value),
+		Average:   aws.Float64(value),
+	}}
+	return output, nil
+}
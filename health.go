@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// What shifting does when the replica becomes unhealthy.
+const (
+	// onUnhealthyHalt stops shifting and keeps the current weights, so the
+	// migration can be resumed or rolled back by hand.
+	onUnhealthyHalt = "halt"
+	// onUnhealthyHold keeps the current weights until the replica recovers,
+	// and halts if it does not within holdTimeout.
+	onUnhealthyHold = "hold"
+	// onUnhealthyRevert sends all traffic back to blue and stops shifting,
+	// keeping green at weight 0 and the replica so the migration can be
+	// resumed or rolled back by hand.
+	onUnhealthyRevert = "revert"
+)
+
+var onUnhealthyActions = []string{onUnhealthyHalt, onUnhealthyHold, onUnhealthyRevert}
+
+// holdInterval is how often the health of a held replica is checked again.
+const holdInterval = 15 * time.Second
+
+// metricsWindow is how far back CloudWatch metrics are looked at.
+const metricsWindow = 5 * time.Minute
+
+// shiftGate is consulted before every weight change of a shift.
+type shiftGate struct {
+	// check returns why the replica is unhealthy, or "" if it is healthy.
+	check       func(ctx context.Context) (string, error)
+	onUnhealthy string
+	holdTimeout time.Duration
+}
+
+// wait returns nil once the next weight change may be made. Otherwise it
+// returns an error wrapping errShiftReverted when the weights are to be
+// reverted, or errShiftHalted when they are to be kept. A nil gate always
+// passes, as does every gate during a dry run.
+func (gate *shiftGate) wait(ctx context.Context) error {
+	if gate == nil || planOf(ctx) != nil {
+		return nil
+	}
+	reason, err := gate.check(ctx)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		return nil
+	}
+	fmt.Fprintln(stdout(ctx), "Replica unhealthy: "+reason)
+	switch gate.onUnhealthy {
+	case onUnhealthyRevert:
+		return fmt.Errorf("%w: %s", errShiftReverted, reason)
+	case onUnhealthyHalt:
+		return fmt.Errorf("%w: %s", errShiftHalted, reason)
+	}
+
+	for waited := time.Duration(0); waited < gate.holdTimeout; waited += holdInterval {
+		fmt.Fprintf(stdout(ctx), "Holding the current weights, checking again in %s.\n", holdInterval)
+		if err := sleep(ctx, holdInterval); err != nil {
+			return err
+		}
+		reason, err = gate.check(ctx)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			fmt.Fprintln(stdout(ctx), "Replica healthy again.")
+			return nil
+		}
+		fmt.Fprintln(stdout(ctx), "Replica still unhealthy: "+reason)
+	}
+	return fmt.Errorf("%w: unhealthy for %s: %s", errShiftHalted, gate.holdTimeout, reason)
+}
+
+// newShiftGate returns the gate that checks the replica's health while
+// traffic is shifted to it.
+func (m *migration) newShiftGate(ctx context.Context) *shiftGate {
+	defaults := m.opts.defaults
+	if m.opts.targetType == targetTypeNLB && (defaults.Max5XX > 0 || defaults.MaxLatency > 0) {
+		warn(ctx, "NLBs report no 5XX responses or latency, only target health is checked")
+	}
+	return &shiftGate{
+		check:       m.replicaHealth,
+		onUnhealthy: m.opts.defaults.OnUnhealthy,
+		holdTimeout: time.Duration(m.opts.defaults.HoldTimeout) * time.Second,
+	}
+}
+
+// replicaHealth returns why the replica is unhealthy, or "" if it is healthy:
+// too few of its instances or targets are healthy, or its 5XX responses or
+// latency exceed the environment's thresholds.
+func (m *migration) replicaHealth(ctx context.Context) (string, error) {
+	target := m.c.forReplica()
+	defaults := m.opts.defaults
+
+	var healthy, total int
+	var loadBalancer *elbv2.LoadBalancer
+	if isTargetTypeV2(m.opts.targetType) {
+		var err error
+		loadBalancer, err = describeLoadBalancerV2(ctx, target.elbv2, m.replicaElbName)
+		if err != nil {
+			return "", err
+		}
+		targetGroups, err := describeTargetGroupsOfLoadBalancer(target.elbv2, *loadBalancer.LoadBalancerArn)
+		if err != nil {
+			return "", err
+		}
+		for _, targetGroup := range targetGroups {
+			healthOutput, err := describeTargetHealth(target.elbv2, targetGroup)
+			if err != nil {
+				return "", err
+			}
+			for _, description := range healthOutput.TargetHealthDescriptions {
+				total++
+				if aws.StringValue(description.TargetHealth.State) == elbv2.TargetHealthStateEnumHealthy {
+					healthy++
+				}
+			}
+		}
+	} else {
+		healthOutput, err := describeELBInstanceHealth(ctx, target.elb, m.replicaElbName)
+		if err != nil {
+			return "", err
+		}
+		for _, instanceState := range healthOutput.InstanceStates {
+			total++
+			if aws.StringValue(instanceState.State) == "InService" {
+				healthy++
+			}
+		}
+	}
+	if required := aws.Int64Value(defaults.MinHealthyPercent); required > 0 && (total == 0 || int64(healthy*100) < required*int64(total)) {
+		return fmt.Sprintf("%d of %d instances healthy, %d%% required", healthy, total, required), nil
+	}
+
+	if defaults.Max5XX == 0 && defaults.MaxLatency == 0 {
+		return "", nil
+	}
+	metrics, ok := replicaMetrics(m.opts.targetType, m.replicaElbName, loadBalancer)
+	if !ok {
+		return "", nil
+	}
+	if defaults.Max5XX > 0 {
+		errorCount, err := getMetricStatistic(target.cloudwatch, metrics.namespace, metrics.errors, metrics.dimension, cloudwatch.StatisticSum)
+		if err != nil {
+			return "", err
+		}
+		if errorCount > float64(defaults.Max5XX) {
+			return fmt.Sprintf("%s is %.0f in the last %s, at most %d allowed", metrics.errors, errorCount, metricsWindow, defaults.Max5XX), nil
+		}
+	}
+	if defaults.MaxLatency > 0 {
+		latency, err := getMetricStatistic(target.cloudwatch, metrics.namespace, metrics.latency, metrics.dimension, cloudwatch.StatisticAverage)
+		if err != nil {
+			return "", err
+		}
+		if latency > defaults.MaxLatency {
+			return fmt.Sprintf("%s is %.3fs in the last %s, at most %.3fs allowed", metrics.latency, latency, metricsWindow, defaults.MaxLatency), nil
+		}
+	}
+	return "", nil
+}
+
+// loadBalancerMetrics names the CloudWatch metrics of a kind of load
+// balancer.
+type loadBalancerMetrics struct {
+	namespace string
+	dimension *cloudwatch.Dimension
+	errors    string
+	latency   string
+}
+
+// replicaMetrics returns the 5XX and latency metrics of the replica. NLBs have
+// neither, so ok is false for them.
+func replicaMetrics(targetType string, elbName string, loadBalancer *elbv2.LoadBalancer) (loadBalancerMetrics, bool) {
+	switch targetType {
+	case targetTypeNLB:
+		return loadBalancerMetrics{}, false
+	case targetTypeALB:
+		// The LoadBalancer dimension is the part of the ARN after
+		// "loadbalancer/", e.g. app/some-app-r/50dc6c495c0c9188.
+		arn := aws.StringValue(loadBalancer.LoadBalancerArn)
+		index := strings.Index(arn, ":loadbalancer/")
+		if index < 0 {
+			return loadBalancerMetrics{}, false
+		}
+		return loadBalancerMetrics{
+			namespace: "AWS/ApplicationELB",
+			dimension: &cloudwatch.Dimension{Name: aws.String("LoadBalancer"), Value: aws.String(arn[index+len(":loadbalancer/"):])},
+			errors:    "HTTPCode_Target_5XX_Count",
+			latency:   "TargetResponseTime",
+		}, true
+	default:
+		return loadBalancerMetrics{
+			namespace: "AWS/ELB",
+			dimension: &cloudwatch.Dimension{Name: aws.String("LoadBalancerName"), Value: aws.String(elbName)},
+			errors:    "HTTPCode_Backend_5XX",
+			latency:   "Latency",
+		}, true
+	}
+}
+
+// getMetricStatistic returns the statistic of a metric over the last
+// metricsWindow, or 0 if there are no datapoints.
+func getMetricStatistic(svc cloudwatchiface.CloudWatchAPI, namespace string, metricName string, dimension *cloudwatch.Dimension, statistic string) (float64, error) {
+	now := time.Now()
+	input := &cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String(namespace),
+		MetricName: aws.String(metricName),
+		Dimensions: []*cloudwatch.Dimension{dimension},
+		StartTime:  aws.Time(now.Add(-metricsWindow)),
+		EndTime:    aws.Time(now),
+		Period:     aws.Int64(int64(metricsWindow / time.Second)),
+		Statistics: aws.StringSlice([]string{statistic}),
+	}
+
+	result, err := svc.GetMetricStatistics(input)
+	if err != nil {
+		return 0, newAWSError("GetMetricStatistics", namespace+"/"+metricName, err)
+	}
+
+	// The window may span two periods, and CloudWatch returns datapoints in
+	// no particular order.
+	if len(result.Datapoints) == 0 {
+		return 0, nil
+	}
+	datapoints := result.Datapoints
+	sort.Slice(datapoints, func(i, j int) bool {
+		return aws.TimeValue(datapoints[i].Timestamp).Before(aws.TimeValue(datapoints[j].Timestamp))
+	})
+	newest := datapoints[len(datapoints)-1]
+	if statistic == cloudwatch.StatisticSum {
+		return aws.Float64Value(newest.Sum), nil
+	}
+	return aws.Float64Value(newest.Average), nil
+}
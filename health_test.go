@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+func TestReplicaHealthInstances(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.elb.instanceStates["i-1"] = "OutOfService"
+	m := newMigration(tm.c, tm.opts)
+	m.replicaElbName = "app"
+	tests := []struct {
+		minHealthyPercent int64
+		want              string
+	}{
+		{100, "1 of 2 instances healthy, 100% required"},
+		{50, ""},
+		{0, ""},
+	}
+	for _, test := range tests {
+		tm.opts.defaults.MinHealthyPercent = aws.Int64(test.minHealthyPercent)
+		if reason, err := m.replicaHealth(context.Background()); err != nil || reason != test.want {
+			t.Errorf("replicaHealth with minHealthyPercent %d = %q, %v, want %q", test.minHealthyPercent, reason, err, test.want)
+		}
+	}
+}
+
+func TestReplicaHealthMetrics(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.opts.defaults.Max5XX = 10
+	tm.opts.defaults.MaxLatency = 0.5
+	tm.cloudwatch.values["HTTPCode_Backend_5XX"] = []float64{0, 50}
+	tm.cloudwatch.values["Latency"] = []float64{0.2, 0.8}
+	m := newMigration(tm.c, tm.opts)
+	m.replicaElbName = "app"
+
+	if reason, err := m.replicaHealth(context.Background()); err != nil || reason != "" {
+		t.Errorf("replicaHealth = %q, %v, want healthy", reason, err)
+	}
+	want := "HTTPCode_Backend_5XX is 50 in the last 5m0s, at most 10 allowed"
+	if reason, err := m.replicaHealth(context.Background()); err != nil || reason != want {
+		t.Errorf("replicaHealth = %q, %v, want %q", reason, err, want)
+	}
+	tm.opts.defaults.Max5XX = 0
+	want = "Latency is 0.800s in the last 5m0s, at most 0.500s allowed"
+	if reason, err := m.replicaHealth(context.Background()); err != nil || reason != want {
+		t.Errorf("replicaHealth without max5xx = %q, %v, want %q", reason, err, want)
+	}
+}
+
+// becomeUnhealthy makes the replica's 5XX responses exceed max5xx from the
+// second health check on, that is once green has weight 20.
+func (tm *testMigration) becomeUnhealthy(onUnhealthy string) {
+	tm.opts.defaults.OnUnhealthy = onUnhealthy
+	tm.opts.defaults.Max5XX = 10
+	tm.cloudwatch.values["HTTPCode_Backend_5XX"] = []float64{0, 50}
+}
+
+func TestMigrateHaltsWhenUnhealthy(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.becomeUnhealthy(onUnhealthyHalt)
+
+	err := runMigrate(context.Background(), tm.c, tm.opts)
+	if !errors.Is(err, errShiftHalted) {
+		t.Fatalf("runMigrate = %v, want errShiftHalted", err)
+	}
+
+	if !tm.hasLoadBalancer("app-r") {
+		t.Error("replica app-r was deleted")
+	}
+	if weights := tm.weights(); !reflect.DeepEqual(weights, map[string]int64{"app": 80, "app-r": 20}) {
+		t.Errorf("weights = %v, want them kept at app 80, app-r 20", weights)
+	}
+}
+
+func TestMigrateRevertsWhenUnhealthy(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.opts.statePath = filepath.Join(t.TempDir(), "state.json")
+	tm.becomeUnhealthy(onUnhealthyRevert)
+
+	err := runMigrate(context.Background(), tm.c, tm.opts)
+	if !errors.Is(err, errShiftReverted) {
+		t.Fatalf("runMigrate = %v, want errShiftReverted", err)
+	}
+
+	if !tm.hasLoadBalancer("app-r") {
+		t.Error("replica app-r was deleted")
+	}
+	if weights := tm.weights(); !reflect.DeepEqual(weights, map[string]int64{"app": 100, "app-r": 0}) {
+		t.Errorf("weights = %v, want app 100 and green kept at 0", weights)
+	}
+
+	// The replica recovers, so the migration can be resumed.
+	tm.cloudwatch.values["HTTPCode_Backend_5XX"] = []float64{0}
+	if err := runResume(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runResume: %v", err)
+	}
+	if tm.hasLoadBalancer("app") {
+		t.Error("source ELB app was not deleted")
+	}
+	if weights := tm.weights(); !reflect.DeepEqual(weights, map[string]int64{"app-r": 100}) {
+		t.Errorf("weights = %v, want app-r 100 only", weights)
+	}
+}
+
+func TestMigrateHoldsUntilHealthy(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.becomeUnhealthy(onUnhealthyHold)
+	tm.cloudwatch.values["HTTPCode_Backend_5XX"] = []float64{0, 50, 50, 0}
+
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate: %v", err)
+	}
+
+	if weights := tm.weights(); !reflect.DeepEqual(weights, map[string]int64{"app-r": 100}) {
+		t.Errorf("weights = %v, want app-r 100 only", weights)
+	}
+}
+
+func TestMigrateHoldTimesOut(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.becomeUnhealthy(onUnhealthyHold)
+	tm.opts.defaults.HoldTimeout = 30
+
+	err := runMigrate(context.Background(), tm.c, tm.opts)
+	if !errors.Is(err, errShiftHalted) || !strings.Contains(err.Error(), "unhealthy for 30s") {
+		t.Fatalf("runMigrate = %v, want a shift halted after 30s", err)
+	}
+	if weights := tm.weights(); !reflect.DeepEqual(weights, map[string]int64{"app": 80, "app-r": 20}) {
+		t.Errorf("weights = %v, want them kept at app 80, app-r 20", weights)
+	}
+}
+
+// datapointsCloudWatch returns the same datapoints for every metric.
+type datapointsCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	datapoints []*cloudwatch.Datapoint
+}
+
+func (f *datapointsCloudWatch) GetMetricStatistics(input *cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	return &cloudwatch.GetMetricStatisticsOutput{Label: input.MetricName, Datapoints: f.datapoints}, nil
+}
+
+func TestGetMetricStatisticNewest(t *testing.T) {
+	now := time.Now()
+	datapoint := func(age time.Duration, value float64) *cloudwatch.Datapoint {
+		return &cloudwatch.Datapoint{Timestamp: aws.Time(now.Add(-age)), Sum: aws.Float64(value), Average: aws.Float64(value / 10)}
+	}
+	svc := &datapointsCloudWatch{datapoints: []*cloudwatch.Datapoint{datapoint(time.Minute, 7), datapoint(6*time.Minute, 40)}}
+	dimension := &cloudwatch.Dimension{Name: aws.String("LoadBalancerName"), Value: aws.String("app-r")}
+	for _, test := range []struct {
+		statistic string
+		want      float64
+	}{
+		{cloudwatch.StatisticSum, 7},
+		{cloudwatch.StatisticAverage, 0.7},
+	} {
+		if got, err := getMetricStatistic(svc, "AWS/ELB", "Latency", dimension, test.statistic); err != nil || got != test.want {
+			t.Errorf("getMetricStatistic(%s) = %v, %v, want %v of the newest datapoint", test.statistic, got, err, test.want)
+		}
+	}
+
+	svc.datapoints = nil
+	if got, err := getMetricStatistic(svc, "AWS/ELB", "Latency", dimension, cloudwatch.StatisticSum); err != nil || got != 0 {
+		t.Errorf("getMetricStatistic without datapoints = %v, %v, want 0", got, err)
+	}
+}
+
+func TestMigrateToNLBWarnsOnceAboutMetrics(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.opts.targetType = targetTypeNLB
+	tm.opts.defaults.Max5XX = 10
+	tm.addTCPListener()
+	notice := "NLBs report no 5XX responses or latency"
+
+	dryRun := &plan{}
+	if err := runMigrate(withPlan(context.Background(), dryRun), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate dry run: %v", err)
+	}
+	if warnings := strings.Join(dryRun.Warnings, "\n"); strings.Count(warnings, notice) != 1 {
+		t.Errorf("planned warnings = %q, want the missing NLB metrics once", dryRun.Warnings)
+	}
+
+	var buf bytes.Buffer
+	if err := runMigrate(withStdout(context.Background(), &buf), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate: %v", err)
+	}
+	if count := strings.Count(buf.String(), notice); count != 1 {
+		t.Errorf("the missing NLB metrics were noted %d times, want once", count)
+	}
+}
@@ -1,19 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
 )
 
-func getElbDescription(elbName string) *elb.LoadBalancerDescription {
-	fmt.Println("Getting ELB description for elb ", elbName)
-	svc := elb.New(session.New())
+func getElbDescription(ctx context.Context, svc elbiface.ELBAPI, elbName string) (*elb.LoadBalancerDescription, error) {
+	fmt.Fprintln(stdout(ctx), "Getting ELB description for elb ", elbName)
 	input := &elb.DescribeLoadBalancersInput{
 		LoadBalancerNames: []*string{
 			aws.String(elbName),
@@ -22,25 +23,77 @@ func getElbDescription(elbName string) *elb.LoadBalancerDescription {
 
 	result, err := svc.DescribeLoadBalancers(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elb.ErrCodeAccessPointNotFoundException:
-				fmt.Println(elb.ErrCodeAccessPointNotFoundException, aerr.Error())
-			case elb.ErrCodeDependencyThrottleException:
-				fmt.Println(elb.ErrCodeDependencyThrottleException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
+		if isAWSErrorCode(err, elb.ErrCodeAccessPointNotFoundException) {
+			return nil, fmt.Errorf("%w: %s", errLoadBalancerNotFound, elbName)
 		}
+		return nil, newAWSError("DescribeLoadBalancers", elbName, err)
 	}
 	if len(result.LoadBalancerDescriptions) == 0 {
-		return nil
+		return nil, fmt.Errorf("%w: %s", errLoadBalancerNotFound, elbName)
 	}
 	description := result.LoadBalancerDescriptions[0]
 
-	return description
+	return description, nil
+}
+
+// elbDNSNamePattern matches the DNS names AWS gives load balancers, with the
+// load balancer name in the first group: classic ELBs and ALBs, e.g.
+// internal-some-app-1234567890.us-west-2.elb.amazonaws.com, and NLBs, e.g.
+// some-app-0123456789abcdef.elb.us-west-2.amazonaws.com. The dualstack and
+// ipv6 prefixes of alias targets are allowed.
+var elbDNSNamePattern = regexp.MustCompile(`^(?:dualstack\.|ipv6\.)?(?:internal-)?([a-z0-9-]+)-[a-z0-9]+\.(?:[a-z0-9-]+\.elb|elb\.[a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// elbNameFromDNSName returns the load balancer name embedded in an ELB DNS
+// name. DNS names are lower case, so the name may differ in case from the
+// real one.
+func elbNameFromDNSName(dnsName string) (string, bool) {
+	captureGroups := elbDNSNamePattern.FindStringSubmatch(strings.ToLower(strings.TrimSuffix(dnsName, ".")))
+	if captureGroups == nil {
+		return "", false
+	}
+	return captureGroups[1], true
+}
+
+// findElbNameByDNSName returns the name of the ELB with the given DNS name.
+// The name embedded in the DNS name is tried first; when that is not an ELB
+// with this DNS name, every ELB in the region is compared against it.
+func findElbNameByDNSName(ctx context.Context, svc elbiface.ELBAPI, dnsName string) (string, error) {
+	target := strings.ToLower(dnsName)
+	for _, prefix := range []string{"dualstack.", "ipv6."} {
+		target = strings.TrimPrefix(target, prefix)
+	}
+
+	if elbName, ok := elbNameFromDNSName(target); ok {
+		description, err := getElbDescription(ctx, svc, elbName)
+		if err == nil && sameDNSName(aws.StringValue(description.DNSName), target) {
+			return elbName, nil
+		}
+		if err != nil && !errors.Is(err, errLoadBalancerNotFound) {
+			return "", err
+		}
+	}
+
+	fmt.Fprintln(stdout(ctx), "Looking up ELB by DNS name ", target)
+	var elbNames []string
+	err := svc.DescribeLoadBalancersPages(&elb.DescribeLoadBalancersInput{}, func(page *elb.DescribeLoadBalancersOutput, lastPage bool) bool {
+		for _, description := range page.LoadBalancerDescriptions {
+			if sameDNSName(aws.StringValue(description.DNSName), target) {
+				elbNames = append(elbNames, *description.LoadBalancerName)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", newAWSError("DescribeLoadBalancers", target, err)
+	}
+	switch len(elbNames) {
+	case 0:
+		return "", fmt.Errorf("%w: no ELB in the region has DNS name %s", errLoadBalancerNotFound, target)
+	case 1:
+		return elbNames[0], nil
+	default:
+		return "", fmt.Errorf("DNS name %s matches several ELBs (%s), name the source with --source-elb", target, strings.Join(elbNames, ", "))
+	}
 }
 
 func getInstancesFromElbDescription(description elb.LoadBalancerDescription) []*elb.Instance {
@@ -64,99 +117,91 @@ func createLBListenersFromDescription(elbDescription *elb.LoadBalancerDescriptio
 	return listeners
 }
 
-func registerInstancesToElb(loadBalancerName *string, instances []*elb.Instance) {
-	fmt.Println("Registering instances to new ELB...")
-	fmt.Println("Target ELB name: ", *loadBalancerName)
-	fmt.Println("Instances to be attached: ", instances)
-	svc := elb.New(session.New())
+func registerInstancesToElb(ctx context.Context, svc elbiface.ELBAPI, loadBalancerName *string, instances []*elb.Instance) error {
+	fmt.Fprintln(stdout(ctx), "Registering instances to new ELB...")
+	fmt.Fprintln(stdout(ctx), "Target ELB name: ", *loadBalancerName)
+	fmt.Fprintln(stdout(ctx), "Instances to be attached: ", instances)
 	input := &elb.RegisterInstancesWithLoadBalancerInput{
 		Instances:        instances,
 		LoadBalancerName: loadBalancerName,
 	}
+	if planned(ctx, "elb", "RegisterInstancesWithLoadBalancer", fmt.Sprintf("register %d instances with %s", len(instances), *loadBalancerName), input) {
+		return nil
+	}
 
 	_, err := svc.RegisterInstancesWithLoadBalancer(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elb.ErrCodeAccessPointNotFoundException:
-				fmt.Println(elb.ErrCodeAccessPointNotFoundException, aerr.Error())
-			case elb.ErrCodeInvalidEndPointException:
-				fmt.Println(elb.ErrCodeInvalidEndPointException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
-		}
+		return newAWSError("RegisterInstancesWithLoadBalancer", *loadBalancerName, err)
+	}
+
+	return nil
+}
+
+func deregisterInstancesFromElb(ctx context.Context, svc elbiface.ELBAPI, elbName string, instances []*elb.Instance) error {
+	fmt.Fprintln(stdout(ctx), "Deregistering instances from ELB ", elbName)
+	input := &elb.DeregisterInstancesFromLoadBalancerInput{
+		Instances:        instances,
+		LoadBalancerName: aws.String(elbName),
+	}
+	if planned(ctx, "elb", "DeregisterInstancesFromLoadBalancer", fmt.Sprintf("deregister %d instances from %s", len(instances), elbName), input) {
+		return nil
+	}
 
-		return
+	_, err := svc.DeregisterInstancesFromLoadBalancer(input)
+	if err != nil {
+		return newAWSError("DeregisterInstancesFromLoadBalancer", elbName, err)
 	}
+
+	return nil
 }
 
-func describeELBInstanceHealth(elbName string) *elb.DescribeInstanceHealthOutput {
-	fmt.Println("Describing ELB Instance health for ", elbName)
-	svc := elb.New(session.New())
+func describeELBInstanceHealth(ctx context.Context, svc elbiface.ELBAPI, elbName string) (*elb.DescribeInstanceHealthOutput, error) {
+	fmt.Fprintln(stdout(ctx), "Describing ELB Instance health for ", elbName)
 	input := &elb.DescribeInstanceHealthInput{
 		LoadBalancerName: aws.String(elbName),
 	}
 
 	result, err := svc.DescribeInstanceHealth(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elb.ErrCodeAccessPointNotFoundException:
-				fmt.Println(elb.ErrCodeAccessPointNotFoundException, aerr.Error())
-			case elb.ErrCodeInvalidEndPointException:
-				fmt.Println(elb.ErrCodeInvalidEndPointException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, newAWSError("DescribeInstanceHealth", elbName, err)
 	}
 
-	return result
+	return result, nil
 }
 
-func createLoadBalancer(input *elb.CreateLoadBalancerInput) *elb.CreateLoadBalancerOutput {
-	svc := elb.New(session.New())
+func createLoadBalancer(ctx context.Context, svc elbiface.ELBAPI, input *elb.CreateLoadBalancerInput) (*elb.CreateLoadBalancerOutput, error) {
+	if planned(ctx, "elb", "CreateLoadBalancer", "create ELB "+*input.LoadBalancerName, input) {
+		return &elb.CreateLoadBalancerOutput{DNSName: aws.String(plannedValue)}, nil
+	}
 	result, err := svc.CreateLoadBalancer(input)
 	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
+		return nil, newAWSError("CreateLoadBalancer", *input.LoadBalancerName, err)
 	}
 
-	return result
+	return result, nil
 }
 
-func deleteElb(elbName string) *elb.DeleteLoadBalancerOutput {
-	fmt.Println("Deleting ELB...")
-	time.Sleep(5 * time.Second)
-	svc := elb.New(session.New())
+func deleteElb(ctx context.Context, svc elbiface.ELBAPI, elbName string) (*elb.DeleteLoadBalancerOutput, error) {
+	fmt.Fprintln(stdout(ctx), "Deleting ELB...")
 	input := &elb.DeleteLoadBalancerInput{
 		LoadBalancerName: aws.String(elbName),
 	}
+	if planned(ctx, "elb", "DeleteLoadBalancer", "delete ELB "+elbName, input) {
+		return &elb.DeleteLoadBalancerOutput{}, nil
+	}
+	if err := sleep(ctx, 5*time.Second); err != nil {
+		return nil, err
+	}
 
 	result, err := svc.DeleteLoadBalancer(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, newAWSError("DeleteLoadBalancer", elbName, err)
 	}
 
-	return result
+	return result, nil
 }
 
-func describeELBTags(elbName string) *elb.TagDescription {
-	svc := elb.New(session.New())
+func describeELBTags(svc elbiface.ELBAPI, elbName string) (*elb.TagDescription, error) {
 	input := &elb.DescribeTagsInput{
 		LoadBalancerNames: []*string{
 			aws.String(elbName),
@@ -165,213 +210,210 @@ func describeELBTags(elbName string) *elb.TagDescription {
 
 	result, err := svc.DescribeTags(input)
 	if err != nil {
-
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elb.ErrCodeAccessPointNotFoundException:
-				fmt.Println(elb.ErrCodeAccessPointNotFoundException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, newAWSError("DescribeTags", elbName, err)
+	}
+	if len(result.TagDescriptions) == 0 {
+		return &elb.TagDescription{LoadBalancerName: aws.String(elbName)}, nil
 	}
 
-	return result.TagDescriptions[0]
+	return result.TagDescriptions[0], nil
 }
 
-func createLbCookieStickinessPolicy(elbName string, policyName string) {
-	fmt.Println("Creating ELB Cookie Stickiness Policy...")
-	svc := elb.New(session.New())
+// createLbCookieStickinessPolicy creates a load balancer cookie stickiness
+// policy. An expirationPeriod of 0 makes the cookie last for the browser
+// session.
+func createLbCookieStickinessPolicy(ctx context.Context, svc elbiface.ELBAPI, elbName string, policyName string, expirationPeriod int64) error {
+	fmt.Fprintln(stdout(ctx), "Creating ELB Cookie Stickiness Policy...")
 	input := &elb.CreateLBCookieStickinessPolicyInput{
-		CookieExpirationPeriod: aws.Int64(1800),
 		LoadBalancerName: aws.String(elbName),
-		PolicyName: aws.String(policyName),
+		PolicyName:       aws.String(policyName),
+	}
+	if expirationPeriod > 0 {
+		input.CookieExpirationPeriod = aws.Int64(expirationPeriod)
+	}
+	if planned(ctx, "elb", "CreateLBCookieStickinessPolicy", fmt.Sprintf("create cookie stickiness policy %s on %s", policyName, elbName), input) {
+		return nil
 	}
 
 	_, err := svc.CreateLBCookieStickinessPolicy(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elb.ErrCodeAccessPointNotFoundException:
-				fmt.Println(elb.ErrCodeAccessPointNotFoundException, aerr.Error())
-			case elb.ErrCodeDuplicatePolicyNameException:
-				fmt.Println(elb.ErrCodeDuplicatePolicyNameException, aerr.Error())
-			case elb.ErrCodeTooManyPoliciesException:
-				fmt.Println(elb.ErrCodeTooManyPoliciesException, aerr.Error())
-			case elb.ErrCodeInvalidConfigurationRequestException:
-				fmt.Println(elb.ErrCodeInvalidConfigurationRequestException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
-		}
+		return newAWSError("CreateLBCookieStickinessPolicy", elbName+"/"+policyName, err)
+	}
 
-		return
+	return nil
+}
+
+func createAppCookieStickinessPolicy(ctx context.Context, svc elbiface.ELBAPI, elbName string, policyName string, cookieName string) error {
+	fmt.Fprintln(stdout(ctx), "Creating App Cookie Stickiness Policy...")
+	input := &elb.CreateAppCookieStickinessPolicyInput{
+		CookieName:       aws.String(cookieName),
+		LoadBalancerName: aws.String(elbName),
+		PolicyName:       aws.String(policyName),
 	}
+	if planned(ctx, "elb", "CreateAppCookieStickinessPolicy", fmt.Sprintf("create app cookie stickiness policy %s on %s", policyName, elbName), input) {
+		return nil
+	}
+
+	_, err := svc.CreateAppCookieStickinessPolicy(input)
+	if err != nil {
+		return newAWSError("CreateAppCookieStickinessPolicy", elbName+"/"+policyName, err)
+	}
+
+	return nil
 }
 
-func setLoadBalancerPolicesOfListener(elbName string, policyNames []string) {
-	fmt.Println("Setting ELB Policies of listener...")
-	svc := elb.New(session.New())
+func setLoadBalancerPolicesOfListener(ctx context.Context, svc elbiface.ELBAPI, elbName string, port int64, policyNames []string) error {
+	fmt.Fprintln(stdout(ctx), "Setting ELB Policies of listener...")
 	input := &elb.SetLoadBalancerPoliciesOfListenerInput{
 		LoadBalancerName: aws.String(elbName),
-		LoadBalancerPort: aws.Int64(443),
-		PolicyNames:      []*string{},
+		LoadBalancerPort: aws.Int64(port),
+		PolicyNames:      aws.StringSlice(policyNames),
 	}
-	for _, policyName := range policyNames {
-		input.PolicyNames = append(input.PolicyNames, &policyName)
+	fmt.Fprintln(stdout(ctx), "Attempting to add policies to ELB Listener with input: ", input)
+	if planned(ctx, "elb", "SetLoadBalancerPoliciesOfListener", fmt.Sprintf("set policies of listener %d on %s", *input.LoadBalancerPort, elbName), input) {
+		return nil
 	}
-	fmt.Println("Attempting to add policies to ELB Listener with input: ", input)
 
 	_, err := svc.SetLoadBalancerPoliciesOfListener(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elb.ErrCodeAccessPointNotFoundException:
-				fmt.Println(elb.ErrCodeAccessPointNotFoundException, aerr.Error())
-			case elb.ErrCodePolicyNotFoundException:
-				fmt.Println(elb.ErrCodePolicyNotFoundException, aerr.Error())
-			case elb.ErrCodeListenerNotFoundException:
-				fmt.Println(elb.ErrCodeListenerNotFoundException, aerr.Error())
-			case elb.ErrCodeInvalidConfigurationRequestException:
-				fmt.Println(elb.ErrCodeInvalidConfigurationRequestException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
-		}
+		return newAWSError("SetLoadBalancerPoliciesOfListener", fmt.Sprintf("%s:%d", elbName, *input.LoadBalancerPort), err)
+	}
+
+	return nil
+}
 
-		return
+func setLoadBalancerPoliciesForBackendServer(ctx context.Context, svc elbiface.ELBAPI, elbName string, instancePort int64, policyNames []string) error {
+	fmt.Fprintln(stdout(ctx), "Setting ELB Policies of backend server...")
+	input := &elb.SetLoadBalancerPoliciesForBackendServerInput{
+		LoadBalancerName: aws.String(elbName),
+		InstancePort:     aws.Int64(instancePort),
+		PolicyNames:      aws.StringSlice(policyNames),
+	}
+	if planned(ctx, "elb", "SetLoadBalancerPoliciesForBackendServer", fmt.Sprintf("set policies of instance port %d on %s", instancePort, elbName), input) {
+		return nil
 	}
+
+	_, err := svc.SetLoadBalancerPoliciesForBackendServer(input)
+	if err != nil {
+		return newAWSError("SetLoadBalancerPoliciesForBackendServer", fmt.Sprintf("%s:%d", elbName, instancePort), err)
+	}
+
+	return nil
 }
 
-func createELBPolicy(elbName string, policyName string, policyTypeName string, policyAttributes []*elb.PolicyAttributeDescription) {
-	fmt.Println("Creating ELB SSL Policy...")
-	defaultSSLPolicy := "some-elb-security-policy"
-	svc := elb.New(session.New())
+func createELBPolicy(ctx context.Context, svc elbiface.ELBAPI, elbName string, policyName string, policyTypeName string, policyAttributes []*elb.PolicyAttributeDescription) error {
+	fmt.Fprintln(stdout(ctx), "Creating ELB Policy "+policyName+"...")
 	input := &elb.CreateLoadBalancerPolicyInput{
 		LoadBalancerName: aws.String(elbName),
 		PolicyName:       aws.String(policyName),
 		PolicyTypeName:   aws.String(policyTypeName),
-		PolicyAttributes: []*elb.PolicyAttribute{
-			{
-				AttributeName:  aws.String("Reference-Security-Policy"),
-				AttributeValue: aws.String(defaultSSLPolicy),
-			},
-		},
+	}
+	for _, attribute := range policyAttributes {
+		input.PolicyAttributes = append(input.PolicyAttributes, &elb.PolicyAttribute{
+			AttributeName:  attribute.AttributeName,
+			AttributeValue: attribute.AttributeValue,
+		})
+	}
+	if planned(ctx, "elb", "CreateLoadBalancerPolicy", fmt.Sprintf("create policy %s on %s", policyName, elbName), input) {
+		return nil
 	}
 
 	_, err := svc.CreateLoadBalancerPolicy(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elb.ErrCodeAccessPointNotFoundException:
-				fmt.Println(elb.ErrCodeAccessPointNotFoundException, aerr.Error())
-			case elb.ErrCodePolicyTypeNotFoundException:
-				fmt.Println(elb.ErrCodePolicyTypeNotFoundException, aerr.Error())
-			case elb.ErrCodeDuplicatePolicyNameException:
-				fmt.Println(elb.ErrCodeDuplicatePolicyNameException, aerr.Error())
-			case elb.ErrCodeTooManyPoliciesException:
-				fmt.Println(elb.ErrCodeTooManyPoliciesException, aerr.Error())
-			case elb.ErrCodeInvalidConfigurationRequestException:
-				fmt.Println(elb.ErrCodeInvalidConfigurationRequestException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
-		}
-
-		return
+		return newAWSError("CreateLoadBalancerPolicy", elbName+"/"+policyName, err)
 	}
+
+	return nil
 }
 
-func describeELBPolicy(elbName string, policyName string) *elb.PolicyDescription {
-	fmt.Println(fmt.Sprintf("Getting Policy description %s for ELB %s.", policyName, elbName))
-	svc := elb.New(session.New())
+// describeELBPolicies returns every policy of the ELB.
+func describeELBPolicies(svc elbiface.ELBAPI, elbName string) ([]*elb.PolicyDescription, error) {
 	input := &elb.DescribeLoadBalancerPoliciesInput{
 		LoadBalancerName: aws.String(elbName),
-		PolicyNames: []*string{
-			aws.String(policyName),
-		},
 	}
 
 	result, err := svc.DescribeLoadBalancerPolicies(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elb.ErrCodeAccessPointNotFoundException:
-				fmt.Println(elb.ErrCodeAccessPointNotFoundException, aerr.Error())
-			case elb.ErrCodePolicyNotFoundException:
-				fmt.Println(elb.ErrCodePolicyNotFoundException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
-		}
+		return nil, newAWSError("DescribeLoadBalancerPolicies", elbName, err)
+	}
+
+	return result.PolicyDescriptions, nil
+}
+
+func describeELBAttributes(svc elbiface.ELBAPI, elbName string) (*elb.LoadBalancerAttributes, error) {
+	input := &elb.DescribeLoadBalancerAttributesInput{
+		LoadBalancerName: aws.String(elbName),
+	}
+
+	result, err := svc.DescribeLoadBalancerAttributes(input)
+	if err != nil {
+		return nil, newAWSError("DescribeLoadBalancerAttributes", elbName, err)
+	}
+
+	return result.LoadBalancerAttributes, nil
+}
+
+func modifyELBAttributes(ctx context.Context, svc elbiface.ELBAPI, elbName string, attributes *elb.LoadBalancerAttributes) error {
+	fmt.Fprintln(stdout(ctx), "Modifying ELB attributes...")
+	input := &elb.ModifyLoadBalancerAttributesInput{
+		LoadBalancerName:       aws.String(elbName),
+		LoadBalancerAttributes: attributes,
+	}
+	if planned(ctx, "elb", "ModifyLoadBalancerAttributes", "modify attributes of "+elbName, input) {
 		return nil
 	}
-	var targetPolicy *elb.PolicyDescription
-	for _, policyDescription := range result.PolicyDescriptions {
-		if *policyDescription.PolicyName == policyName {
-			targetPolicy = policyDescription
-		}
+
+	_, err := svc.ModifyLoadBalancerAttributes(input)
+	if err != nil {
+		return newAWSError("ModifyLoadBalancerAttributes", elbName, err)
 	}
 
-	return targetPolicy
+	return nil
 }
 
-func configureHealthCheck(input *elb.ConfigureHealthCheckInput) {
-	fmt.Println("Configuring Health Check...")
-	svc := elb.New(session.New())
+func configureHealthCheck(ctx context.Context, svc elbiface.ELBAPI, input *elb.ConfigureHealthCheckInput) error {
+	fmt.Fprintln(stdout(ctx), "Configuring Health Check...")
+	if planned(ctx, "elb", "ConfigureHealthCheck", "configure health check of "+*input.LoadBalancerName, input) {
+		return nil
+	}
 	_, err := svc.ConfigureHealthCheck(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case elb.ErrCodeAccessPointNotFoundException:
-				fmt.Println(elb.ErrCodeAccessPointNotFoundException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
-		}
-
-		return
+		return newAWSError("ConfigureHealthCheck", *input.LoadBalancerName, err)
 	}
+
+	return nil
 }
 
-func waitForELBInstanceInService(elbName string) {
-	fmt.Println("Waiting for `InService` ELB instances states...")
+func waitForELBInstanceInService(ctx context.Context, svc elbiface.ELBAPI, elbName string) error {
+	fmt.Fprintln(stdout(ctx), "Waiting for `InService` ELB instances states...")
+	if planOf(ctx) != nil {
+		return nil
+	}
 	maxTries := 40
 	tries := 0
 	for {
-		healthOutput := describeELBInstanceHealth(elbName)
+		healthOutput, err := describeELBInstanceHealth(ctx, svc, elbName)
+		if err != nil {
+			return err
+		}
 		tries++
 		instancesInService := true
 		for _, instanceState := range healthOutput.InstanceStates {
 			instanceInService := (*instanceState.State == "InService")
 			instancesInService = instanceInService && instancesInService
 		}
-		if instancesInService == false {
-			fmt.Println("Instances not in service yet. Waiting 5s and trying again.")
-			time.Sleep(5 * time.Second)
-
-		} else {
-			fmt.Println("Instances in service.")
-			break
+		if instancesInService {
+			fmt.Fprintln(stdout(ctx), "Instances in service.")
+			return nil
 		}
 
 		if tries == maxTries {
-			fmt.Println("Reached maximum number of retries for instances to become healthy. Stopping.")
-			deleteElb(elbName)
-			break
+			fmt.Fprintln(stdout(ctx), "Reached maximum number of retries for instances to become healthy. Stopping.")
+			return fmt.Errorf("instances of %s did not come into service after %d checks", elbName, maxTries)
+		}
+		fmt.Fprintln(stdout(ctx), "Instances not in service yet. Waiting 5s and trying again.")
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
 		}
 	}
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+)
+
+func describeLoadBalancerV2(ctx context.Context, svc elbv2iface.ELBV2API, name string) (*elbv2.LoadBalancer, error) {
+	fmt.Fprintln(stdout(ctx), "Getting load balancer description for ", name)
+	input := &elbv2.DescribeLoadBalancersInput{
+		Names: []*string{
+			aws.String(name),
+		},
+	}
+
+	result, err := svc.DescribeLoadBalancers(input)
+	if err != nil {
+		if isAWSErrorCode(err, elbv2.ErrCodeLoadBalancerNotFoundException) {
+			return nil, fmt.Errorf("%w: %s", errLoadBalancerNotFound, name)
+		}
+		return nil, newAWSError("DescribeLoadBalancers", name, err)
+	}
+	if len(result.LoadBalancers) == 0 {
+		return nil, fmt.Errorf("%w: %s", errLoadBalancerNotFound, name)
+	}
+
+	return result.LoadBalancers[0], nil
+}
+
+func createLoadBalancerV2(ctx context.Context, svc elbv2iface.ELBV2API, input *elbv2.CreateLoadBalancerInput) (*elbv2.LoadBalancer, error) {
+	fmt.Fprintln(stdout(ctx), "Creating load balancer ", *input.Name)
+	if planned(ctx, "elbv2", "CreateLoadBalancer", fmt.Sprintf("create %s load balancer %s", *input.Type, *input.Name), input) {
+		return &elbv2.LoadBalancer{
+			LoadBalancerName:      input.Name,
+			LoadBalancerArn:       aws.String(plannedValue),
+			DNSName:               aws.String(plannedValue),
+			CanonicalHostedZoneId: aws.String(plannedValue),
+		}, nil
+	}
+
+	result, err := svc.CreateLoadBalancer(input)
+	if err != nil {
+		return nil, newAWSError("CreateLoadBalancer", *input.Name, err)
+	}
+	if len(result.LoadBalancers) == 0 {
+		return nil, fmt.Errorf("CreateLoadBalancer %s returned no load balancer", *input.Name)
+	}
+
+	return result.LoadBalancers[0], nil
+}
+
+func deleteLoadBalancerV2(ctx context.Context, svc elbv2iface.ELBV2API, loadBalancer *elbv2.LoadBalancer) error {
+	fmt.Fprintln(stdout(ctx), "Deleting load balancer ", *loadBalancer.LoadBalancerName)
+	input := &elbv2.DeleteLoadBalancerInput{
+		LoadBalancerArn: loadBalancer.LoadBalancerArn,
+	}
+	if planned(ctx, "elbv2", "DeleteLoadBalancer", "delete load balancer "+*loadBalancer.LoadBalancerName, input) {
+		return nil
+	}
+	if err := sleep(ctx, 5*time.Second); err != nil {
+		return err
+	}
+
+	_, err := svc.DeleteLoadBalancer(input)
+	if err != nil {
+		return newAWSError("DeleteLoadBalancer", *loadBalancer.LoadBalancerName, err)
+	}
+
+	return nil
+}
+
+func createTargetGroup(ctx context.Context, svc elbv2iface.ELBV2API, input *elbv2.CreateTargetGroupInput) (*elbv2.TargetGroup, error) {
+	fmt.Fprintln(stdout(ctx), "Creating target group ", *input.Name)
+	if planned(ctx, "elbv2", "CreateTargetGroup", fmt.Sprintf("create target group %s (%s:%d)", *input.Name, *input.Protocol, *input.Port), input) {
+		return &elbv2.TargetGroup{
+			TargetGroupName: input.Name,
+			TargetGroupArn:  aws.String(plannedValue),
+			Port:            input.Port,
+		}, nil
+	}
+
+	result, err := svc.CreateTargetGroup(input)
+	if err != nil {
+		return nil, newAWSError("CreateTargetGroup", *input.Name, err)
+	}
+	if len(result.TargetGroups) == 0 {
+		return nil, fmt.Errorf("CreateTargetGroup %s returned no target group", *input.Name)
+	}
+
+	return result.TargetGroups[0], nil
+}
+
+func describeTargetGroupsOfLoadBalancer(svc elbv2iface.ELBV2API, loadBalancerArn string) ([]*elbv2.TargetGroup, error) {
+	input := &elbv2.DescribeTargetGroupsInput{
+		LoadBalancerArn: aws.String(loadBalancerArn),
+	}
+
+	var targetGroups []*elbv2.TargetGroup
+	err := svc.DescribeTargetGroupsPages(input, func(page *elbv2.DescribeTargetGroupsOutput, lastPage bool) bool {
+		targetGroups = append(targetGroups, page.TargetGroups...)
+		return true
+	})
+	if err != nil {
+		return nil, newAWSError("DescribeTargetGroups", loadBalancerArn, err)
+	}
+
+	return targetGroups, nil
+}
+
+// deleteTargetGroup deletes a target group. A target group cannot be deleted
+// while the load balancer it was attached to is still being deleted, so that
+// error is retried for a while.
+func deleteTargetGroup(ctx context.Context, svc elbv2iface.ELBV2API, targetGroupArn string) error {
+	fmt.Fprintln(stdout(ctx), "Deleting target group ", targetGroupArn)
+	input := &elbv2.DeleteTargetGroupInput{
+		TargetGroupArn: aws.String(targetGroupArn),
+	}
+	if planned(ctx, "elbv2", "DeleteTargetGroup", "delete target group "+targetGroupArn, input) {
+		return nil
+	}
+
+	maxTries := 12
+	for tries := 1; ; tries++ {
+		_, err := svc.DeleteTargetGroup(input)
+		if err == nil {
+			return nil
+		}
+		if !isAWSErrorCode(err, elbv2.ErrCodeResourceInUseException) || tries == maxTries {
+			return newAWSError("DeleteTargetGroup", targetGroupArn, err)
+		}
+		fmt.Fprintln(stdout(ctx), "Target group still in use. Waiting 5s and trying again.")
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+func modifyTargetGroupAttributes(ctx context.Context, svc elbv2iface.ELBV2API, targetGroup *elbv2.TargetGroup, attributes []*elbv2.TargetGroupAttribute) error {
+	fmt.Fprintln(stdout(ctx), "Modifying attributes of target group ", *targetGroup.TargetGroupName)
+	input := &elbv2.ModifyTargetGroupAttributesInput{
+		TargetGroupArn: targetGroup.TargetGroupArn,
+		Attributes:     attributes,
+	}
+	if planned(ctx, "elbv2", "ModifyTargetGroupAttributes", "modify attributes of target group "+*targetGroup.TargetGroupName, input) {
+		return nil
+	}
+
+	_, err := svc.ModifyTargetGroupAttributes(input)
+	if err != nil {
+		return newAWSError("ModifyTargetGroupAttributes", *targetGroup.TargetGroupName, err)
+	}
+
+	return nil
+}
+
+func modifyLoadBalancerAttributesV2(ctx context.Context, svc elbv2iface.ELBV2API, loadBalancer *elbv2.LoadBalancer, attributes []*elbv2.LoadBalancerAttribute) error {
+	fmt.Fprintln(stdout(ctx), "Modifying attributes of load balancer ", *loadBalancer.LoadBalancerName)
+	input := &elbv2.ModifyLoadBalancerAttributesInput{
+		LoadBalancerArn: loadBalancer.LoadBalancerArn,
+		Attributes:      attributes,
+	}
+	if planned(ctx, "elbv2", "ModifyLoadBalancerAttributes", "modify attributes of load balancer "+*loadBalancer.LoadBalancerName, input) {
+		return nil
+	}
+
+	_, err := svc.ModifyLoadBalancerAttributes(input)
+	if err != nil {
+		return newAWSError("ModifyLoadBalancerAttributes", *loadBalancer.LoadBalancerName, err)
+	}
+
+	return nil
+}
+
+func createListenerV2(ctx context.Context, svc elbv2iface.ELBV2API, input *elbv2.CreateListenerInput) error {
+	fmt.Fprintln(stdout(ctx), "Creating listener on port ", *input.Port)
+	if planned(ctx, "elbv2", "CreateListener", fmt.Sprintf("create %s listener on port %d", *input.Protocol, *input.Port), input) {
+		return nil
+	}
+
+	_, err := svc.CreateListener(input)
+	if err != nil {
+		return newAWSError("CreateListener", fmt.Sprintf("%s:%d", *input.LoadBalancerArn, *input.Port), err)
+	}
+
+	return nil
+}
+
+func registerTargets(ctx context.Context, svc elbv2iface.ELBV2API, targetGroup *elbv2.TargetGroup, targets []*elbv2.TargetDescription) error {
+	fmt.Fprintln(stdout(ctx), "Registering targets with target group ", *targetGroup.TargetGroupName)
+	input := &elbv2.RegisterTargetsInput{
+		TargetGroupArn: targetGroup.TargetGroupArn,
+		Targets:        targets,
+	}
+	if planned(ctx, "elbv2", "RegisterTargets", fmt.Sprintf("register %d targets with %s", len(targets), *targetGroup.TargetGroupName), input) {
+		return nil
+	}
+
+	_, err := svc.RegisterTargets(input)
+	if err != nil {
+		return newAWSError("RegisterTargets", *targetGroup.TargetGroupName, err)
+	}
+
+	return nil
+}
+
+func describeTargetHealth(svc elbv2iface.ELBV2API, targetGroup *elbv2.TargetGroup) (*elbv2.DescribeTargetHealthOutput, error) {
+	input := &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: targetGroup.TargetGroupArn,
+	}
+
+	result, err := svc.DescribeTargetHealth(input)
+	if err != nil {
+		return nil, newAWSError("DescribeTargetHealth", *targetGroup.TargetGroupName, err)
+	}
+
+	return result, nil
+}
+
+func waitForTargetsHealthy(ctx context.Context, svc elbv2iface.ELBV2API, targetGroups []*elbv2.TargetGroup) error {
+	fmt.Fprintln(stdout(ctx), "Waiting for `healthy` target states...")
+	if planOf(ctx) != nil {
+		return nil
+	}
+	maxTries := 40
+	tries := 0
+	for {
+		tries++
+		targetsHealthy := true
+		for _, targetGroup := range targetGroups {
+			healthOutput, err := describeTargetHealth(svc, targetGroup)
+			if err != nil {
+				return err
+			}
+			for _, description := range healthOutput.TargetHealthDescriptions {
+				targetsHealthy = targetsHealthy && aws.StringValue(description.TargetHealth.State) == elbv2.TargetHealthStateEnumHealthy
+			}
+		}
+		if targetsHealthy {
+			fmt.Fprintln(stdout(ctx), "Targets healthy.")
+			return nil
+		}
+
+		if tries == maxTries {
+			fmt.Fprintln(stdout(ctx), "Reached maximum number of retries for targets to become healthy. Stopping.")
+			return fmt.Errorf("targets did not become healthy after %d checks", maxTries)
+		}
+		fmt.Fprintln(stdout(ctx), "Targets not healthy yet. Waiting 5s and trying again.")
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestElbNameFromDNSName(t *testing.T) {
+	tests := []struct {
+		dnsName string
+		want    string
+	}{
+		{"some-app-1234567890.us-west-2.elb.amazonaws.com", "some-app"},
+		{"internal-some-app-1234567890.us-west-2.elb.amazonaws.com.", "some-app"},
+		{"dualstack.some-app-1234567890.us-west-2.elb.amazonaws.com.", "some-app"},
+		{"ipv6.internal-some-app-1234567890.eu-west-1.elb.amazonaws.com", "some-app"},
+		{"Some-App-1234567890.US-West-2.elb.amazonaws.com", "some-app"},
+		{"some-nlb-0123456789abcdef.elb.us-east-1.amazonaws.com", "some-nlb"},
+		{"some-app-1234567890.cn-north-1.elb.amazonaws.com.cn", "some-app"},
+		{"www.example.com", ""},
+	}
+	for _, test := range tests {
+		got, ok := elbNameFromDNSName(test.dnsName)
+		if got != test.want || ok != (test.want != "") {
+			t.Errorf("elbNameFromDNSName(%q) = %q, %v, want %q", test.dnsName, got, ok, test.want)
+		}
+	}
+}
+
+func TestFindElbNameByDNSName(t *testing.T) {
+	tm := newTestMigration(t)
+
+	elbName, err := findElbNameByDNSName(context.Background(), tm.elb, "dualstack."+testSourceDNSName+".")
+	if err != nil || elbName != "app" {
+		t.Errorf("findElbNameByDNSName = %q, %v, want app", elbName, err)
+	}
+}
+
+func TestFindElbNameByDNSNameFallback(t *testing.T) {
+	tm := newTestMigration(t)
+	// DNS names are lower case, so the name in them does not find App.
+	source := tm.elb.loadBalancers["app"]
+	delete(tm.elb.loadBalancers, "app")
+	source.LoadBalancerName = aws.String("App")
+	tm.elb.addLoadBalancer(source, nil, nil)
+
+	elbName, err := findElbNameByDNSName(context.Background(), tm.elb, testSourceDNSName)
+	if err != nil || elbName != "App" {
+		t.Errorf("findElbNameByDNSName = %q, %v, want App", elbName, err)
+	}
+
+	_, err = findElbNameByDNSName(context.Background(), tm.elb, "www.example.com")
+	if !errors.Is(err, errLoadBalancerNotFound) {
+		t.Errorf("findElbNameByDNSName of a name no ELB has = %v, want errLoadBalancerNotFound", err)
+	}
+}
@@ -1,30 +1,35 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/route53"
 )
 
-var config = map[string]map[string]map[string][]string{
-	"some-environment": {
-		"us-west-2": {
-			"vpc-23456789": []string{"sg-12345678", "sg-34567890"},
-		},
-		"us-east-1": {
-			"vpc-12345678": []string{"sg-12345677", "sg-22334455", "sg-33224411"},
-		},
-	},
-}
-
-func replicateElb(envConfig map[string]string, sourceElbName string, newElbName string) *elb.CreateLoadBalancerInput {
-	fmt.Println("Replicating ELB: ", sourceElbName)
-	region := envConfig["region"]
-	env := envConfig["environment"]
+func replicateElb(ctx context.Context, c *clients, opts *options, sourceElbName string, newElbName string) (*elb.CreateLoadBalancerInput, error) {
+	fmt.Fprintln(stdout(ctx), "Replicating ELB: ", sourceElbName)
+	svc := c.elb
+	replicaSvc := c.forReplica().elb
 
-	sourceELBDescription := getElbDescription(sourceElbName)
+	sourceELBDescription, err := getElbDescription(ctx, svc, sourceElbName)
+	if err != nil {
+		return nil, err
+	}
+	sourceELBDescription, err = forTarget(opts, sourceELBDescription)
+	if err != nil {
+		return nil, err
+	}
+	if err := replicaCertificates(ctx, c, opts, sourceELBDescription); err != nil {
+		return nil, err
+	}
 
 	elbInput := &elb.CreateLoadBalancerInput{}
 	elbName := newElbName
@@ -32,20 +37,38 @@ func replicateElb(envConfig map[string]string, sourceElbName string, newElbName
 	elbInput.SetLoadBalancerName(elbName)
 	elbInput.SetListeners(createLBListenersFromDescription(sourceELBDescription))
 
-	elbInput.SetScheme("internet-facing")
-	newSecurityGroups := []*string{}
-	for _, sg := range config[env][region][*sourceELBDescription.VPCId] {
-		newSecurityGroups = append(newSecurityGroups, aws.String(sg))
+	elbInput.SetScheme(replicaScheme(ctx, opts, sourceELBDescription))
+	securityGroups, err := replicaSecurityGroups(c.forReplica().ec2, opts, sourceELBDescription)
+	if err != nil {
+		return nil, err
 	}
+	elbInput.SetSecurityGroups(aws.StringSlice(securityGroups))
+	subnets, err := replicaSubnets(ctx, c.forReplica().ec2, opts, sourceELBDescription)
+	if err != nil {
+		return nil, err
+	}
+	elbInput.SetSubnets(aws.StringSlice(subnets))
 
-	elbInput.SetSecurityGroups(newSecurityGroups)
-	elbInput.SetSubnets(sourceELBDescription.Subnets)
-
-	tags := describeELBTags(*sourceELBDescription.LoadBalancerName)
+	tags, err := describeELBTags(svc, *sourceELBDescription.LoadBalancerName)
+	if err != nil {
+		return nil, err
+	}
 	elbInput.SetTags(tags.Tags)
 
-	output := createLoadBalancer(elbInput)
-	fmt.Println("ELB create output: ", output)
+	policies, err := describeELBPolicies(svc, sourceElbName)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := describeELBAttributes(svc, sourceElbName)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := createLoadBalancer(ctx, replicaSvc, elbInput)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(stdout(ctx), "ELB create output: ", output)
 
 	// Post elb creation configuration steps
 
@@ -53,81 +76,491 @@ func replicateElb(envConfig map[string]string, sourceElbName string, newElbName
 	healthCheckInput := &elb.ConfigureHealthCheckInput{}
 	healthCheckInput.SetHealthCheck(sourceELBDescription.HealthCheck)
 	healthCheckInput.SetLoadBalancerName(elbName)
-	configureHealthCheck(healthCheckInput)
+	if err := configureHealthCheck(ctx, replicaSvc, healthCheckInput); err != nil {
+		return elbInput, err
+	}
+
+	// Copy connection draining, idle timeout, cross-zone load balancing,
+	// access logs and any additional attributes
+	if err := modifyELBAttributes(ctx, replicaSvc, elbName, attributes); err != nil {
+		return elbInput, err
+	}
 
 	// Attach Policies
-	LBCookieStickinessPolices := sourceELBDescription.Policies.LBCookieStickinessPolicies
-	for _, cookiePolicy := range LBCookieStickinessPolices {
-		createLbCookieStickinessPolicy(elbName, *cookiePolicy.PolicyName)
+	if err := replicatePolicies(ctx, replicaSvc, opts, sourceELBDescription, policies, elbName); err != nil {
+		return elbInput, err
 	}
-	policyDescription := describeELBPolicy(sourceElbName, "some-elb-policy-name")
-	createELBPolicy(elbName, "SSLNegotiationPolicy-443", "SSLNegotiationPolicyType", policyDescription.PolicyAttributeDescriptions)
-	setLoadBalancerPolicesOfListener(elbName, []string{"SSLNegotiationPolicy-443"})
 
 	// Attach instances
 	instances := getInstancesFromElbDescription(*sourceELBDescription)
-	registerInstancesToElb(&elbName, instances)
+	if err := registerInstancesToElb(ctx, replicaSvc, &elbName, instances); err != nil {
+		return elbInput, err
+	}
 
-	waitForELBInstanceInService(elbName)
-	return elbInput
+	return elbInput, waitForELBInstanceInService(ctx, replicaSvc, elbName)
 }
 
 func main() {
-
-	// These values need to be configured prior to running.
-	envConfig := map[string]string{
-		"environment": "some-env",
-		"region":      "us-west-2",
-		"zoneValue":   "test.example.com.",
-		"cnameValue":  "some-app.test.example.com",
+	opts, err := parseOptions(os.Args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fmt.Println(err)
+		os.Exit(2)
 	}
+	c := newClients(opts)
+	ctx := context.Background()
+	var dryRun *plan
+	if opts.dryRun {
+		dryRun = &plan{}
+		ctx = withPlan(ctx, dryRun)
+	}
+
+	// The first Ctrl-C cancels the run and lets it roll back; restoring the
+	// default handler means a second one kills the process.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	zone := findHostedZone(envConfig["zoneValue"])
-	if zone == nil {
+	runErr := findCommand(opts.command).run(ctx, c, opts)
+	// The plan of an interrupted run is incomplete.
+	if dryRun != nil && ctx.Err() == nil {
+		dryRun.print(os.Stdout)
+		if opts.planJSONPath != "" {
+			if err := dryRun.writeJSON(opts.planJSONPath); err != nil {
+				fmt.Println("Writing plan:", err)
+				os.Exit(1)
+			}
+			fmt.Println("Plan written to", opts.planJSONPath)
+		}
+	}
+	if runErr != nil {
+		fmt.Println(runErr)
 		os.Exit(1)
 	}
-	cname := envConfig["cnameValue"]
+}
+
+// replicaLoadBalancer is what the green record set needs to know about the
+// replica, whichever kind of load balancer it is.
+type replicaLoadBalancer struct {
+	name                  string
+	dnsName               string
+	canonicalHostedZoneID string
+}
+
+// getReplica looks up the replica of the given target type.
+func getReplica(ctx context.Context, c *clients, targetType string, elbReplicaName string) (*replicaLoadBalancer, error) {
+	c = c.forReplica()
+	if !isTargetTypeV2(targetType) {
+		description, err := getElbDescription(ctx, c.elb, elbReplicaName)
+		if err != nil {
+			return nil, err
+		}
+		return &replicaLoadBalancer{
+			name:                  elbReplicaName,
+			dnsName:               aws.StringValue(description.DNSName),
+			canonicalHostedZoneID: aws.StringValue(description.CanonicalHostedZoneNameID),
+		}, nil
+	}
+	loadBalancer, err := describeLoadBalancerV2(ctx, c.elbv2, elbReplicaName)
+	if err != nil {
+		return nil, err
+	}
+	return &replicaLoadBalancer{
+		name:                  elbReplicaName,
+		dnsName:               aws.StringValue(loadBalancer.DNSName),
+		canonicalHostedZoneID: aws.StringValue(loadBalancer.CanonicalHostedZoneId),
+	}, nil
+}
+
+// describeReplica returns the replica once it has been created. During a dry
+// run the replica was never created, so a placeholder is returned instead.
+func describeReplica(ctx context.Context, c *clients, targetType string, elbReplicaName string) (*replicaLoadBalancer, error) {
+	if planOf(ctx) != nil {
+		return &replicaLoadBalancer{
+			name:                  elbReplicaName,
+			dnsName:               plannedValue,
+			canonicalHostedZoneID: plannedValue,
+		}, nil
+	}
+	return getReplica(ctx, c, targetType, elbReplicaName)
+}
+
+func runMigrate(ctx context.Context, c *clients, opts *options) error {
+	m := newMigration(c, opts)
+	if planOf(ctx) == nil {
+		// A state file that cannot be read may be the only record of an
+		// interrupted migration, so it is never overwritten.
+		state, err := loadState(opts.statePath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("%v; fix or remove it before starting a new migration", err)
+		}
+		if err == nil && !state.finished(migrateSteps) {
+			return fmt.Errorf("%s holds an unfinished migration, continue it with resume or remove the file", opts.statePath)
+		}
+		m.statePath = opts.statePath
+	}
+	return m.run(ctx, migrateSteps...)
+}
+
+// runResume continues the migration recorded in the state file from the
+// first step that did not complete.
+func runResume(ctx context.Context, c *clients, opts *options) error {
+	state, err := loadState(opts.statePath)
+	if err != nil {
+		return err
+	}
+	if state.RolledBack {
+		return fmt.Errorf("the migration in %s was rolled back, start a new one with migrate", opts.statePath)
+	}
+	if state.finished(migrateSteps) {
+		fmt.Fprintln(stdout(ctx), "The migration in", opts.statePath, "already completed.")
+		return nil
+	}
+
+	m := newMigration(c, opts)
+	if planOf(ctx) == nil {
+		m.statePath = opts.statePath
+	}
+	if err := m.restore(ctx, state); err != nil {
+		return fmt.Errorf("restoring migration from %s: %w", opts.statePath, err)
+	}
+	return m.run(ctx, migrateSteps...)
+}
+
+func runReplicate(ctx context.Context, c *clients, opts *options) error {
+	m := newMigration(c, opts)
+	return m.run(ctx, stepDiscover, stepReplicate)
+}
+
+func runShift(ctx context.Context, c *clients, opts *options) error {
+	m := newMigration(c, opts)
+	return m.run(ctx, stepDiscover, stepFindReplica, stepCreateGreenRecord, stepShift)
+}
+
+func runDelete(ctx context.Context, c *clients, opts *options) error {
+	zone, err := findHostedZone(ctx, c.route53, opts.zone)
+	if err != nil {
+		return err
+	}
+	elbName := opts.sourceElb
+	if elbName == "" {
+		return fmt.Errorf("delete requires --source-elb, the ELB that no longer receives traffic")
+	}
+	description, err := getElbDescription(ctx, c.elb, elbName)
+	if err != nil {
+		return err
+	}
+
+	pairs, err := findBlueGreens(ctx, c, opts, zone, *description.DNSName, "")
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		if pair.blue.Weight == nil {
+			return fmt.Errorf("record set %s is a simple record set that sends all traffic to %s, migrate it first", *pair.blue.Name, elbName)
+		}
+		if *pair.blue.Weight > 0 {
+			return fmt.Errorf("record set %s still sends weight %d to %s, shift traffic first", *pair.blue.Name, *pair.blue.Weight, elbName)
+		}
+	}
 
-	elbName := findElbNameFromDNSRecordSet(*zone.Name, cname)
+	if err := replConfirmation(ctx, "Proceed with deletion of ELB "+elbName+"?"); err != nil {
+		return err
+	}
+	if _, err := deleteElb(ctx, c.elb, elbName); err != nil {
+		return err
+	}
 
-	elbReplicaName := elbName + "-r"
-	fmt.Println("Found ELB " + elbName)
-	replConfirmation("Proceed with ELB replication? ")
+	for _, pair := range pairs {
+		fmt.Fprintln(stdout(ctx), pair.blue)
+		if err := replConfirmation(ctx, "Proceed with deletion of preceding recordset?"); err != nil {
+			return err
+		}
+		if err := deleteRecordSet(ctx, c.route53, *pair.blue.Name, pair.zone, pair.blue); err != nil {
+			return fmt.Errorf("ELB %s was deleted but its record set was not: %w", elbName, err)
+		}
+	}
+	return nil
+}
+
+// runPlan runs a full migration as a dry run.
+func runPlan(ctx context.Context, c *clients, opts *options) error {
+	return runMigrate(ctx, c, opts)
+}
+
+// runRollback moves the traffic of a migration back to the source ELB and
+// deletes the green record sets and the replica. When the migration's state
+// file is there, every blue record set also gets back the weight it had, and
+// one that was a simple record set becomes one again.
+func runRollback(ctx context.Context, c *clients, opts *options) error {
+	zone, err := findHostedZone(ctx, c.route53, opts.zone)
+	if err != nil {
+		return err
+	}
+	elbName, err := findSourceElbName(ctx, c, opts)
+	if err != nil {
+		return err
+	}
+	state, err := loadState(opts.statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if state != nil && state.SourceElb != elbName {
+		fmt.Fprintf(stdout(ctx), "Ignoring %s, it holds a migration of %s, not of %s.\n", opts.statePath, state.SourceElb, elbName)
+		state = nil
+	}
+	elbReplicaName := opts.replicaName(elbName)
+	sourceDescription, err := getElbDescription(ctx, c.elb, elbName)
+	if err != nil {
+		return fmt.Errorf("cannot roll back to source ELB: %w", err)
+	}
+	replica, err := getReplica(ctx, c, opts.targetType, elbReplicaName)
+	if err != nil {
+		return err
+	}
 
-	replicateElb(envConfig, elbName, elbReplicaName)
-	description := getElbDescription(elbReplicaName)
+	pairs, err := findBlueGreens(ctx, c, opts, zone, *sourceDescription.DNSName, replica.dnsName)
+	if err != nil {
+		return err
+	}
+	// Traffic moves back from the replica, so its record sets are the blue
+	// ones of the reverse shift.
+	var reversed []*blueGreen
+	var sourceRecordSets []*route53.ResourceRecordSet
+	for _, pair := range pairs {
+		if pair.green == nil {
+			continue
+		}
+		if pair.blue == nil {
+			return fmt.Errorf("no record set %s points at %s, refusing to remove the green record set", *pair.green.Name, elbName)
+		}
+		reversed = append(reversed, &blueGreen{zone: pair.zone, blue: pair.green, green: pair.blue})
+		sourceRecordSets = append(sourceRecordSets, pair.blue)
+	}
 
-	// Determine Blue Resource Record Set
-	blueResourceRecordSet := findResourceRecord(cname, zone, nil)
-	fmt.Println("Found blue resource record set: ", blueResourceRecordSet)
+	m := newMigration(c, opts)
+	if len(reversed) > 0 {
+		if err := replConfirmation(ctx, "Proceed with blue/green back to "+elbName+"? "); err != nil {
+			return err
+		}
+		if err := weightedBlueGreen(ctx, c.route53, reversed, linearSchedule(aws.Int64Value(opts.defaults.BleedStep)), bakeTime(ctx, opts.defaults, sourceRecordSets...), nil, nil); err != nil {
+			return err
+		}
+		for _, pair := range reversed {
+			fmt.Fprintln(stdout(ctx), pair.blue)
+			record, saved := state.record(*pair.zone.Id, *pair.green.Name, *pair.green.Type)
+			if saved && record.BlueWasSimple {
+				fmt.Fprintln(stdout(ctx), "The record set was a simple one before the migration and is restored as such.")
+			}
+			if err := replConfirmation(ctx, "Proceed with deletion of green record set?"); err != nil {
+				return err
+			}
+			if !saved {
+				if err := deleteRecordSet(ctx, c.route53, *pair.blue.Name, pair.zone, pair.blue); err != nil {
+					return err
+				}
+				continue
+			}
+			r := &recordMigration{
+				zone:               pair.zone,
+				blue:               pair.green,
+				green:              pair.blue,
+				blueOriginalWeight: record.BlueOriginalWeight,
+				blueWasSimple:      record.BlueWasSimple,
+			}
+			if err := m.restoreBlue(ctx, r); err != nil {
+				return err
+			}
+		}
+	}
 
-	// Create green record set whose target is the newly created ELB
-	greenResourceRecordSet := createResourceRecordSet(cname, *description.DNSName, *blueResourceRecordSet.SetIdentifier+"-r")
-	greenCreateChangeOutput := changeResourceRecordSet("CREATE", greenResourceRecordSet, *zone)
-	fmt.Println(greenCreateChangeOutput)
+	if err := replConfirmation(ctx, "Proceed with deletion of replica ELB "+elbReplicaName+"?"); err != nil {
+		return err
+	}
+	m.replicaElbName = elbReplicaName
+	if err := m.deleteReplica(ctx); err != nil {
+		return err
+	}
+	if state != nil && planOf(ctx) == nil {
+		state.RolledBack = true
+		if err := saveState(opts.statePath, state); err != nil {
+			fmt.Fprintln(stdout(ctx), "Warning: could not save migration state:", err)
+		}
+	}
+	return nil
+}
 
-	// Perform Blue/Green release
-	replConfirmation("Proceed with blue/green? ")
-	weightedBlueGreen(blueResourceRecordSet, greenResourceRecordSet, zone)
+// findBlueGreens pairs up by name and type the record sets named by --cname,
+// or with --all-records those of every hosted zone, that point at the source
+// ELB and at its replica. Either record set of a pair may be nil; with no
+// replicaDNSName every green is.
+func findBlueGreens(ctx context.Context, c *clients, opts *options, zone *route53.HostedZone, sourceDNSName string, replicaDNSName string) ([]*blueGreen, error) {
+	zones := []*route53.HostedZone{zone}
+	recordType := opts.recordType
+	if opts.allRecords {
+		var err error
+		zones, err = listHostedZones(c.route53)
+		if err != nil {
+			return nil, err
+		}
+		recordType = ""
+	}
 
-	// Delete Original ELB after release
-	replConfirmation("Proceed with deletion of ELB " + elbName + "?")
-	deleteElb(elbName)
+	var pairs []*blueGreen
+	for _, zone := range zones {
+		var recordSets []*route53.ResourceRecordSet
+		var err error
+		if opts.allRecords {
+			recordSets, err = listResourceRecordSets(c.route53, zone)
+		} else {
+			recordSets, err = findResourceRecordsByName(ctx, c.route53, opts.cname, zone)
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	fmt.Println(blueResourceRecordSet)
-	replConfirmation("Proceed with deletion of preceding recordset?")
-	deleteRecordSet(cname, zone, blueResourceRecordSet)
+		byNameAndType := map[string]*blueGreen{}
+		for _, recordSet := range recordSets {
+			if !matchesRecordType(recordSet, recordType) {
+				continue
+			}
+			isBlue := recordSetPointsTo(recordSet, sourceDNSName)
+			isGreen := replicaDNSName != "" && recordSetPointsTo(recordSet, replicaDNSName)
+			if !isBlue && !isGreen {
+				continue
+			}
+			key := strings.ToLower(*recordSet.Name) + " " + *recordSet.Type
+			pair, ok := byNameAndType[key]
+			if !ok {
+				pair = &blueGreen{zone: zone}
+				byNameAndType[key] = pair
+				pairs = append(pairs, pair)
+			}
+			if isBlue {
+				pair.blue = recordSet
+			} else {
+				pair.green = recordSet
+			}
+		}
+	}
+	return pairs, nil
+}
 
-	// replConfirmation("Proceed with replication back to blue configuration?")
-	// Replicate ELB the internet-facing scheme to match original Name
-	// replicatedElbInput.SetLoadBalancerName(elbName)
-	// replicateElb(elbReplicaName, elbName)
+func runWeight(ctx context.Context, c *clients, opts *options) error {
+	return changeWeights(ctx, c, opts, func(current int64) int64 {
+		return opts.greenWeight
+	})
+}
 
-	// Perform Blue/Green release back to original ELB with new scheme and security Groups
-	// replConfirmation("Proceed with blue/green?")
-	// weightedBlueGreen(greenResourceRecordSet, blueResourceRecordSet, zone)
+func runForward(ctx context.Context, c *clients, opts *options) error {
+	return changeWeights(ctx, c, opts, func(current int64) int64 {
+		return nextWeight(shiftSchedule(opts.defaults), current)
+	})
+}
 
-	// Delete Replicated ELB
-	// replConfirmation("Proceed with Green ELB deletion?")
-	// deleteElb(*replicatedElbInput.LoadBalancerName)
+func runBack(ctx context.Context, c *clients, opts *options) error {
+	return changeWeights(ctx, c, opts, func(current int64) int64 {
+		return previousWeight(shiftSchedule(opts.defaults), current)
+	})
+}
+
+func runRevert(ctx context.Context, c *clients, opts *options) error {
+	return changeWeights(ctx, c, opts, func(current int64) int64 {
+		return 0
+	})
+}
+
+// changeWeights gives green of a migration in progress the weight that
+// greenWeight returns for its current one, and blue the rest of 100, in one
+// change.
+func changeWeights(ctx context.Context, c *clients, opts *options, greenWeight func(current int64) int64) error {
+	zone, err := findHostedZone(ctx, c.route53, opts.zone)
+	if err != nil {
+		return err
+	}
+	elbName, err := findSourceElbName(ctx, c, opts)
+	if err != nil {
+		return err
+	}
+	elbReplicaName := opts.replicaName(elbName)
+	sourceDescription, err := getElbDescription(ctx, c.elb, elbName)
+	if err != nil {
+		return err
+	}
+	replica, err := getReplica(ctx, c, opts.targetType, elbReplicaName)
+	if err != nil {
+		return err
+	}
+	found, err := findBlueGreens(ctx, c, opts, zone, *sourceDescription.DNSName, replica.dnsName)
+	if err != nil {
+		return err
+	}
+	var pairs []*blueGreen
+	for _, pair := range found {
+		if pair.green == nil {
+			continue
+		}
+		if pair.blue == nil || pair.blue.Weight == nil || pair.green.Weight == nil {
+			return fmt.Errorf("record set %s has no weighted record sets for both %s and %s", *pair.green.Name, elbName, elbReplicaName)
+		}
+		pairs = append(pairs, pair)
+	}
+	if len(pairs) == 0 {
+		return fmt.Errorf("no green record set %s points at %s, run shift first", opts.cname, elbReplicaName)
+	}
+
+	// Green record sets that are out of step, e.g. after a failed change,
+	// are given the weight of the first.
+	current := *pairs[0].green.Weight
+	weight := greenWeight(current)
+	if lowest, highest := greenWeights(pairs); weight == lowest && weight == highest {
+		fmt.Fprintf(stdout(ctx), "Green already has weight %d.\n", current)
+		return nil
+	}
+	if err := replConfirmation(ctx, fmt.Sprintf("Proceed with blue %d/green %d (currently blue %d/green %d)? ", 100-weight, weight, *pairs[0].blue.Weight, current)); err != nil {
+		return err
+	}
+	if err := setWeights(ctx, c.route53, pairs, weight); err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		fmt.Fprintf(stdout(ctx), "%s %s: blue %s now has weight %d, green %s weight %d.\n", *pair.blue.Name, *pair.blue.Type, elbName, *pair.blue.Weight, elbReplicaName, *pair.green.Weight)
+	}
+	return nil
+}
+
+// findSourceElbName returns the ELB named on the command line, or the ELB the
+// CNAME currently points at. When the record sets point at an ELB and its
+// replica, i.e. a migration is in progress, the ELB that is not the replica
+// is the source.
+func findSourceElbName(ctx context.Context, c *clients, opts *options) (string, error) {
+	if opts.sourceElb != "" {
+		return opts.sourceElb, nil
+	}
+	elbNames, err := findElbNamesFromDNSRecordSet(ctx, c.route53, c.elb, opts.zone, opts.cname, opts.recordType)
+	if err != nil {
+		return "", err
+	}
+	if len(elbNames) == 1 {
+		return elbNames[0], nil
+	}
+
+	replicas := map[string]bool{}
+	for _, elbName := range elbNames {
+		replicas[opts.replicaName(elbName)] = true
+	}
+	var sources []string
+	for _, elbName := range elbNames {
+		if !replicas[elbName] {
+			sources = append(sources, elbName)
+		}
+	}
+	if len(sources) != 1 {
+		return "", fmt.Errorf("record set %s points at several ELBs (%s), name the source with --source-elb", opts.cname, strings.Join(elbNames, ", "))
+	}
+	return sources[0], nil
 }
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+const (
+	testZone          = "test.example.com."
+	testCNAME         = "app.test.example.com."
+	testSourceDNSName = "internal-app-1234567890.us-west-2.elb.amazonaws.com"
+)
+
+// testMigration holds fakes seeded with a source ELB, app, that the weighted
+// CNAME app.test.example.com. sends all of its traffic to, and the options
+// that migrate it to app-r.
+type testMigration struct {
+	c          *clients
+	elb        *fakeELB
+	elbv2      *fakeELBV2
+	route53    *fakeRoute53
+	ec2        *fakeEC2
+	acm        *fakeACM
+	cloudwatch *fakeCloudWatch
+	zone       *route53.HostedZone
+	opts       *options
+}
+
+func newTestMigration(t *testing.T) *testMigration {
+	t.Helper()
+	// Every prompt is confirmed and no poll waits.
+	previousStdin, previousSleep, previousTimeNow := stdin, sleep, timeNow
+	stdin = bufio.NewReader(strings.NewReader(strings.Repeat("y\n", 100)))
+	sleep = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+	t.Cleanup(func() { stdin, sleep, timeNow = previousStdin, previousSleep, previousTimeNow })
+
+	tm := &testMigration{
+		elb:        newFakeELB("us-west-2"),
+		elbv2:      newFakeELBV2("us-west-2"),
+		route53:    newFakeRoute53(),
+		ec2:        newFakeEC2(),
+		acm:        newFakeACM(),
+		cloudwatch: newFakeCloudWatch(),
+	}
+	tm.c = &clients{elb: tm.elb, elbv2: tm.elbv2, route53: tm.route53, ec2: tm.ec2, acm: tm.acm, cloudwatch: tm.cloudwatch}
+
+	tm.elb.addLoadBalancer(&elb.LoadBalancerDescription{
+		LoadBalancerName:          aws.String("app"),
+		DNSName:                   aws.String(testSourceDNSName),
+		CanonicalHostedZoneNameID: aws.String("Z1FAKEELBZONE"),
+		Scheme:                    aws.String("internal"),
+		VPCId:                     aws.String("vpc-1"),
+		SecurityGroups:            aws.StringSlice([]string{"sg-1"}),
+		Subnets:                   aws.StringSlice([]string{"subnet-a"}),
+		HealthCheck: &elb.HealthCheck{
+			Target:             aws.String("HTTP:80/health"),
+			Interval:           aws.Int64(30),
+			Timeout:            aws.Int64(5),
+			HealthyThreshold:   aws.Int64(2),
+			UnhealthyThreshold: aws.Int64(2),
+		},
+		Instances: []*elb.Instance{{InstanceId: aws.String("i-1")}, {InstanceId: aws.String("i-2")}},
+		ListenerDescriptions: []*elb.ListenerDescription{{
+			Listener: &elb.Listener{
+				Protocol:         aws.String("HTTP"),
+				LoadBalancerPort: aws.Int64(80),
+				InstanceProtocol: aws.String("HTTP"),
+				InstancePort:     aws.Int64(8080),
+			},
+		}},
+		Policies: &elb.Policies{},
+	}, nil, []*elb.Tag{{Key: aws.String("team"), Value: aws.String("web")}})
+	tm.ec2.addSecurityGroup("sg-1", "vpc-1")
+
+	tm.zone = tm.route53.addHostedZone("Z1", testZone, &route53.ResourceRecordSet{
+		Name:            aws.String(testCNAME),
+		Type:            aws.String(route53.RRTypeCname),
+		TTL:             aws.Int64(60),
+		SetIdentifier:   aws.String("app"),
+		Weight:          aws.Int64(100),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(testSourceDNSName)}},
+	})
+
+	config := &migrationConfig{
+		Version: configVersion,
+		Environments: map[string]*environmentConfig{
+			"test": {
+				// The replica of the internal source stays internal.
+				Defaults: environmentDefaults{Scheme: schemeKeep},
+				Regions:  map[string]map[string][]string{"us-west-2": {"vpc-1": {"sg-1"}}},
+			},
+		},
+	}
+	tm.opts = &options{
+		command:     "migrate",
+		environment: "test",
+		region:      "us-west-2",
+		zone:        testZone,
+		cname:       testCNAME,
+		targetType:  targetTypeClassic,
+		concurrency: 1,
+		config:      config,
+		defaults:    config.defaults("test"),
+	}
+	return tm
+}
+
+// recordSets returns the record sets of the test zone.
+func (tm *testMigration) recordSets() []*route53.ResourceRecordSet {
+	tm.route53.mu.Lock()
+	defer tm.route53.mu.Unlock()
+	return tm.route53.recordSets[*tm.zone.Id]
+}
+
+// weights returns the weight of every record set of the test zone by set
+// identifier.
+func (tm *testMigration) weights() map[string]int64 {
+	weights := map[string]int64{}
+	for _, recordSet := range tm.recordSets() {
+		weights[aws.StringValue(recordSet.SetIdentifier)] = aws.Int64Value(recordSet.Weight)
+	}
+	return weights
+}
+
+func (tm *testMigration) hasLoadBalancer(name string) bool {
+	tm.elb.mu.Lock()
+	defer tm.elb.mu.Unlock()
+	_, ok := tm.elb.loadBalancers[name]
+	return ok
+}
+
+func TestReplicateElb(t *testing.T) {
+	tm := newTestMigration(t)
+
+	if _, err := replicateElb(context.Background(), tm.c, tm.opts, "app", "app-r"); err != nil {
+		t.Fatalf("replicateElb: %v", err)
+	}
+
+	source, replica := tm.elb.loadBalancers["app"], tm.elb.loadBalancers["app-r"]
+	if replica == nil {
+		t.Fatal("replica app-r was not created")
+	}
+	if got := aws.StringValue(replica.Scheme); got != "internal" {
+		t.Errorf("scheme = %q, want internal", got)
+	}
+	if got := aws.StringValueSlice(replica.SecurityGroups); len(got) != 1 || got[0] != "sg-1" {
+		t.Errorf("security groups = %v, want [sg-1]", got)
+	}
+	if got := aws.StringValueSlice(replica.Subnets); len(got) != 1 || got[0] != "subnet-a" {
+		t.Errorf("subnets = %v, want [subnet-a]", got)
+	}
+	if len(replica.ListenerDescriptions) != 1 || *replica.ListenerDescriptions[0].Listener.InstancePort != 8080 {
+		t.Errorf("listeners = %v, want the source's", replica.ListenerDescriptions)
+	}
+	if aws.StringValue(replica.HealthCheck.Target) != aws.StringValue(source.HealthCheck.Target) {
+		t.Errorf("health check = %v, want %v", replica.HealthCheck, source.HealthCheck)
+	}
+	if len(replica.Instances) != 2 {
+		t.Errorf("instances = %v, want i-1 and i-2", replica.Instances)
+	}
+	if tags := tm.elb.tags["app-r"]; len(tags) != 1 || *tags[0].Key != "team" {
+		t.Errorf("tags = %v, want the source's", tags)
+	}
+}
+
+func TestReplicateElbCopiesAttributes(t *testing.T) {
+	tm := newTestMigration(t)
+	attributes := &elb.LoadBalancerAttributes{
+		AccessLog:              &elb.AccessLog{Enabled: aws.Bool(true), S3BucketName: aws.String("logs"), S3BucketPrefix: aws.String("app"), EmitInterval: aws.Int64(60)},
+		ConnectionDraining:     &elb.ConnectionDraining{Enabled: aws.Bool(true), Timeout: aws.Int64(120)},
+		ConnectionSettings:     &elb.ConnectionSettings{IdleTimeout: aws.Int64(300)},
+		CrossZoneLoadBalancing: &elb.CrossZoneLoadBalancing{Enabled: aws.Bool(true)},
+		AdditionalAttributes: []*elb.AdditionalAttribute{
+			{Key: aws.String("elb.http.desyncmitigationmode"), Value: aws.String("strictest")},
+		},
+	}
+	tm.elb.attributes["app"] = attributes
+
+	if _, err := replicateElb(context.Background(), tm.c, tm.opts, "app", "app-r"); err != nil {
+		t.Fatalf("replicateElb: %v", err)
+	}
+
+	if got := tm.elb.attributes["app-r"]; !reflect.DeepEqual(got, attributes) {
+		t.Errorf("attributes = %v, want the source's %v", got, attributes)
+	}
+}
+
+func TestReplicateElbMissingSource(t *testing.T) {
+	tm := newTestMigration(t)
+
+	_, err := replicateElb(context.Background(), tm.c, tm.opts, "missing", "missing-r")
+	if !errors.Is(err, errLoadBalancerNotFound) {
+		t.Fatalf("replicateElb = %v, want errLoadBalancerNotFound", err)
+	}
+	if tm.hasLoadBalancer("missing-r") {
+		t.Error("a replica of a missing ELB was created")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tm := newTestMigration(t)
+
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate: %v", err)
+	}
+
+	if tm.hasLoadBalancer("app") {
+		t.Error("source ELB app was not deleted")
+	}
+	if !tm.hasLoadBalancer("app-r") {
+		t.Error("replica ELB app-r is missing")
+	}
+	recordSets := tm.recordSets()
+	if len(recordSets) != 1 {
+		t.Fatalf("record sets = %v, want only green", recordSets)
+	}
+	green := recordSets[0]
+	if aws.StringValue(green.SetIdentifier) != "app-r" || aws.Int64Value(green.Weight) != 100 {
+		t.Errorf("green = %v, want app-r with weight 100", green)
+	}
+	if !recordSetPointsTo(green, *tm.elb.loadBalancers["app-r"].DNSName) {
+		t.Errorf("green points at %s, want the replica", recordSetValue(green))
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	tm := newTestMigration(t)
+	dryRun := &plan{}
+
+	if err := runMigrate(withPlan(context.Background(), dryRun), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate: %v", err)
+	}
+
+	if len(dryRun.Changes) == 0 {
+		t.Error("the dry run planned no changes")
+	}
+	if !tm.hasLoadBalancer("app") || tm.hasLoadBalancer("app-r") {
+		t.Error("the dry run changed the ELBs")
+	}
+	if weights := tm.weights(); len(weights) != 1 || weights["app"] != 100 {
+		t.Errorf("weights = %v, want app 100 only", weights)
+	}
+}
+
+func TestMigrateDryRunPlansRollback(t *testing.T) {
+	tm := newTestMigration(t)
+	m := newMigration(tm.c, tm.opts)
+	m.replicaElbName = "app"
+	m.journal.record("delete replica ELB app", m.deleteReplica)
+	failing := &migrationStep{name: "fail", run: func(m *migration, ctx context.Context) error {
+		return errors.New("failed")
+	}}
+	dryRun := &plan{}
+
+	if err := m.run(withPlan(context.Background(), dryRun), failing); err == nil {
+		t.Fatal("run succeeded, want the step's error")
+	}
+
+	if !tm.hasLoadBalancer("app") {
+		t.Error("the rollback of a dry run deleted ELB app")
+	}
+	if n := len(dryRun.Changes); n == 0 || dryRun.Changes[n-1].Operation != "DeleteLoadBalancer" {
+		t.Errorf("planned changes = %v, want the deletion of ELB app last", dryRun.Changes)
+	}
+}
+
+func TestChangeWeights(t *testing.T) {
+	tests := []struct {
+		command     func(ctx context.Context, c *clients, opts *options) error
+		name        string
+		greenWeight int64
+		current     int64
+		want        int64
+	}{
+		{runForward, "forward", 0, 0, 20},
+		{runForward, "forward", 0, 30, 40},
+		{runForward, "forward", 0, 80, 100},
+		{runForward, "forward at the end", 0, 100, 100},
+		{runBack, "back", 0, 40, 20},
+		{runBack, "back", 0, 30, 20},
+		{runBack, "back at the start", 0, 20, 0},
+		{runBack, "back at the end", 0, 0, 0},
+		{runWeight, "weight", 35, 20, 35},
+		{runWeight, "weight", 100, 60, 100},
+		{runRevert, "revert", 0, 60, 0},
+	}
+	for _, test := range tests {
+		tm := newTestMigration(t)
+		tm.addGreen(t, test.current)
+		tm.opts.greenWeight = test.greenWeight
+
+		if err := test.command(context.Background(), tm.c, tm.opts); err != nil {
+			t.Fatalf("%s from %d: %v", test.name, test.current, err)
+		}
+		if want := map[string]int64{"app": 100 - test.want, "app-r": test.want}; !reflect.DeepEqual(tm.weights(), want) {
+			t.Errorf("%s from %d: weights = %v, want %v", test.name, test.current, tm.weights(), want)
+		}
+	}
+}
+
+func TestChangeWeightsWithoutGreen(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.addGreen(t, 20)
+	tm.route53.mu.Lock()
+	tm.route53.recordSets[*tm.zone.Id] = tm.route53.recordSets[*tm.zone.Id][:1]
+	tm.route53.mu.Unlock()
+
+	err := runForward(context.Background(), tm.c, tm.opts)
+	if err == nil || !strings.Contains(err.Error(), "run shift first") {
+		t.Errorf("runForward = %v, want a request to shift first", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+// forTarget returns the description of source as it applies where the
+// replica is created. Within the same region and account that is source
+// itself. Otherwise its VPC, subnets, security groups and instances are
+// replaced with their counterparts from the environment's mappings, and every
+// one of them has to be mapped. Certificates are translated by
+// replicaCertificates.
+func forTarget(opts *options, source *elb.LoadBalancerDescription) (*elb.LoadBalancerDescription, error) {
+	if !opts.crossTarget() {
+		return source, nil
+	}
+	mappings := opts.config.mappings(opts.environment)
+	translated := awsutil.CopyOf(source).(*elb.LoadBalancerDescription)
+	var unmapped []string
+	mapID := func(kind string, mapping map[string]string, id *string) *string {
+		if id == nil {
+			return nil
+		}
+		if to, ok := mapping[*id]; ok {
+			return aws.String(to)
+		}
+		unmapped = append(unmapped, kind+" "+*id)
+		return id
+	}
+
+	translated.VPCId = mapID("vpcs", mappings.VPCs, source.VPCId)
+	translated.Subnets = nil
+	if len(opts.defaults.SubnetMap) == 0 && len(opts.defaults.SubnetTags) == 0 {
+		for _, subnet := range source.Subnets {
+			translated.Subnets = append(translated.Subnets, mapID("subnets", mappings.Subnets, subnet))
+		}
+	}
+	// The source's security groups and subnets only matter when the replica
+	// keeps them.
+	translated.SecurityGroups = nil
+	if opts.defaults.SecurityGroups == securityGroupsKeep {
+		for _, sg := range source.SecurityGroups {
+			translated.SecurityGroups = append(translated.SecurityGroups, mapID("securityGroups", mappings.SecurityGroups, sg))
+		}
+	}
+	for i, instance := range source.Instances {
+		translated.Instances[i].InstanceId = mapID("instances", mappings.Instances, instance.InstanceId)
+	}
+
+	if len(unmapped) > 0 {
+		sort.Strings(unmapped)
+		return nil, fmt.Errorf("the replica of %s is created in %s, but environment %s maps no counterpart for: %s",
+			aws.StringValue(source.LoadBalancerName), opts.replicaRegion(), opts.environment, strings.Join(unmapped, ", "))
+	}
+	return translated, nil
+}
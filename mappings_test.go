@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// useTarget creates the replica in us-east-1 with fakes of its own, and maps
+// the VPC, subnet, security group and instances of app to their counterparts
+// there. It returns the fakes of the target.
+func (tm *testMigration) useTarget() *testMigration {
+	target := &testMigration{
+		elb:        newFakeELB("us-east-1"),
+		elbv2:      newFakeELBV2("us-east-1"),
+		ec2:        newFakeEC2(),
+		acm:        newFakeACM(),
+		cloudwatch: newFakeCloudWatch(),
+	}
+	target.c = &clients{elb: target.elb, elbv2: target.elbv2, route53: tm.route53, ec2: target.ec2, acm: target.acm, cloudwatch: target.cloudwatch}
+	target.ec2.addSecurityGroup("sg-9", "vpc-9")
+	tm.c.target = target.c
+
+	tm.opts.targetRegion = "us-east-1"
+	environment := tm.opts.config.Environments["test"]
+	environment.Regions["us-east-1"] = map[string][]string{"vpc-9": {"sg-9"}}
+	environment.Mappings = targetMappings{
+		VPCs:           map[string]string{"vpc-1": "vpc-9"},
+		Subnets:        map[string]string{"subnet-a": "subnet-z"},
+		SecurityGroups: map[string]string{"sg-1": "sg-9"},
+		Instances:      map[string]string{"i-1": "i-8", "i-2": "i-9"},
+	}
+	return target
+}
+
+func TestForTarget(t *testing.T) {
+	tm := newTestMigration(t)
+	source := tm.elb.loadBalancers["app"]
+	if got, err := forTarget(tm.opts, source); err != nil || got != source {
+		t.Errorf("forTarget in the same region = %v, %v, want the source itself", got, err)
+	}
+
+	tm.useTarget()
+	tm.opts.defaults.SecurityGroups = securityGroupsKeep
+	got, err := forTarget(tm.opts, source)
+	if err != nil {
+		t.Fatalf("forTarget: %v", err)
+	}
+	if aws.StringValue(got.VPCId) != "vpc-9" ||
+		!reflect.DeepEqual(aws.StringValueSlice(got.Subnets), []string{"subnet-z"}) ||
+		!reflect.DeepEqual(aws.StringValueSlice(got.SecurityGroups), []string{"sg-9"}) {
+		t.Errorf("VPC, subnets, security groups = %s, %v, %v, want vpc-9, [subnet-z], [sg-9]",
+			aws.StringValue(got.VPCId), aws.StringValueSlice(got.Subnets), aws.StringValueSlice(got.SecurityGroups))
+	}
+	var instances []string
+	for _, instance := range got.Instances {
+		instances = append(instances, aws.StringValue(instance.InstanceId))
+	}
+	if !reflect.DeepEqual(instances, []string{"i-8", "i-9"}) {
+		t.Errorf("instances = %v, want [i-8 i-9]", instances)
+	}
+	if aws.StringValue(source.VPCId) != "vpc-1" || aws.StringValue(source.Instances[0].InstanceId) != "i-1" {
+		t.Error("forTarget changed the source's description")
+	}
+
+	// Security groups from the configuration and subnets chosen by tags do
+	// not need the source's to be mapped.
+	tm.opts.defaults.SecurityGroups = securityGroupsConfig
+	tm.opts.defaults.SubnetTags = map[string]string{"tier": "public"}
+	got, err = forTarget(tm.opts, source)
+	if err != nil || got.SecurityGroups != nil || got.Subnets != nil {
+		t.Errorf("forTarget = %v, %v, want neither security groups nor subnets", got, err)
+	}
+}
+
+func TestForTargetUnmapped(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useTarget()
+	tm.opts.defaults.SecurityGroups = securityGroupsKeep
+	mappings := &tm.opts.config.Environments["test"].Mappings
+	delete(mappings.SecurityGroups, "sg-1")
+	delete(mappings.Instances, "i-2")
+
+	got, err := forTarget(tm.opts, tm.elb.loadBalancers["app"])
+	if err == nil || !strings.Contains(err.Error(), "maps no counterpart for: instances i-2, securityGroups sg-1") {
+		t.Errorf("forTarget = %v, %v, want the unmapped instance and security group", got, err)
+	}
+}
+
+func TestReplicateElbIntoTargetRegion(t *testing.T) {
+	tm := newTestMigration(t)
+	target := tm.useTarget()
+
+	if _, err := replicateElb(context.Background(), tm.c, tm.opts, "app", "app-r"); err != nil {
+		t.Fatalf("replicateElb: %v", err)
+	}
+
+	if tm.hasLoadBalancer("app-r") {
+		t.Error("the replica was created with the source's clients")
+	}
+	replica, ok := target.elb.loadBalancers["app-r"]
+	if !ok {
+		t.Fatal("the replica was not created with the target clients")
+	}
+	if !reflect.DeepEqual(aws.StringValueSlice(replica.Subnets), []string{"subnet-z"}) ||
+		!reflect.DeepEqual(aws.StringValueSlice(replica.SecurityGroups), []string{"sg-9"}) {
+		t.Errorf("subnets, security groups = %v, %v, want [subnet-z], [sg-9]",
+			aws.StringValueSlice(replica.Subnets), aws.StringValueSlice(replica.SecurityGroups))
+	}
+	var instances []string
+	for _, instance := range replica.Instances {
+		instances = append(instances, aws.StringValue(instance.InstanceId))
+	}
+	if !reflect.DeepEqual(instances, []string{"i-8", "i-9"}) {
+		t.Errorf("instances = %v, want the mapped [i-8 i-9]", instances)
+	}
+}
@@ -0,0 +1,733 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// migration moves a record set from a source ELB to a replica of it using
+// weighted blue/green record sets. Steps fill in the fields below as they
+// complete so later steps, and the failure summary, can use them.
+type migration struct {
+	c    *clients
+	opts *options
+
+	sourceElbName  string
+	replicaElbName string
+	replica        *replicaLoadBalancer
+	// replicaTargetGroups are the target groups created for an elbv2
+	// replica, so they can be deleted even if it was never attached to them.
+	replicaTargetGroups []*elbv2.TargetGroup
+	// recordMigration moves the record set named by --cname. Only its zone
+	// is set before discover has found it, and nothing without --zone.
+	recordMigration
+	// otherRecords are the other record sets that point at the source ELB,
+	// found with --all-records. They move in lock-step with the first.
+	otherRecords []*recordMigration
+
+	completed  []*migrationStep
+	journal    rollbackJournal
+	rolledBack bool
+	// statePath is where progress is saved; empty means it is not saved.
+	statePath string
+}
+
+// recordMigration moves a record set, blue, from the source ELB to the
+// replica by way of a green record set of the same name and type.
+type recordMigration struct {
+	zone *route53.HostedZone
+	// recordType is the type of the blue and green record sets.
+	recordType string
+	blue       *route53.ResourceRecordSet
+	green      *route53.ResourceRecordSet
+
+	// blueOriginalWeight is the weight blue is restored to on rollback.
+	blueOriginalWeight int64
+	// blueWasSimple is set when blue was a simple record set that the
+	// migration converted into a weighted one.
+	blueWasSimple bool
+	// blueDeleted is set once the delete-blue-record step deleted blue.
+	blueDeleted bool
+}
+
+// newRecordMigration starts moving blue, which has to be a simple or a
+// weighted record set.
+func newRecordMigration(zone *route53.HostedZone, blue *route53.ResourceRecordSet) (*recordMigration, error) {
+	r := &recordMigration{
+		zone:               zone,
+		recordType:         *blue.Type,
+		blue:               blue,
+		blueWasSimple:      blue.SetIdentifier == nil,
+		blueOriginalWeight: 100,
+	}
+	if !r.blueWasSimple {
+		if blue.Weight == nil {
+			return nil, fmt.Errorf("record set %s uses a routing policy other than weighted", *blue.Name)
+		}
+		r.blueOriginalWeight = *blue.Weight
+	}
+	return r, nil
+}
+
+type migrationStep struct {
+	name string
+	run  func(m *migration, ctx context.Context) error
+	// result describes what exists in AWS once the step has completed.
+	result func(m *migration) string
+}
+
+var (
+	stepDiscover = &migrationStep{
+		name: "discover",
+		run:  (*migration).discover,
+		result: func(m *migration) string {
+			return "source ELB is " + m.sourceElbName
+		},
+	}
+	stepFindReplica = &migrationStep{
+		name: "find-replica",
+		run:  (*migration).findReplica,
+		result: func(m *migration) string {
+			return "replica ELB is " + m.replicaElbName
+		},
+	}
+	stepReplicate = &migrationStep{
+		name: "replicate",
+		run:  (*migration).replicate,
+		result: func(m *migration) string {
+			return fmt.Sprintf("replica ELB %s created (%s)", m.replicaElbName, m.replica.dnsName)
+		},
+	}
+	stepCreateGreenRecord = &migrationStep{
+		name: "create-green-record",
+		run:  (*migration).createGreenRecord,
+		result: func(m *migration) string {
+			return fmt.Sprintf("green record set %s (%s) created%s", *m.green.Name, aws.StringValue(m.green.SetIdentifier), m.otherRecordsNote())
+		},
+	}
+	stepShift = &migrationStep{
+		name: "shift",
+		run:  (*migration).shift,
+		result: func(m *migration) string {
+			return "all traffic shifted to " + m.replicaElbName
+		},
+	}
+	stepDeleteSourceElb = &migrationStep{
+		name: "delete-source-elb",
+		run:  (*migration).deleteSourceElb,
+		result: func(m *migration) string {
+			return "source ELB " + m.sourceElbName + " deleted"
+		},
+	}
+	stepDeleteBlueRecord = &migrationStep{
+		name: "delete-blue-record",
+		run:  (*migration).deleteBlueRecord,
+		result: func(m *migration) string {
+			return fmt.Sprintf("blue record set %s (%s) deleted%s", *m.blue.Name, aws.StringValue(m.blue.SetIdentifier), m.otherRecordsNote())
+		},
+	}
+)
+
+// migrateSteps are the steps of a full migration, in order.
+var migrateSteps = []*migrationStep{stepDiscover, stepReplicate, stepCreateGreenRecord, stepShift, stepDeleteSourceElb, stepDeleteBlueRecord}
+
+func newMigration(c *clients, opts *options) *migration {
+	return &migration{c: c, opts: opts}
+}
+
+// records returns the record sets the migration moves, the one named by
+// --cname first. There are none before discover, or without --zone.
+func (m *migration) records() []*recordMigration {
+	if m.blue == nil {
+		return nil
+	}
+	return append([]*recordMigration{&m.recordMigration}, m.otherRecords...)
+}
+
+// blueGreens returns the record sets that have a green record set.
+func (m *migration) blueGreens() []*blueGreen {
+	var pairs []*blueGreen
+	for _, r := range m.records() {
+		if r.green != nil {
+			pairs = append(pairs, &blueGreen{zone: r.zone, blue: r.blue, green: r.green})
+		}
+	}
+	return pairs
+}
+
+func (m *migration) otherRecordsNote() string {
+	if len(m.otherRecords) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", and %d more", len(m.otherRecords))
+}
+
+// run executes steps in order. When a step fails, or ctx is cancelled, the
+// state left behind by the completed steps is printed, the changes recorded
+// in the journal are undone and a *stepError is returned. Declining a
+// confirmation, or a shift halted or reverted because the replica is
+// unhealthy, stops the migration without rolling back.
+func (m *migration) run(ctx context.Context, steps ...*migrationStep) error {
+	for _, step := range steps {
+		if m.isCompleted(step) {
+			fmt.Fprintln(stdout(ctx), "==> "+step.name+" (already completed)")
+			continue
+		}
+		fmt.Fprintln(stdout(ctx), "==> "+step.name)
+		if err := step.run(m, ctx); err != nil {
+			stepErr := &stepError{Step: step.name, Err: err}
+			m.printSummary(ctx, stepErr)
+			if errors.Is(err, errAborted) || errors.Is(err, errShiftHalted) || errors.Is(err, errShiftReverted) || m.opts.noRollback || m.journal.empty() {
+				return stepErr
+			}
+			// The run context may be the one that was cancelled, so the
+			// rollback gets one that cannot be, with the same plan and output.
+			fmt.Fprintln(stdout(ctx), "")
+			if rollbackErr := m.journal.rollback(context.WithoutCancel(ctx)); rollbackErr != nil {
+				return fmt.Errorf("%w\n%v", stepErr, rollbackErr)
+			}
+			m.rolledBack = true
+			m.save(ctx)
+			fmt.Fprintln(stdout(ctx), "Rollback complete.")
+			return stepErr
+		}
+		m.completed = append(m.completed, step)
+		m.save(ctx)
+	}
+	return nil
+}
+
+func (m *migration) isCompleted(step *migrationStep) bool {
+	for _, completed := range m.completed {
+		if completed == step {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the progress of the migration to its state file. Failing to
+// save is reported but does not stop the migration.
+func (m *migration) save(ctx context.Context) {
+	if m.statePath == "" {
+		return
+	}
+	state := &migrationState{
+		Environment:        m.opts.environment,
+		Region:             m.opts.region,
+		Zone:               m.opts.zone,
+		CNAME:              m.opts.cname,
+		RecordType:         m.recordType,
+		SourceElb:          m.sourceElbName,
+		ReplicaElb:         m.replicaElbName,
+		TargetType:         m.opts.targetType,
+		TargetRegion:       m.opts.targetRegion,
+		TargetRoleArn:      m.opts.targetRoleArn,
+		BlueOriginalWeight: m.blueOriginalWeight,
+		BlueWasSimple:      m.blueWasSimple,
+		BlueDeleted:        m.blueDeleted,
+		AllRecords:         m.opts.allRecords,
+		RolledBack:         m.rolledBack,
+	}
+	if m.zone != nil {
+		state.ZoneID = *m.zone.Id
+	}
+	if m.replica != nil {
+		state.ReplicaDNSName = m.replica.dnsName
+	}
+	if m.blue != nil {
+		state.BlueSetIdentifier = aws.StringValue(m.blue.SetIdentifier)
+		state.BlueWeight = aws.Int64Value(m.blue.Weight)
+	}
+	if m.green != nil {
+		state.GreenSetIdentifier = aws.StringValue(m.green.SetIdentifier)
+		state.GreenWeight = aws.Int64Value(m.green.Weight)
+	}
+	for _, r := range m.otherRecords {
+		state.Records = append(state.Records, r.state())
+	}
+	for _, step := range m.completed {
+		state.CompletedSteps = append(state.CompletedSteps, step.name)
+	}
+	if err := saveState(m.statePath, state); err != nil {
+		fmt.Fprintln(stdout(ctx), "Warning: could not save migration state:", err)
+	}
+}
+
+// restore loads a migration from its saved state. The replica and record
+// sets are looked up again since they may have changed after the state was
+// written, and the rollback journal is rebuilt for the completed steps.
+func (m *migration) restore(ctx context.Context, state *migrationState) error {
+	m.sourceElbName = state.SourceElb
+	m.replicaElbName = state.ReplicaElb
+	if state.ZoneID != "" {
+		m.zone = &route53.HostedZone{Id: aws.String(state.ZoneID), Name: aws.String(state.Zone)}
+	}
+	for _, step := range migrateSteps {
+		if state.completed(step.name) {
+			m.completed = append(m.completed, step)
+		}
+	}
+
+	sourceDeleted := state.completed(stepDeleteSourceElb.name)
+	if state.completed(stepReplicate.name) {
+		replica, err := getReplica(ctx, m.c, m.opts.targetType, m.replicaElbName)
+		if err != nil {
+			return err
+		}
+		m.replica = replica
+		if !sourceDeleted {
+			m.journal.record("delete replica ELB "+m.replicaElbName, m.deleteReplica)
+		}
+	}
+	if m.zone == nil {
+		return nil
+	}
+	// The record set named by --cname may not have been found yet.
+	if state.completed(stepDiscover.name) {
+		if err := m.restoreRecord(ctx, &m.recordMigration, state.cnameRecord(), state); err != nil {
+			return err
+		}
+	}
+	for _, recordState := range state.Records {
+		r := &recordMigration{}
+		if err := m.restoreRecord(ctx, r, recordState, state); err != nil {
+			return err
+		}
+		m.otherRecords = append(m.otherRecords, r)
+	}
+	return nil
+}
+
+// restoreRecord looks up the record sets of a saved record set's migration
+// again.
+func (m *migration) restoreRecord(ctx context.Context, r *recordMigration, recordState recordState, state *migrationState) error {
+	r.zone = &route53.HostedZone{Id: aws.String(recordState.ZoneID), Name: aws.String(recordState.Zone)}
+	r.recordType = recordState.RecordType
+	r.blueOriginalWeight = recordState.BlueOriginalWeight
+	r.blueWasSimple = recordState.BlueWasSimple
+	if recordState.GreenSetIdentifier == "" {
+		// Blue may still be a simple record set without an identifier.
+		blue, err := findResourceRecord(ctx, m.c.route53, recordState.Name, r.recordType, r.zone)
+		if err != nil {
+			return err
+		}
+		r.blue = blue
+		return nil
+	}
+
+	if recordState.BlueSetIdentifier != "" {
+		r.blueDeleted = recordState.BlueDeleted || state.completed(stepDeleteBlueRecord.name)
+		if !r.blueDeleted {
+			blue, err := findResourceRecordBySetIdentifier(ctx, m.c.route53, recordState.Name, r.recordType, r.zone, recordState.BlueSetIdentifier)
+			// Once the source ELB is deleted, a missing blue was deleted by
+			// delete-blue-record before that could be saved.
+			switch {
+			case errors.Is(err, errRecordSetNotFound) && state.completed(stepDeleteSourceElb.name):
+				r.blueDeleted = true
+			case err != nil:
+				return err
+			default:
+				r.blue = blue
+			}
+		}
+		if r.blueDeleted {
+			// Only the name and identifier of a deleted blue are needed.
+			r.blue = &route53.ResourceRecordSet{
+				Name:          aws.String(recordState.Name),
+				Type:          aws.String(r.recordType),
+				SetIdentifier: aws.String(recordState.BlueSetIdentifier),
+				Weight:        aws.Int64(0),
+			}
+		}
+	}
+	green, err := findResourceRecordBySetIdentifier(ctx, m.c.route53, recordState.Name, r.recordType, r.zone, recordState.GreenSetIdentifier)
+	if err != nil {
+		return err
+	}
+	r.green = green
+	if !state.completed(stepDeleteSourceElb.name) {
+		m.recordRestoreBlue(r)
+	}
+	return nil
+}
+
+// state returns what is saved of a record set's migration.
+func (r *recordMigration) state() recordState {
+	state := recordState{
+		Zone:               *r.zone.Name,
+		ZoneID:             *r.zone.Id,
+		Name:               *r.blue.Name,
+		RecordType:         r.recordType,
+		BlueSetIdentifier:  aws.StringValue(r.blue.SetIdentifier),
+		BlueOriginalWeight: r.blueOriginalWeight,
+		BlueWasSimple:      r.blueWasSimple,
+		BlueDeleted:        r.blueDeleted,
+		BlueWeight:         aws.Int64Value(r.blue.Weight),
+	}
+	if r.green != nil {
+		state.GreenSetIdentifier = aws.StringValue(r.green.SetIdentifier)
+		state.GreenWeight = aws.Int64Value(r.green.Weight)
+	}
+	return state
+}
+
+func (m *migration) printSummary(ctx context.Context, failure error) {
+	fmt.Fprintln(stdout(ctx), "")
+	fmt.Fprintln(stdout(ctx), "Migration stopped:", failure)
+	if len(m.completed) == 0 {
+		fmt.Fprintln(stdout(ctx), "No steps completed, nothing was changed.")
+		return
+	}
+	fmt.Fprintln(stdout(ctx), "Completed steps:")
+	for _, step := range m.completed {
+		fmt.Fprintf(stdout(ctx), "  %-20s %s\n", step.name, step.result(m))
+	}
+	for _, pair := range m.blueGreens() {
+		fmt.Fprintf(stdout(ctx), "Current weights of %s %s: blue %s (%s) %d, green %s (%s) %d\n", *pair.blue.Name, *pair.blue.Type,
+			aws.StringValue(pair.blue.SetIdentifier), recordSetValue(pair.blue), aws.Int64Value(pair.blue.Weight),
+			aws.StringValue(pair.green.SetIdentifier), recordSetValue(pair.green), aws.Int64Value(pair.green.Weight))
+	}
+}
+
+func (m *migration) discover(ctx context.Context) error {
+	if m.opts.zone != "" {
+		zone, err := findHostedZone(ctx, m.c.route53, m.opts.zone)
+		if err != nil {
+			return err
+		}
+		m.zone = zone
+	}
+
+	sourceElbName, err := findSourceElbName(ctx, m.c, m.opts)
+	if err != nil {
+		return err
+	}
+	m.sourceElbName = sourceElbName
+	m.replicaElbName = m.opts.replicaName(sourceElbName)
+	fmt.Fprintln(stdout(ctx), "Found ELB "+m.sourceElbName)
+
+	if m.zone != nil {
+		blue, err := findResourceRecord(ctx, m.c.route53, m.opts.cname, m.opts.recordType, m.zone)
+		if err != nil {
+			return err
+		}
+		if err := m.checkAliasCompanion(ctx, blue); err != nil {
+			return err
+		}
+		r, err := newRecordMigration(m.zone, blue)
+		if err != nil {
+			return err
+		}
+		m.recordMigration = *r
+		fmt.Fprintln(stdout(ctx), "Found blue resource record set: ", m.blue)
+	}
+	if m.zone != nil && m.opts.allRecords {
+		return m.findOtherRecords(ctx)
+	}
+	return nil
+}
+
+// findOtherRecords finds the record sets of every hosted zone, other than the
+// one named by --cname, that point at the source ELB.
+func (m *migration) findOtherRecords(ctx context.Context) error {
+	description, err := getElbDescription(ctx, m.c.elb, m.sourceElbName)
+	if err != nil {
+		return err
+	}
+	pairs, err := findBlueGreens(ctx, m.c, m.opts, m.zone, *description.DNSName, "")
+	if err != nil {
+		return err
+	}
+	m.otherRecords = nil
+	for _, pair := range pairs {
+		if *pair.zone.Id == *m.zone.Id && sameDNSName(*pair.blue.Name, *m.blue.Name) && *pair.blue.Type == *m.blue.Type {
+			continue
+		}
+		r, err := newRecordMigration(pair.zone, pair.blue)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout(ctx), "Found blue resource record set %s %s in %s\n", *pair.blue.Name, *pair.blue.Type, *pair.zone.Name)
+		m.otherRecords = append(m.otherRecords, r)
+	}
+	return nil
+}
+
+// checkAliasCompanion refuses to migrate one alias record set of a name that
+// also has an alias record set of the other address family, since that one
+// would still point at the source ELB once it is deleted. Naming the record
+// type explicitly overrides the check, and with --all-records the other one
+// is migrated as well.
+func (m *migration) checkAliasCompanion(ctx context.Context, blue *route53.ResourceRecordSet) error {
+	if blue.AliasTarget == nil || m.opts.recordType != "" || m.opts.allRecords {
+		return nil
+	}
+	recordSets, err := findResourceRecordsByName(ctx, m.c.route53, m.opts.cname, m.zone)
+	if err != nil {
+		return err
+	}
+	for _, recordSet := range recordSets {
+		if *recordSet.Type != *blue.Type && matchesRecordType(recordSet, "") {
+			return fmt.Errorf("record set %s has both %s and %s alias record sets, choose one with --record-type", m.opts.cname, *blue.Type, *recordSet.Type)
+		}
+	}
+	return nil
+}
+
+// newGreenRecordSet returns a green record set of the same kind as blue whose
+// target is the replica. Alias targets keep the dualstack prefix of blue.
+func (m *migration) newGreenRecordSet(blue *route53.ResourceRecordSet, setID string) *route53.ResourceRecordSet {
+	if blue.AliasTarget == nil {
+		return createResourceRecordSet(*blue.Name, m.replica.dnsName, setID)
+	}
+	dnsName := m.replica.dnsName
+	if _, dualstack := trimDualstackPrefix(aws.StringValue(blue.AliasTarget.DNSName)); dualstack {
+		dnsName = dualstackPrefix + dnsName
+	}
+	return createAliasRecordSet(*blue.Name, *blue.Type, dnsName, m.replica.canonicalHostedZoneID, aws.BoolValue(blue.AliasTarget.EvaluateTargetHealth), setID)
+}
+
+func (m *migration) findReplica(ctx context.Context) error {
+	replica, err := getReplica(ctx, m.c, m.opts.targetType, m.replicaElbName)
+	if err != nil {
+		return fmt.Errorf("replica ELB %s is missing, run replicate first: %w", m.replicaElbName, err)
+	}
+	m.replica = replica
+	return nil
+}
+
+func (m *migration) replicate(ctx context.Context) error {
+	if err := replConfirmation(ctx, "Proceed with ELB replication? "); err != nil {
+		return err
+	}
+	created, err := m.createReplica(ctx)
+	if created {
+		// The replica exists even if configuring it failed.
+		m.journal.record("delete replica ELB "+m.replicaElbName, m.deleteReplica)
+	}
+	if err != nil {
+		return err
+	}
+	replica, err := describeReplica(ctx, m.c, m.opts.targetType, m.replicaElbName)
+	if err != nil {
+		return err
+	}
+	m.replica = replica
+	return nil
+}
+
+// createReplica replicates the source ELB as the configured kind of load
+// balancer and reports whether the replica exists afterwards.
+func (m *migration) createReplica(ctx context.Context) (bool, error) {
+	if isTargetTypeV2(m.opts.targetType) {
+		replica, err := replicateV2(ctx, m.c, m.opts, m.sourceElbName, m.replicaElbName)
+		if replica != nil {
+			m.replicaTargetGroups = replica.targetGroups
+		}
+		return replica != nil, err
+	}
+	elbInput, err := replicateElb(ctx, m.c, m.opts, m.sourceElbName, m.replicaElbName)
+	return elbInput != nil, err
+}
+
+// deleteReplica deregisters the instances of the replica and deletes it.
+func (m *migration) deleteReplica(ctx context.Context) error {
+	if isTargetTypeV2(m.opts.targetType) {
+		return m.deleteReplicaV2(ctx)
+	}
+	svc := m.c.forReplica().elb
+	replica, err := getElbDescription(ctx, svc, m.replicaElbName)
+	if errors.Is(err, errLoadBalancerNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(replica.Instances) > 0 {
+		if err := deregisterInstancesFromElb(ctx, svc, m.replicaElbName, replica.Instances); err != nil {
+			return err
+		}
+	}
+	_, err = deleteElb(ctx, svc, m.replicaElbName)
+	return err
+}
+
+// deleteReplicaV2 deletes an elbv2 replica and then its target groups, which
+// outlive the load balancer.
+func (m *migration) deleteReplicaV2(ctx context.Context) error {
+	svc := m.c.forReplica().elbv2
+	targetGroupArns := map[string]bool{}
+	var ordered []string
+	addTargetGroup := func(targetGroup *elbv2.TargetGroup) {
+		if !targetGroupArns[*targetGroup.TargetGroupArn] {
+			targetGroupArns[*targetGroup.TargetGroupArn] = true
+			ordered = append(ordered, *targetGroup.TargetGroupArn)
+		}
+	}
+	for _, targetGroup := range m.replicaTargetGroups {
+		addTargetGroup(targetGroup)
+	}
+
+	loadBalancer, err := describeLoadBalancerV2(ctx, svc, m.replicaElbName)
+	if err != nil && !errors.Is(err, errLoadBalancerNotFound) {
+		return err
+	}
+	if loadBalancer != nil {
+		targetGroups, err := describeTargetGroupsOfLoadBalancer(svc, *loadBalancer.LoadBalancerArn)
+		if err != nil {
+			return err
+		}
+		for _, targetGroup := range targetGroups {
+			addTargetGroup(targetGroup)
+		}
+		if err := deleteLoadBalancerV2(ctx, svc, loadBalancer); err != nil {
+			return err
+		}
+	}
+
+	for _, targetGroupArn := range ordered {
+		if err := deleteTargetGroup(ctx, svc, targetGroupArn); err != nil {
+			return err
+		}
+	}
+	m.replicaTargetGroups = nil
+	return nil
+}
+
+// createGreenRecord creates the green record sets whose target is the
+// replica. A simple blue record set is replaced by a weighted one carrying all
+// traffic in the same change batch, since Route53 does not allow simple and
+// weighted record sets of the same name side by side.
+func (m *migration) createGreenRecord(ctx context.Context) error {
+	for _, r := range m.records() {
+		// Green record sets created before an interrupted run are kept.
+		if r.green != nil {
+			continue
+		}
+		if err := m.createGreenRecordSet(ctx, r); err != nil {
+			return err
+		}
+		m.save(ctx)
+	}
+	return nil
+}
+
+func (m *migration) createGreenRecordSet(ctx context.Context, r *recordMigration) error {
+	blue := r.blue
+	var changes []*route53.Change
+	if r.blueWasSimple {
+		blue = weightedRecordSet(r.blue, m.sourceElbName, r.blueOriginalWeight)
+		fmt.Fprintln(stdout(ctx), "Converting simple record set to weighted record set: ", blue)
+		changes = append(changes,
+			&route53.Change{Action: aws.String("DELETE"), ResourceRecordSet: r.blue},
+			&route53.Change{Action: aws.String("CREATE"), ResourceRecordSet: blue},
+		)
+	}
+	green := m.newGreenRecordSet(blue, *blue.SetIdentifier+"-r")
+	changes = append(changes, &route53.Change{Action: aws.String("CREATE"), ResourceRecordSet: green})
+	output, err := cnameBatchChange(ctx, m.c.route53, changes, *r.zone)
+	if err != nil {
+		return err
+	}
+	r.blue = blue
+	r.green = green
+	m.recordRestoreBlue(r)
+
+	greenCreateChangeOutput, err := waitForChange(ctx, m.c.route53, output.ChangeInfo)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout(ctx), greenCreateChangeOutput)
+	return nil
+}
+
+func (m *migration) recordRestoreBlue(r *recordMigration) {
+	restoreBlue := func(ctx context.Context) error {
+		return m.restoreBlue(ctx, r)
+	}
+	if r.blueWasSimple {
+		m.journal.record("restore simple record set "+*r.blue.Name+" and delete green record set", restoreBlue)
+		return
+	}
+	m.journal.record(fmt.Sprintf("restore blue weight of %s to %d and delete green record set", *r.blue.Name, r.blueOriginalWeight), restoreBlue)
+}
+
+// restoreBlue sends all traffic back to blue and deletes the green record set
+// in one change batch, so no request is ever left without a target. A blue
+// record set that was converted from a simple one is converted back.
+func (m *migration) restoreBlue(ctx context.Context, r *recordMigration) error {
+	blue := withWeight(r.blue, r.blueOriginalWeight)
+	changes := []*route53.Change{
+		{Action: aws.String("UPSERT"), ResourceRecordSet: blue},
+		{Action: aws.String("DELETE"), ResourceRecordSet: r.green},
+	}
+	if r.blueWasSimple {
+		blue = simpleRecordSet(r.blue)
+		changes = []*route53.Change{
+			{Action: aws.String("DELETE"), ResourceRecordSet: r.blue},
+			{Action: aws.String("DELETE"), ResourceRecordSet: r.green},
+			{Action: aws.String("CREATE"), ResourceRecordSet: blue},
+		}
+	}
+	output, err := cnameBatchChange(ctx, m.c.route53, changes, *r.zone)
+	if err != nil {
+		return err
+	}
+	r.blue = blue
+	r.green = nil
+	_, err = waitForChange(ctx, m.c.route53, output.ChangeInfo)
+	return err
+}
+
+// shift moves the traffic of every record set to its green record set.
+func (m *migration) shift(ctx context.Context) error {
+	if err := replConfirmation(ctx, "Proceed with blue/green? "); err != nil {
+		return err
+	}
+	pairs := m.blueGreens()
+	var blues []*route53.ResourceRecordSet
+	for _, pair := range pairs {
+		blues = append(blues, pair.blue)
+	}
+	return weightedBlueGreen(ctx, m.c.route53, pairs, shiftSchedule(m.opts.defaults), bakeTime(ctx, m.opts.defaults, blues...), m.newShiftGate(ctx), func() { m.save(ctx) })
+}
+
+func (m *migration) deleteSourceElb(ctx context.Context) error {
+	if err := replConfirmation(ctx, "Proceed with deletion of ELB "+m.sourceElbName+"?"); err != nil {
+		return err
+	}
+	if _, err := deleteElb(ctx, m.c.elb, m.sourceElbName); err != nil {
+		return err
+	}
+	// Without the source ELB there is nothing to send traffic back to.
+	m.journal.discard()
+	fmt.Fprintln(stdout(ctx), "Source ELB deleted, automatic rollback is no longer possible.")
+	return nil
+}
+
+func (m *migration) deleteBlueRecord(ctx context.Context) error {
+	for _, r := range m.records() {
+		if r.blueDeleted {
+			fmt.Fprintf(stdout(ctx), "Blue record set %s (%s) already deleted\n", *r.blue.Name, aws.StringValue(r.blue.SetIdentifier))
+			continue
+		}
+		fmt.Fprintln(stdout(ctx), r.blue)
+		if err := replConfirmation(ctx, "Proceed with deletion of preceding recordset?"); err != nil {
+			return err
+		}
+		if err := deleteRecordSet(ctx, m.c.route53, *r.blue.Name, r.zone, r.blue); err != nil {
+			return err
+		}
+		// With --all-records a later deletion may fail, so every deleted
+		// blue is saved right away.
+		r.blueDeleted = true
+		m.save(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+// Schemes a replica can be created with. schemeKeep uses the source's.
+const (
+	schemeKeep           = "keep"
+	schemeInternal       = "internal"
+	schemeInternetFacing = "internet-facing"
+)
+
+// Where the security groups of a replica come from before
+// addSecurityGroups and removeSecurityGroups are applied.
+const (
+	securityGroupsConfig = "config"
+	securityGroupsKeep   = "keep"
+)
+
+// replicaScheme returns the scheme the replica of source is created with.
+func replicaScheme(ctx context.Context, opts *options, source *elb.LoadBalancerDescription) string {
+	scheme := opts.defaults.Scheme
+	if scheme == schemeKeep || scheme == "" {
+		return aws.StringValue(source.Scheme)
+	}
+	if scheme != aws.StringValue(source.Scheme) {
+		fmt.Fprintf(stdout(ctx), "Switching the scheme of the replica from %s to %s, the subnets must suit it\n", aws.StringValue(source.Scheme), scheme)
+	}
+	return scheme
+}
+
+// replicaSecurityGroups returns the security groups the replica of source is
+// created with: the ones configured for the source's VPC or the source's own,
+// plus addSecurityGroups and minus removeSecurityGroups. Every group has to
+// exist in the source's VPC. Only an NLB replica may end up without any.
+func replicaSecurityGroups(svc ec2iface.EC2API, opts *options, source *elb.LoadBalancerDescription) ([]string, error) {
+	vpc := aws.StringValue(source.VPCId)
+	var securityGroups []string
+	switch opts.defaults.SecurityGroups {
+	case securityGroupsKeep:
+		securityGroups = aws.StringValueSlice(source.SecurityGroups)
+	default:
+		configured, err := opts.config.securityGroups(opts.environment, opts.replicaRegion(), vpc)
+		if err != nil {
+			return nil, err
+		}
+		securityGroups = configured
+	}
+
+	removed := map[string]bool{}
+	for _, sg := range opts.defaults.RemoveSecurityGroups {
+		removed[sg] = true
+	}
+	seen := map[string]bool{}
+	var result []string
+	for _, sg := range append(securityGroups, opts.defaults.AddSecurityGroups...) {
+		if removed[sg] || seen[sg] {
+			continue
+		}
+		seen[sg] = true
+		result = append(result, sg)
+	}
+
+	if len(result) == 0 {
+		if opts.targetType == targetTypeNLB {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("no security groups left for the replica of %s", aws.StringValue(source.LoadBalancerName))
+	}
+	described, err := describeSecurityGroups(svc, result)
+	if err != nil {
+		return nil, err
+	}
+	for _, sg := range described {
+		if aws.StringValue(sg.VpcId) != vpc {
+			return nil, fmt.Errorf("security group %s belongs to %s, not to %s of %s", aws.StringValue(sg.GroupId), aws.StringValue(sg.VpcId), vpc, aws.StringValue(source.LoadBalancerName))
+		}
+	}
+
+	return result, nil
+}
+
+// replicaSubnets returns the subnets the replica of source is created in. By
+// default these are the source's. subnetMap names the subnet to use in each
+// availability zone; subnetTags selects the subnets of the source's VPC that
+// carry all of the tags, one per availability zone. Either way every
+// availability zone with instances registered with the source has to be
+// covered.
+func replicaSubnets(ctx context.Context, svc ec2iface.EC2API, opts *options, source *elb.LoadBalancerDescription) ([]string, error) {
+	subnetMap := opts.defaults.SubnetMap
+	subnetTags := opts.defaults.SubnetTags
+	if len(subnetMap) == 0 && len(subnetTags) == 0 {
+		return aws.StringValueSlice(source.Subnets), nil
+	}
+	elbName := aws.StringValue(source.LoadBalancerName)
+	vpc := aws.StringValue(source.VPCId)
+
+	input := &ec2.DescribeSubnetsInput{
+		Filters: []*ec2.Filter{{Name: aws.String("vpc-id"), Values: aws.StringSlice([]string{vpc})}},
+	}
+	if len(subnetMap) > 0 {
+		for _, subnet := range subnetMap {
+			input.SubnetIds = append(input.SubnetIds, aws.String(subnet))
+		}
+	} else {
+		for key, value := range subnetTags {
+			input.Filters = append(input.Filters, &ec2.Filter{Name: aws.String("tag:" + key), Values: aws.StringSlice([]string{value})})
+		}
+	}
+	subnets, err := describeSubnets(svc, input)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := map[string]string{}
+	for _, subnet := range subnets {
+		subnetID := aws.StringValue(subnet.SubnetId)
+		zone := aws.StringValue(subnet.AvailabilityZone)
+		if len(subnetMap) > 0 && subnetMap[zone] != subnetID {
+			return nil, fmt.Errorf("subnetMap: %s is in %s, not in the availability zone it is mapped to", subnetID, zone)
+		}
+		if other, ok := zones[zone]; ok {
+			return nil, fmt.Errorf("subnetTags: both %s and %s in %s match, a load balancer takes one subnet per availability zone", other, subnetID, zone)
+		}
+		zones[zone] = subnetID
+	}
+	for zone, subnetID := range subnetMap {
+		if zones[zone] != subnetID {
+			return nil, fmt.Errorf("subnetMap: %s is not a subnet of %s", subnetID, vpc)
+		}
+	}
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("subnetTags: no subnet of %s matches", vpc)
+	}
+
+	var instanceIds []string
+	for _, instance := range source.Instances {
+		instanceIds = append(instanceIds, aws.StringValue(instance.InstanceId))
+	}
+	instanceZones, err := describeInstanceZones(svc, instanceIds)
+	if err != nil {
+		return nil, err
+	}
+	var uncovered []string
+	for _, zone := range instanceZones {
+		if _, ok := zones[zone]; !ok {
+			uncovered = append(uncovered, zone)
+		}
+	}
+	if len(uncovered) > 0 {
+		return nil, fmt.Errorf("the replica of %s would have no subnet in %s, where instances of it run", elbName, strings.Join(uncovered, ", "))
+	}
+
+	sortedZones := make([]string, 0, len(zones))
+	for zone := range zones {
+		sortedZones = append(sortedZones, zone)
+	}
+	sort.Strings(sortedZones)
+	result := make([]string, 0, len(zones))
+	for _, zone := range sortedZones {
+		fmt.Fprintf(stdout(ctx), "Using subnet %s in %s\n", zones[zone], zone)
+		result = append(result, zones[zone])
+	}
+	return result, nil
+}
+
+// checkALBSubnets refuses subnets an ALB cannot be created in, since it needs
+// subnets in at least two availability zones.
+func checkALBSubnets(svc ec2iface.EC2API, elbName string, subnetIds []string) error {
+	subnets, err := describeSubnets(svc, &ec2.DescribeSubnetsInput{SubnetIds: aws.StringSlice(subnetIds)})
+	if err != nil {
+		return err
+	}
+	zones := map[string]bool{}
+	for _, subnet := range subnets {
+		zones[aws.StringValue(subnet.AvailabilityZone)] = true
+	}
+	if len(zones) < 2 {
+		return fmt.Errorf("an ALB needs subnets in at least two availability zones, the replica of %s would only have %s; choose more with subnetMap or subnetTags", elbName, strings.Join(subnetIds, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestReplicaScheme(t *testing.T) {
+	tm := newTestMigration(t)
+	source := tm.elb.loadBalancers["app"]
+	tests := []struct {
+		scheme string
+		want   string
+	}{
+		{"", schemeInternal},
+		{schemeKeep, schemeInternal},
+		{schemeInternal, schemeInternal},
+		{schemeInternetFacing, schemeInternetFacing},
+	}
+	for _, test := range tests {
+		tm.opts.defaults.Scheme = test.scheme
+		if got := replicaScheme(context.Background(), tm.opts, source); got != test.want {
+			t.Errorf("replicaScheme with scheme %q = %q, want %q", test.scheme, got, test.want)
+		}
+	}
+}
+
+func TestReplicaSecurityGroups(t *testing.T) {
+	tm := newTestMigration(t)
+	source := tm.elb.loadBalancers["app"]
+	source.SecurityGroups = aws.StringSlice([]string{"sg-source"})
+	tm.ec2.addSecurityGroup("sg-source", "vpc-1")
+	tm.ec2.addSecurityGroup("sg-extra", "vpc-1")
+	tests := []struct {
+		securityGroups string
+		add            []string
+		remove         []string
+		want           []string
+	}{
+		{securityGroupsConfig, nil, nil, []string{"sg-1"}},
+		{securityGroupsKeep, nil, nil, []string{"sg-source"}},
+		{securityGroupsKeep, []string{"sg-extra", "sg-source"}, nil, []string{"sg-source", "sg-extra"}},
+		{securityGroupsConfig, []string{"sg-extra"}, []string{"sg-1"}, []string{"sg-extra"}},
+	}
+	for _, test := range tests {
+		tm.opts.defaults.SecurityGroups = test.securityGroups
+		tm.opts.defaults.AddSecurityGroups = test.add
+		tm.opts.defaults.RemoveSecurityGroups = test.remove
+		got, err := replicaSecurityGroups(tm.ec2, tm.opts, source)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("replicaSecurityGroups(%s, +%v, -%v) = %v, %v, want %v", test.securityGroups, test.add, test.remove, got, err, test.want)
+		}
+	}
+}
+
+func TestReplicaSecurityGroupsRefusesOtherVPC(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.ec2.addSecurityGroup("sg-other", "vpc-2")
+	tm.opts.defaults.AddSecurityGroups = []string{"sg-other"}
+
+	_, err := replicateElb(context.Background(), tm.c, tm.opts, "app", "app-r")
+	if err == nil || !strings.Contains(err.Error(), "sg-other belongs to vpc-2") {
+		t.Fatalf("replicateElb = %v, want a refusal of sg-other", err)
+	}
+	if tm.hasLoadBalancer("app-r") {
+		t.Error("a replica was created with a security group of another VPC")
+	}
+}
+
+func TestReplicaSecurityGroupsNoneLeft(t *testing.T) {
+	tm := newTestMigration(t)
+	source := tm.elb.loadBalancers["app"]
+	tm.opts.defaults.RemoveSecurityGroups = []string{"sg-1"}
+
+	if _, err := replicaSecurityGroups(tm.ec2, tm.opts, source); err == nil || !strings.Contains(err.Error(), "no security groups left") {
+		t.Errorf("replicaSecurityGroups = %v, want a refusal without security groups", err)
+	}
+	tm.opts.targetType = targetTypeNLB
+	if got, err := replicaSecurityGroups(tm.ec2, tm.opts, source); err != nil || got != nil {
+		t.Errorf("replicaSecurityGroups for an NLB = %v, %v, want none", got, err)
+	}
+}
+
+func TestReplicateElbSwitchesScheme(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.opts.defaults.Scheme = schemeInternetFacing
+
+	if _, err := replicateElb(context.Background(), tm.c, tm.opts, "app", "app-r"); err != nil {
+		t.Fatalf("replicateElb: %v", err)
+	}
+
+	replica := tm.elb.loadBalancers["app-r"]
+	if got := aws.StringValue(replica.Scheme); got != schemeInternetFacing {
+		t.Errorf("scheme = %q, want internet-facing", got)
+	}
+	if strings.HasPrefix(aws.StringValue(replica.DNSName), "internal-") {
+		t.Errorf("DNS name %s is an internal one", aws.StringValue(replica.DNSName))
+	}
+}
+
+// addPublicSubnets seeds the fakes with a private and a public subnet in each
+// of us-west-2a and us-west-2b, and runs i-1 in us-west-2a and i-2 in
+// us-west-2b.
+func (tm *testMigration) addPublicSubnets() {
+	tm.ec2.addSubnet("subnet-a", "vpc-1", "us-west-2a", map[string]string{"tier": "private"})
+	tm.ec2.addSubnet("subnet-b", "vpc-1", "us-west-2b", map[string]string{"tier": "private"})
+	tm.ec2.addSubnet("subnet-public-a", "vpc-1", "us-west-2a", map[string]string{"tier": "public"})
+	tm.ec2.addSubnet("subnet-public-b", "vpc-1", "us-west-2b", map[string]string{"tier": "public"})
+	tm.ec2.addSubnet("subnet-other", "vpc-2", "us-west-2a", map[string]string{"tier": "public"})
+	tm.ec2.addInstance("i-1", "us-west-2a")
+	tm.ec2.addInstance("i-2", "us-west-2b")
+}
+
+func TestReplicaSubnets(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.addPublicSubnets()
+	source := tm.elb.loadBalancers["app"]
+	tests := []struct {
+		subnetMap  map[string]string
+		subnetTags map[string]string
+		want       []string
+	}{
+		{nil, nil, []string{"subnet-a"}},
+		{nil, map[string]string{"tier": "public"}, []string{"subnet-public-a", "subnet-public-b"}},
+		{map[string]string{"us-west-2b": "subnet-public-b", "us-west-2a": "subnet-a"}, nil, []string{"subnet-a", "subnet-public-b"}},
+	}
+	for _, test := range tests {
+		tm.opts.defaults.SubnetMap = test.subnetMap
+		tm.opts.defaults.SubnetTags = test.subnetTags
+		got, err := replicaSubnets(context.Background(), tm.ec2, tm.opts, source)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("replicaSubnets(%v, %v) = %v, %v, want %v", test.subnetMap, test.subnetTags, got, err, test.want)
+		}
+	}
+}
+
+func TestReplicaSubnetsRefused(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.addPublicSubnets()
+	tm.ec2.addSubnet("subnet-public-a2", "vpc-1", "us-west-2a", map[string]string{"tier": "public", "spare": "yes"})
+	source := tm.elb.loadBalancers["app"]
+	tests := []struct {
+		subnetMap  map[string]string
+		subnetTags map[string]string
+		want       string
+	}{
+		{map[string]string{"us-west-2b": "subnet-public-a"}, nil, "subnet-public-a is in us-west-2a"},
+		{map[string]string{"us-west-2a": "subnet-other"}, nil, "subnet-other is not a subnet of vpc-1"},
+		{map[string]string{"us-west-2a": "subnet-public-a"}, nil, "no subnet in us-west-2b"},
+		{nil, map[string]string{"tier": "public"}, "both subnet-public-a and subnet-public-a2"},
+		{nil, map[string]string{"tier": "dmz"}, "no subnet of vpc-1 matches"},
+		{nil, map[string]string{"spare": "yes"}, "no subnet in us-west-2b"},
+	}
+	for _, test := range tests {
+		tm.opts.defaults.SubnetMap = test.subnetMap
+		tm.opts.defaults.SubnetTags = test.subnetTags
+		if _, err := replicaSubnets(context.Background(), tm.ec2, tm.opts, source); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("replicaSubnets(%v, %v) = %v, want an error about %q", test.subnetMap, test.subnetTags, err, test.want)
+		}
+	}
+}
+
+func TestReplicateElbRemapsSubnets(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.addPublicSubnets()
+	tm.opts.defaults.Scheme = schemeInternetFacing
+	tm.opts.defaults.SubnetTags = map[string]string{"tier": "public"}
+
+	if _, err := replicateElb(context.Background(), tm.c, tm.opts, "app", "app-r"); err != nil {
+		t.Fatalf("replicateElb: %v", err)
+	}
+
+	if got := aws.StringValueSlice(tm.elb.loadBalancers["app-r"].Subnets); !reflect.DeepEqual(got, []string{"subnet-public-a", "subnet-public-b"}) {
+		t.Errorf("subnets = %v, want the public ones", got)
+	}
+}
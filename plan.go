@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// plannedValue stands in for values that are only known once a planned
+// change has been applied, such as the DNS name of a new ELB.
+const plannedValue = "(known after apply)"
+
+// plannedChange is a single ELB or Route53 mutation that a dry run skipped.
+type plannedChange struct {
+	Service   string          `json:"service"`
+	Operation string          `json:"operation"`
+	Summary   string          `json:"summary"`
+	Input     json.RawMessage `json:"input"`
+
+	text string
+}
+
+type plan struct {
+	Changes []plannedChange `json:"changes"`
+	// Warnings name what the replica will not reproduce of the source.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type planKey struct{}
+
+// withPlan returns a context in which mutations are collected in p instead of
+// being sent to AWS.
+func withPlan(ctx context.Context, p *plan) context.Context {
+	return context.WithValue(ctx, planKey{}, p)
+}
+
+// planOf returns the plan the mutations of a dry run are collected in, or nil
+// when they are sent to AWS.
+func planOf(ctx context.Context) *plan {
+	p, _ := ctx.Value(planKey{}).(*plan)
+	return p
+}
+
+// planned records a mutation when running dry and reports whether the caller
+// should skip executing it. The input is serialized immediately because
+// callers keep modifying record sets between steps.
+func planned(ctx context.Context, service string, operation string, summary string, input interface{}) bool {
+	dryRun := planOf(ctx)
+	if dryRun == nil {
+		return false
+	}
+	encoded, err := json.Marshal(input)
+	if err != nil {
+		encoded, _ = json.Marshal(err.Error())
+	}
+	fmt.Fprintln(stdout(ctx), "[dry run] skipping", service, operation+":", summary)
+	dryRun.Changes = append(dryRun.Changes, plannedChange{
+		Service:   service,
+		Operation: operation,
+		Summary:   summary,
+		Input:     encoded,
+		text:      awsutil.Prettify(input),
+	})
+	return true
+}
+
+// warn prints a warning about something the replica will not reproduce. A dry
+// run also adds it to the plan, so that a batch can show it before its single
+// confirmation.
+func warn(ctx context.Context, format string, a ...interface{}) {
+	warning := fmt.Sprintf(format, a...)
+	fmt.Fprintln(stdout(ctx), "Warning: "+warning)
+	if dryRun := planOf(ctx); dryRun != nil {
+		dryRun.Warnings = append(dryRun.Warnings, warning)
+	}
+}
+
+func (p *plan) print(w io.Writer) {
+	fmt.Fprintln(w, "")
+	for _, warning := range p.Warnings {
+		fmt.Fprintln(w, "Warning: "+warning)
+	}
+	if len(p.Changes) == 0 {
+		fmt.Fprintln(w, "No changes.")
+		return
+	}
+	fmt.Fprintf(w, "Plan: %d changes\n", len(p.Changes))
+	for i, change := range p.Changes {
+		fmt.Fprintf(w, "\n%d. %s %s: %s\n", i+1, change.Service, change.Operation, change.Summary)
+		for _, line := range strings.Split(strings.TrimSpace(change.text), "\n") {
+			fmt.Fprintln(w, "     "+line)
+		}
+	}
+}
+
+func (p *plan) writeJSON(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+)
+
+// Classic ELB policy types that need special handling when replicated.
+const (
+	policyTypeSSLNegotiation = "SSLNegotiationPolicyType"
+	policyTypePublicKey      = "PublicKeyPolicyType"
+	policyTypeLBCookie       = "LBCookieStickinessPolicyType"
+	policyTypeAppCookie      = "AppCookieStickinessPolicyType"
+
+	referenceSecurityPolicy = "Reference-Security-Policy"
+)
+
+// replicatePolicies recreates every policy of the source ELB on the replica
+// and attaches them to the same listener and backend ports. Public key
+// policies are created first since backend server authentication policies
+// refer to them by name.
+func replicatePolicies(ctx context.Context, svc elbiface.ELBAPI, opts *options, source *elb.LoadBalancerDescription, policies []*elb.PolicyDescription, elbName string) error {
+	var ordered []*elb.PolicyDescription
+	for _, policy := range policies {
+		if aws.StringValue(policy.PolicyTypeName) == policyTypePublicKey {
+			ordered = append(ordered, policy)
+		}
+	}
+	for _, policy := range policies {
+		if aws.StringValue(policy.PolicyTypeName) != policyTypePublicKey {
+			ordered = append(ordered, policy)
+		}
+	}
+
+	for _, policy := range ordered {
+		policyName := *policy.PolicyName
+		var err error
+		switch aws.StringValue(policy.PolicyTypeName) {
+		case policyTypeLBCookie:
+			err = createLbCookieStickinessPolicy(ctx, svc, elbName, policyName, cookieExpiration(policy, opts.defaults.StickinessDuration))
+		case policyTypeAppCookie:
+			err = createAppCookieStickinessPolicy(ctx, svc, elbName, policyName, policyAttribute(policy, "CookieName"))
+		case policyTypeSSLNegotiation:
+			err = createELBPolicy(ctx, svc, elbName, policyName, *policy.PolicyTypeName, sslNegotiationAttributes(ctx, policy, opts.defaults.SSLPolicyName))
+		default:
+			err = createELBPolicy(ctx, svc, elbName, policyName, *policy.PolicyTypeName, policy.PolicyAttributeDescriptions)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, listenerDescription := range source.ListenerDescriptions {
+		if len(listenerDescription.PolicyNames) == 0 {
+			continue
+		}
+		port := *listenerDescription.Listener.LoadBalancerPort
+		if err := setLoadBalancerPolicesOfListener(ctx, svc, elbName, port, aws.StringValueSlice(listenerDescription.PolicyNames)); err != nil {
+			return err
+		}
+	}
+	for _, backend := range source.BackendServerDescriptions {
+		if len(backend.PolicyNames) == 0 {
+			continue
+		}
+		if err := setLoadBalancerPoliciesForBackendServer(ctx, svc, elbName, *backend.InstancePort, aws.StringValueSlice(backend.PolicyNames)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sslNegotiationAttributes returns the attributes to recreate an SSL
+// negotiation policy with. A policy based on a predefined security policy is
+// recreated from its Reference-Security-Policy alone, as AWS expands it again.
+// sslPolicyName, when set, replaces the security policy of every SSL
+// negotiation policy, custom ones included.
+func sslNegotiationAttributes(ctx context.Context, policy *elb.PolicyDescription, sslPolicyName string) []*elb.PolicyAttributeDescription {
+	reference := policyAttribute(policy, referenceSecurityPolicy)
+	if sslPolicyName != "" {
+		if reference != sslPolicyName {
+			fmt.Fprintf(stdout(ctx), "Replacing security policy %q of %s with %s\n", reference, *policy.PolicyName, sslPolicyName)
+		}
+		reference = sslPolicyName
+	}
+	if reference == "" {
+		return policy.PolicyAttributeDescriptions
+	}
+	return []*elb.PolicyAttributeDescription{
+		{
+			AttributeName:  aws.String(referenceSecurityPolicy),
+			AttributeValue: aws.String(reference),
+		},
+	}
+}
+
+// cookieExpiration returns the cookie expiration period to recreate a load
+// balancer cookie stickiness policy with: stickinessDuration when set,
+// otherwise the policy's own, where 0 means the browser session.
+func cookieExpiration(policy *elb.PolicyDescription, stickinessDuration int64) int64 {
+	if stickinessDuration > 0 {
+		return stickinessDuration
+	}
+	expiration, err := strconv.ParseInt(policyAttribute(policy, "CookieExpirationPeriod"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return expiration
+}
+
+// listenerSecurityPolicy returns the predefined security policy that the SSL
+// negotiation policy among policyNames refers to, or "" if there is none.
+func listenerSecurityPolicy(policies []*elb.PolicyDescription, policyNames []*string) string {
+	for _, policyName := range aws.StringValueSlice(policyNames) {
+		for _, policy := range policies {
+			if aws.StringValue(policy.PolicyName) == policyName && aws.StringValue(policy.PolicyTypeName) == policyTypeSSLNegotiation {
+				return policyAttribute(policy, referenceSecurityPolicy)
+			}
+		}
+	}
+	return ""
+}
+
+// policyAttribute returns the value of the named attribute of a policy, or ""
+// if it does not have one.
+func policyAttribute(policy *elb.PolicyDescription, name string) string {
+	for _, attribute := range policy.PolicyAttributeDescriptions {
+		if aws.StringValue(attribute.AttributeName) == name {
+			return aws.StringValue(attribute.AttributeValue)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+)
+
+// policyAttributes builds policy attributes from name and value pairs.
+func policyAttributes(nameValues ...string) []*elb.PolicyAttributeDescription {
+	var attributes []*elb.PolicyAttributeDescription
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		attributes = append(attributes, &elb.PolicyAttributeDescription{
+			AttributeName:  aws.String(nameValues[i]),
+			AttributeValue: aws.String(nameValues[i+1]),
+		})
+	}
+	return attributes
+}
+
+func TestReplicatePolicies(t *testing.T) {
+	tm := newTestMigration(t)
+	source := tm.elb.loadBalancers["app"]
+	source.ListenerDescriptions[0].PolicyNames = aws.StringSlice([]string{"app-cookie"})
+	source.BackendServerDescriptions = []*elb.BackendServerDescription{
+		{InstancePort: aws.Int64(8443), PolicyNames: aws.StringSlice([]string{"auth", "proxy-protocol"})},
+	}
+	// The backend authentication policy comes before the public key policy
+	// it refers to, as AWS may describe them in any order.
+	tm.elb.policies["app"] = []*elb.PolicyDescription{
+		{PolicyName: aws.String("proxy-protocol"), PolicyTypeName: aws.String("ProxyProtocolPolicyType"), PolicyAttributeDescriptions: policyAttributes("ProxyProtocol", "true")},
+		{PolicyName: aws.String("auth"), PolicyTypeName: aws.String("BackendServerAuthenticationPolicyType"), PolicyAttributeDescriptions: policyAttributes("PublicKeyPolicyName", "public-key")},
+		{PolicyName: aws.String("public-key"), PolicyTypeName: aws.String(policyTypePublicKey), PolicyAttributeDescriptions: policyAttributes("PublicKey", "MIIB")},
+		{PolicyName: aws.String("app-cookie"), PolicyTypeName: aws.String(policyTypeAppCookie), PolicyAttributeDescriptions: policyAttributes("CookieName", "SESSION")},
+	}
+
+	if _, err := replicateElb(context.Background(), tm.c, tm.opts, "app", "app-r"); err != nil {
+		t.Fatalf("replicateElb: %v", err)
+	}
+
+	var created []string
+	for _, policy := range tm.elb.policies["app-r"] {
+		created = append(created, *policy.PolicyName)
+	}
+	if want := []string{"public-key", "proxy-protocol", "auth", "app-cookie"}; !reflect.DeepEqual(created, want) {
+		t.Errorf("created policies %v, want %v", created, want)
+	}
+	replica := tm.elb.loadBalancers["app-r"]
+	if names := aws.StringValueSlice(replica.ListenerDescriptions[0].PolicyNames); !reflect.DeepEqual(names, []string{"app-cookie"}) {
+		t.Errorf("policies of listener 80 = %v, want app-cookie", names)
+	}
+	if len(replica.BackendServerDescriptions) != 1 ||
+		!reflect.DeepEqual(aws.StringValueSlice(replica.BackendServerDescriptions[0].PolicyNames), []string{"auth", "proxy-protocol"}) {
+		t.Errorf("backend servers = %v, want auth and proxy-protocol on port 8443", replica.BackendServerDescriptions)
+	}
+}
+
+func TestReplicatePoliciesStickinessDuration(t *testing.T) {
+	tests := []struct {
+		attributes         []*elb.PolicyAttributeDescription
+		stickinessDuration int64
+		// want is the expiration period of the replica's policy, nil for a
+		// browser session cookie.
+		want *int64
+	}{
+		{policyAttributes("CookieExpirationPeriod", "60"), 3600, aws.Int64(3600)},
+		{policyAttributes("CookieExpirationPeriod", "60"), 0, aws.Int64(60)},
+		{policyAttributes("CookieExpirationPeriod", "0"), 0, nil},
+		{policyAttributes("CookieExpirationPeriod", "0"), 3600, aws.Int64(3600)},
+		{nil, 0, nil},
+	}
+	for _, test := range tests {
+		tm := newTestMigration(t)
+		tm.opts.defaults.StickinessDuration = test.stickinessDuration
+		tm.elb.loadBalancers["app"].ListenerDescriptions[0].PolicyNames = aws.StringSlice([]string{"lb-cookie"})
+		tm.elb.policies["app"] = []*elb.PolicyDescription{
+			{PolicyName: aws.String("lb-cookie"), PolicyTypeName: aws.String(policyTypeLBCookie), PolicyAttributeDescriptions: test.attributes},
+		}
+
+		if _, err := replicateElb(context.Background(), tm.c, tm.opts, "app", "app-r"); err != nil {
+			t.Fatalf("replicateElb: %v", err)
+		}
+
+		stickiness := tm.elb.loadBalancers["app-r"].Policies.LBCookieStickinessPolicies
+		if len(stickiness) != 1 || !reflect.DeepEqual(stickiness[0].CookieExpirationPeriod, test.want) {
+			t.Errorf("stickiness policies of %v with duration %d = %v, want lb-cookie expiring after %v", test.attributes, test.stickinessDuration, stickiness, aws.Int64Value(test.want))
+		}
+	}
+}
+
+func TestSSLNegotiationAttributes(t *testing.T) {
+	predefined := &elb.PolicyDescription{
+		PolicyName:     aws.String("ssl"),
+		PolicyTypeName: aws.String(policyTypeSSLNegotiation),
+		PolicyAttributeDescriptions: policyAttributes(
+			"Protocol-TLSv1.2", "true",
+			"ECDHE-RSA-AES128-GCM-SHA256", "true",
+			referenceSecurityPolicy, "ELBSecurityPolicy-2016-08",
+		),
+	}
+	custom := &elb.PolicyDescription{
+		PolicyName:                  aws.String("custom-ssl"),
+		PolicyTypeName:              aws.String(policyTypeSSLNegotiation),
+		PolicyAttributeDescriptions: policyAttributes("Protocol-TLSv1.2", "true"),
+	}
+	tests := []struct {
+		policy        *elb.PolicyDescription
+		sslPolicyName string
+		want          []*elb.PolicyAttributeDescription
+	}{
+		{predefined, "", policyAttributes(referenceSecurityPolicy, "ELBSecurityPolicy-2016-08")},
+		{predefined, "ELBSecurityPolicy-TLS-1-2-2017-01", policyAttributes(referenceSecurityPolicy, "ELBSecurityPolicy-TLS-1-2-2017-01")},
+		{custom, "", custom.PolicyAttributeDescriptions},
+		{custom, "ELBSecurityPolicy-TLS-1-2-2017-01", policyAttributes(referenceSecurityPolicy, "ELBSecurityPolicy-TLS-1-2-2017-01")},
+	}
+	for _, test := range tests {
+		got := sslNegotiationAttributes(context.Background(), test.policy, test.sslPolicyName)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("sslNegotiationAttributes(%s, %q) = %v, want %v", *test.policy.PolicyName, test.sslPolicyName, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// replicaV2 records the resources created for an elbv2 replica, so that a
+// replication that fails half way can be undone.
+type replicaV2 struct {
+	loadBalancer *elbv2.LoadBalancer
+	targetGroups []*elbv2.TargetGroup
+}
+
+// replicateV2 creates an Application or Network Load Balancer, depending on
+// opts.targetType, that serves the same listeners as the source ELB. Every
+// instance port of the source gets a target group with the source's instances
+// and health check, and every listener forwards to the target group of its
+// instance port. Settings the replica cannot reproduce are listed and need
+// confirmation first. The returned replica is not nil once the load balancer
+// has been created, even if a later call fails.
+func replicateV2(ctx context.Context, c *clients, opts *options, sourceElbName string, newElbName string) (*replicaV2, error) {
+	fmt.Fprintf(stdout(ctx), "Replicating ELB %s as an %s\n", sourceElbName, strings.ToUpper(opts.targetType))
+	target := c.forReplica()
+	sourceELBDescription, err := getElbDescription(ctx, c.elb, sourceElbName)
+	if err != nil {
+		return nil, err
+	}
+	sourceELBDescription, err = forTarget(opts, sourceELBDescription)
+	if err != nil {
+		return nil, err
+	}
+	if err := replicaCertificates(ctx, c, opts, sourceELBDescription); err != nil {
+		return nil, err
+	}
+	if opts.targetType == targetTypeALB {
+		for _, listenerDescription := range sourceELBDescription.ListenerDescriptions {
+			listener := listenerDescription.Listener
+			if !isHTTPProtocol(*listener.Protocol) || !isHTTPProtocol(*listener.InstanceProtocol) {
+				return nil, fmt.Errorf("listener %d of %s uses %s to %s, which an ALB cannot serve, use --target-type %s", *listener.LoadBalancerPort, sourceElbName, *listener.Protocol, *listener.InstanceProtocol, targetTypeNLB)
+			}
+		}
+	}
+	policies, err := describeELBPolicies(c.elb, sourceElbName)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := describeELBAttributes(c.elb, sourceElbName)
+	if err != nil {
+		return nil, err
+	}
+	lbAttributes, tgAttributes := attributesV2(ctx, opts.targetType, attributes)
+	if opts.targetType == targetTypeNLB {
+		if unsupported := unsupportedByNLB(sourceELBDescription, policies); len(unsupported) > 0 {
+			warn(ctx, "an NLB cannot reproduce these settings of %s: %s", sourceElbName, strings.Join(unsupported, "; "))
+			if err := replConfirmation(ctx, "Replicate without them?"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opts.targetType == targetTypeALB {
+		if conflicts := sharedStickinessConflicts(sourceELBDescription, opts.defaults.StickinessDuration); len(conflicts) > 0 {
+			warn(ctx, "listeners of %s that share a target group stick differently, the first listener's stickiness applies: %s", sourceElbName, strings.Join(conflicts, "; "))
+			if err := replConfirmation(ctx, "Replicate with it?"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	securityGroups, err := replicaSecurityGroups(target.ec2, opts, sourceELBDescription)
+	if err != nil {
+		return nil, err
+	}
+	subnets, err := replicaSubnets(ctx, target.ec2, opts, sourceELBDescription)
+	if err != nil {
+		return nil, err
+	}
+	if opts.targetType == targetTypeALB {
+		if err := checkALBSubnets(target.ec2, sourceElbName, subnets); err != nil {
+			return nil, err
+		}
+	}
+	tags, err := describeELBTags(c.elb, sourceElbName)
+	if err != nil {
+		return nil, err
+	}
+
+	lbInput := &elbv2.CreateLoadBalancerInput{}
+	lbInput.SetName(newElbName)
+	lbInput.SetType(elbv2.LoadBalancerTypeEnumApplication)
+	if opts.targetType == targetTypeNLB {
+		lbInput.SetType(elbv2.LoadBalancerTypeEnumNetwork)
+	}
+	lbInput.SetScheme(replicaScheme(ctx, opts, sourceELBDescription))
+	if len(securityGroups) > 0 {
+		lbInput.SetSecurityGroups(aws.StringSlice(securityGroups))
+	}
+	lbInput.SetSubnets(aws.StringSlice(subnets))
+	if len(tags.Tags) > 0 {
+		lbInput.SetTags(tagsV2(tags.Tags))
+	}
+	loadBalancer, err := createLoadBalancerV2(ctx, target.elbv2, lbInput)
+	if err != nil {
+		return nil, err
+	}
+	replica := &replicaV2{loadBalancer: loadBalancer}
+	if len(lbAttributes) > 0 {
+		if err := modifyLoadBalancerAttributesV2(ctx, target.elbv2, loadBalancer, lbAttributes); err != nil {
+			return replica, err
+		}
+	}
+
+	// One target group per instance protocol and port, shared by the
+	// listeners that forward to it.
+	targetGroups := map[string]*elbv2.TargetGroup{}
+	for _, listenerDescription := range sourceELBDescription.ListenerDescriptions {
+		listener := listenerDescription.Listener
+		key := targetGroupKey(listener)
+		targetGroup, ok := targetGroups[key]
+		if !ok {
+			tgInput := &elbv2.CreateTargetGroupInput{}
+			tgInput.SetName(targetGroupName(newElbName, *listener.InstanceProtocol, *listener.InstancePort))
+			tgInput.SetProtocol(protocolV2(opts.targetType, *listener.InstanceProtocol))
+			tgInput.SetPort(*listener.InstancePort)
+			tgInput.SetVpcId(*sourceELBDescription.VPCId)
+			tgInput.SetTargetType(elbv2.TargetTypeEnumInstance)
+			if err := setTargetGroupHealthCheck(ctx, tgInput, sourceELBDescription.HealthCheck); err != nil {
+				return replica, err
+			}
+			if len(tags.Tags) > 0 {
+				tgInput.SetTags(tagsV2(tags.Tags))
+			}
+			targetGroup, err = createTargetGroup(ctx, target.elbv2, tgInput)
+			if err != nil {
+				return replica, err
+			}
+			replica.targetGroups = append(replica.targetGroups, targetGroup)
+			targetGroups[key] = targetGroup
+
+			targetGroupAttributes := tgAttributes
+			if opts.targetType == targetTypeALB {
+				targetGroupAttributes = append(targetGroupAttributes, stickinessAttributes(ctx, sourceELBDescription, listenerDescription.PolicyNames, opts.defaults.StickinessDuration)...)
+			}
+			if len(targetGroupAttributes) > 0 {
+				if err := modifyTargetGroupAttributes(ctx, target.elbv2, targetGroup, targetGroupAttributes); err != nil {
+					return replica, err
+				}
+			}
+		}
+
+		listenerInput := &elbv2.CreateListenerInput{}
+		listenerInput.SetLoadBalancerArn(*loadBalancer.LoadBalancerArn)
+		listenerInput.SetProtocol(protocolV2(opts.targetType, *listener.Protocol))
+		listenerInput.SetPort(*listener.LoadBalancerPort)
+		listenerInput.SetDefaultActions([]*elbv2.Action{
+			{
+				Type:           aws.String(elbv2.ActionTypeEnumForward),
+				TargetGroupArn: targetGroup.TargetGroupArn,
+			},
+		})
+		if listener.SSLCertificateId != nil {
+			listenerInput.SetCertificates([]*elbv2.Certificate{{CertificateArn: listener.SSLCertificateId}})
+			// Without a policy of its own the listener gets the AWS default.
+			sslPolicyName := opts.defaults.SSLPolicyName
+			if sslPolicyName == "" {
+				sslPolicyName = listenerSecurityPolicy(policies, listenerDescription.PolicyNames)
+			}
+			if sslPolicyName != "" {
+				listenerInput.SetSslPolicy(sslPolicyName)
+			}
+		}
+		if err := createListenerV2(ctx, target.elbv2, listenerInput); err != nil {
+			return replica, err
+		}
+	}
+
+	for _, targetGroup := range replica.targetGroups {
+		var targets []*elbv2.TargetDescription
+		for _, instance := range sourceELBDescription.Instances {
+			targets = append(targets, &elbv2.TargetDescription{Id: instance.InstanceId, Port: targetGroup.Port})
+		}
+		if len(targets) == 0 {
+			continue
+		}
+		if err := registerTargets(ctx, target.elbv2, targetGroup, targets); err != nil {
+			return replica, err
+		}
+	}
+
+	return replica, waitForTargetsHealthy(ctx, target.elbv2, replica.targetGroups)
+}
+
+// attributesV2 translates the attributes of a classic ELB into load balancer
+// and target group attributes of an elbv2 replica. Connection draining becomes
+// the deregistration delay of the target groups. Attributes that the replica
+// cannot reproduce are printed as warnings.
+func attributesV2(ctx context.Context, targetType string, attributes *elb.LoadBalancerAttributes) ([]*elbv2.LoadBalancerAttribute, []*elbv2.TargetGroupAttribute) {
+	var lbAttributes []*elbv2.LoadBalancerAttribute
+	var tgAttributes []*elbv2.TargetGroupAttribute
+	lbAttribute := func(key string, value string) {
+		lbAttributes = append(lbAttributes, &elbv2.LoadBalancerAttribute{Key: aws.String(key), Value: aws.String(value)})
+	}
+	if attributes == nil {
+		return nil, nil
+	}
+
+	if draining := attributes.ConnectionDraining; draining != nil {
+		delay := int64(0)
+		if aws.BoolValue(draining.Enabled) {
+			delay = aws.Int64Value(draining.Timeout)
+		}
+		tgAttributes = append(tgAttributes, &elbv2.TargetGroupAttribute{
+			Key:   aws.String("deregistration_delay.timeout_seconds"),
+			Value: aws.String(strconv.FormatInt(delay, 10)),
+		})
+	}
+	if settings := attributes.ConnectionSettings; settings != nil {
+		if targetType == targetTypeALB {
+			lbAttribute("idle_timeout.timeout_seconds", strconv.FormatInt(aws.Int64Value(settings.IdleTimeout), 10))
+		} else {
+			warn(ctx, "idle timeout %ds is not copied, NLBs have a fixed idle timeout", aws.Int64Value(settings.IdleTimeout))
+		}
+	}
+	if crossZone := attributes.CrossZoneLoadBalancing; crossZone != nil {
+		if targetType == targetTypeNLB {
+			lbAttribute("load_balancing.cross_zone.enabled", strconv.FormatBool(aws.BoolValue(crossZone.Enabled)))
+		} else if !aws.BoolValue(crossZone.Enabled) {
+			warn(ctx, "cross-zone load balancing cannot be disabled on an ALB")
+		}
+	}
+	if accessLog := attributes.AccessLog; accessLog != nil && aws.BoolValue(accessLog.Enabled) {
+		lbAttribute("access_logs.s3.enabled", "true")
+		lbAttribute("access_logs.s3.bucket", aws.StringValue(accessLog.S3BucketName))
+		lbAttribute("access_logs.s3.prefix", aws.StringValue(accessLog.S3BucketPrefix))
+		if aws.Int64Value(accessLog.EmitInterval) != 5 {
+			warn(ctx, "access logs are written every 5 minutes instead of every %d", aws.Int64Value(accessLog.EmitInterval))
+		}
+	}
+	for _, additional := range attributes.AdditionalAttributes {
+		key := aws.StringValue(additional.Key)
+		if key == "elb.http.desyncmitigationmode" && targetType == targetTypeALB {
+			lbAttribute("routing.http.desync_mitigation_mode", aws.StringValue(additional.Value))
+			continue
+		}
+		warn(ctx, "attribute %s=%s is not copied", key, aws.StringValue(additional.Value))
+	}
+
+	return lbAttributes, tgAttributes
+}
+
+// protocolV2 returns the listener or target group protocol that replaces a
+// classic protocol. NLBs have no HTTP protocols, so HTTP is forwarded as TCP
+// and HTTPS as TLS; classic SSL is TLS on both.
+func protocolV2(targetType string, protocol string) string {
+	protocol = strings.ToUpper(protocol)
+	if targetType != targetTypeNLB {
+		return protocol
+	}
+	switch protocol {
+	case elbv2.ProtocolEnumHttps, "SSL":
+		return elbv2.ProtocolEnumTls
+	default:
+		return elbv2.ProtocolEnumTcp
+	}
+}
+
+// unsupportedByNLB lists the settings of a classic ELB that an NLB replica
+// does not reproduce.
+func unsupportedByNLB(description *elb.LoadBalancerDescription, policies []*elb.PolicyDescription) []string {
+	policyTypes := map[string]string{}
+	for _, policy := range policies {
+		policyTypes[aws.StringValue(policy.PolicyName)] = aws.StringValue(policy.PolicyTypeName)
+	}
+
+	var unsupported []string
+	for _, listenerDescription := range description.ListenerDescriptions {
+		listener := listenerDescription.Listener
+		if isHTTPProtocol(*listener.Protocol) {
+			unsupported = append(unsupported, fmt.Sprintf("listener %d: %s is forwarded as %s, requests are not inspected and X-Forwarded-For is not added", *listener.LoadBalancerPort, *listener.Protocol, protocolV2(targetTypeNLB, *listener.Protocol)))
+		}
+		for _, policyName := range aws.StringValueSlice(listenerDescription.PolicyNames) {
+			switch policyTypes[policyName] {
+			case "LBCookieStickinessPolicyType", "AppCookieStickinessPolicyType":
+				unsupported = append(unsupported, fmt.Sprintf("listener %d: cookie stickiness policy %s, NLBs only stick by source IP", *listener.LoadBalancerPort, policyName))
+			}
+		}
+	}
+	for _, backend := range description.BackendServerDescriptions {
+		for _, policyName := range aws.StringValueSlice(backend.PolicyNames) {
+			switch policyTypes[policyName] {
+			case "ProxyProtocolPolicyType":
+				unsupported = append(unsupported, fmt.Sprintf("instance port %d: proxy protocol policy %s, NLBs only send proxy protocol v2", *backend.InstancePort, policyName))
+			case "BackendServerAuthenticationPolicyType":
+				unsupported = append(unsupported, fmt.Sprintf("instance port %d: backend server authentication policy %s", *backend.InstancePort, policyName))
+			}
+		}
+	}
+	return unsupported
+}
+
+func isHTTPProtocol(protocol string) bool {
+	protocol = strings.ToUpper(protocol)
+	return protocol == elbv2.ProtocolEnumHttp || protocol == elbv2.ProtocolEnumHttps
+}
+
+// targetGroupName names the target group of an instance port after the load
+// balancer, e.g. some-app-r-http80. Target group names are limited to 32
+// characters, so the load balancer name is shortened when needed.
+func targetGroupName(elbName string, protocol string, port int64) string {
+	suffix := "-" + strings.ToLower(protocol) + strconv.FormatInt(port, 10)
+	maxLength := 32 - len(suffix)
+	if len(elbName) > maxLength {
+		elbName = strings.TrimRight(elbName[:maxLength], "-")
+	}
+	return elbName + suffix
+}
+
+// setTargetGroupHealthCheck translates a classic health check target such as
+// HTTP:80/health or TCP:443 into the health check of a target group. The
+// thresholds are clamped to the ranges target groups accept.
+func setTargetGroupHealthCheck(ctx context.Context, input *elbv2.CreateTargetGroupInput, healthCheck *elb.HealthCheck) error {
+	if healthCheck == nil {
+		return nil
+	}
+	target := aws.StringValue(healthCheck.Target)
+	parts := strings.SplitN(target, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("cannot parse health check target %q", target)
+	}
+	protocol := strings.ToUpper(parts[0])
+	port, path := parts[1], ""
+	if i := strings.Index(port, "/"); i >= 0 {
+		port, path = port[:i], port[i:]
+	}
+	if _, err := strconv.ParseInt(port, 10, 64); err != nil {
+		return fmt.Errorf("cannot parse health check target %q: %v", target, err)
+	}
+
+	switch {
+	case isHTTPProtocol(protocol):
+		input.SetHealthCheckProtocol(protocol)
+		input.SetHealthCheckPath(path)
+	case isHTTPProtocol(*input.Protocol):
+		// HTTP target groups only check over HTTP(S), so a TCP or SSL check
+		// becomes a request for / on the same port.
+		warn(ctx, "health check %s of target group %s is checked with HTTP GET / instead", target, *input.Name)
+		input.SetHealthCheckProtocol(elbv2.ProtocolEnumHttp)
+		input.SetHealthCheckPath("/")
+	default:
+		input.SetHealthCheckProtocol(elbv2.ProtocolEnumTcp)
+	}
+	input.SetHealthCheckPort(port)
+
+	interval := clamp(aws.Int64Value(healthCheck.Interval), 5, 300)
+	input.SetHealthCheckIntervalSeconds(interval)
+	input.SetHealthCheckTimeoutSeconds(clamp(aws.Int64Value(healthCheck.Timeout), 2, clamp(interval-1, 2, 120)))
+	input.SetHealthyThresholdCount(clamp(aws.Int64Value(healthCheck.HealthyThreshold), 2, 10))
+	input.SetUnhealthyThresholdCount(clamp(aws.Int64Value(healthCheck.UnhealthyThreshold), 2, 10))
+	return nil
+}
+
+// stickinessAttributes returns the target group attributes that reproduce
+// the cookie stickiness policy among policyNames, or nil if the listener is
+// not sticky. stickinessDuration, when set, replaces the source's cookie
+// expiration. A load balancer cookie without an expiration period lasts for
+// the browser session on a classic ELB; target groups cannot do that, so they
+// keep their default duration.
+func stickinessAttributes(ctx context.Context, description *elb.LoadBalancerDescription, policyNames []*string, stickinessDuration int64) []*elbv2.TargetGroupAttribute {
+	if description.Policies == nil {
+		return nil
+	}
+	for _, policyName := range aws.StringValueSlice(policyNames) {
+		for _, policy := range description.Policies.LBCookieStickinessPolicies {
+			if aws.StringValue(policy.PolicyName) != policyName {
+				continue
+			}
+			duration := aws.Int64Value(policy.CookieExpirationPeriod)
+			if stickinessDuration > 0 {
+				duration = stickinessDuration
+			}
+			attributes := []*elbv2.TargetGroupAttribute{
+				{Key: aws.String("stickiness.enabled"), Value: aws.String("true")},
+				{Key: aws.String("stickiness.type"), Value: aws.String("lb_cookie")},
+			}
+			if duration == 0 {
+				warn(ctx, "%s uses a browser session cookie, the ALB keeps its default cookie duration", policyName)
+				return attributes
+			}
+			return append(attributes, &elbv2.TargetGroupAttribute{
+				Key:   aws.String("stickiness.lb_cookie.duration_seconds"),
+				Value: aws.String(strconv.FormatInt(duration, 10)),
+			})
+		}
+		for _, policy := range description.Policies.AppCookieStickinessPolicies {
+			if aws.StringValue(policy.PolicyName) != policyName {
+				continue
+			}
+			return []*elbv2.TargetGroupAttribute{
+				{Key: aws.String("stickiness.enabled"), Value: aws.String("true")},
+				{Key: aws.String("stickiness.type"), Value: aws.String("app_cookie")},
+				{Key: aws.String("stickiness.app_cookie.cookie_name"), Value: policy.CookieName},
+			}
+		}
+	}
+	return nil
+}
+
+// stickiness describes the cookie stickiness that stickinessAttributes gives
+// a listener with policyNames, for comparing listeners.
+func stickiness(description *elb.LoadBalancerDescription, policyNames []*string, stickinessDuration int64) string {
+	if description.Policies == nil {
+		return "none"
+	}
+	for _, policyName := range aws.StringValueSlice(policyNames) {
+		for _, policy := range description.Policies.LBCookieStickinessPolicies {
+			if aws.StringValue(policy.PolicyName) != policyName {
+				continue
+			}
+			duration := aws.Int64Value(policy.CookieExpirationPeriod)
+			if stickinessDuration > 0 {
+				duration = stickinessDuration
+			}
+			return fmt.Sprintf("lb_cookie %ds", duration)
+		}
+		for _, policy := range description.Policies.AppCookieStickinessPolicies {
+			if aws.StringValue(policy.PolicyName) == policyName {
+				return "app_cookie " + aws.StringValue(policy.CookieName)
+			}
+		}
+	}
+	return "none"
+}
+
+// sharedStickinessConflicts lists the listeners whose stickiness differs from
+// that of the first listener forwarding to the same instance port. They share
+// its target group, and stickiness is a target group attribute.
+func sharedStickinessConflicts(description *elb.LoadBalancerDescription, stickinessDuration int64) []string {
+	first := map[string]*elb.ListenerDescription{}
+	var conflicts []string
+	for _, listenerDescription := range description.ListenerDescriptions {
+		key := targetGroupKey(listenerDescription.Listener)
+		earlier, ok := first[key]
+		if !ok {
+			first[key] = listenerDescription
+			continue
+		}
+		want := stickiness(description, earlier.PolicyNames, stickinessDuration)
+		if got := stickiness(description, listenerDescription.PolicyNames, stickinessDuration); got != want {
+			conflicts = append(conflicts, fmt.Sprintf("listener %d (%s) gets %s of listener %d", *listenerDescription.Listener.LoadBalancerPort, got, want, *earlier.Listener.LoadBalancerPort))
+		}
+	}
+	return conflicts
+}
+
+// targetGroupKey identifies the target group a listener forwards to, one per
+// instance protocol and port.
+func targetGroupKey(listener *elb.Listener) string {
+	return fmt.Sprintf("%s:%d", *listener.InstanceProtocol, *listener.InstancePort)
+}
+
+func tagsV2(tags []*elb.Tag) []*elbv2.Tag {
+	var converted []*elbv2.Tag
+	for _, tag := range tags {
+		converted = append(converted, &elbv2.Tag{Key: tag.Key, Value: tag.Value})
+	}
+	return converted
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// listenersV2 returns the listeners of the elbv2 load balancer by port.
+func (tm *testMigration) listenersV2(name string) map[int64]*elbv2.Listener {
+	tm.elbv2.mu.Lock()
+	defer tm.elbv2.mu.Unlock()
+	listeners := map[int64]*elbv2.Listener{}
+	if loadBalancer, ok := tm.elbv2.loadBalancers[name]; ok {
+		for _, listener := range tm.elbv2.listeners[*loadBalancer.LoadBalancerArn] {
+			listeners[*listener.Port] = listener
+		}
+	}
+	return listeners
+}
+
+// useALB makes the replica an ALB and gives the source ELB a second subnet,
+// subnet-b, in another availability zone, as ALBs need two.
+func (tm *testMigration) useALB() {
+	tm.opts.targetType = targetTypeALB
+	tm.ec2.addSubnet("subnet-a", "vpc-1", "us-west-2a", nil)
+	tm.ec2.addSubnet("subnet-b", "vpc-1", "us-west-2b", nil)
+	source := tm.elb.loadBalancers["app"]
+	source.Subnets = append(source.Subnets, aws.String("subnet-b"))
+}
+
+func TestMigrateToALB(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useALB()
+
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate: %v", err)
+	}
+
+	alb, ok := tm.elbv2.loadBalancers["app-r"]
+	if !ok {
+		t.Fatal("ALB app-r was not created")
+	}
+	if *alb.Type != elbv2.LoadBalancerTypeEnumApplication || *alb.Scheme != "internal" {
+		t.Errorf("load balancer is a %s %s one, want an internal application one", *alb.Scheme, *alb.Type)
+	}
+	listener := tm.listenersV2("app-r")[80]
+	if listener == nil || *listener.Protocol != elbv2.ProtocolEnumHttp {
+		t.Fatalf("listener on port 80 = %v, want an HTTP one", listener)
+	}
+	targetGroupArn := *listener.DefaultActions[0].TargetGroupArn
+	targetGroup := tm.elbv2.targetGroups[targetGroupArn]
+	if *targetGroup.TargetGroupName != "app-r-http8080" || *targetGroup.Port != 8080 || *targetGroup.HealthCheckPath != "/health" {
+		t.Errorf("target group = %v, want app-r-http8080 checking /health", targetGroup)
+	}
+	if targets := tm.elbv2.targets[targetGroupArn]; len(targets) != 2 {
+		t.Errorf("targets = %v, want i-1 and i-2", targets)
+	}
+
+	if tm.hasLoadBalancer("app") {
+		t.Error("source ELB app was not deleted")
+	}
+	recordSets := tm.recordSets()
+	if len(recordSets) != 1 || !recordSetPointsTo(recordSets[0], *alb.DNSName) {
+		t.Errorf("record sets = %v, want only green pointing at the ALB", recordSets)
+	}
+}
+
+func TestMigrateToALBRollsBack(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useALB()
+	tm.route53.failChange = 3
+
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err == nil {
+		t.Fatal("runMigrate succeeded despite the failed change")
+	}
+
+	if len(tm.elbv2.loadBalancers) != 0 || len(tm.elbv2.targetGroups) != 0 {
+		t.Errorf("left behind load balancers %v and target groups %v", tm.elbv2.loadBalancers, tm.elbv2.targetGroups)
+	}
+	if weights := tm.weights(); len(weights) != 1 || weights["app"] != 100 {
+		t.Errorf("weights = %v, want app 100 only", weights)
+	}
+}
+
+func TestAttributesV2(t *testing.T) {
+	attributes := &elb.LoadBalancerAttributes{
+		AccessLog:              &elb.AccessLog{Enabled: aws.Bool(true), S3BucketName: aws.String("logs"), S3BucketPrefix: aws.String("app"), EmitInterval: aws.Int64(5)},
+		ConnectionDraining:     &elb.ConnectionDraining{Enabled: aws.Bool(true), Timeout: aws.Int64(120)},
+		ConnectionSettings:     &elb.ConnectionSettings{IdleTimeout: aws.Int64(300)},
+		CrossZoneLoadBalancing: &elb.CrossZoneLoadBalancing{Enabled: aws.Bool(true)},
+		AdditionalAttributes: []*elb.AdditionalAttribute{
+			{Key: aws.String("elb.http.desyncmitigationmode"), Value: aws.String("strictest")},
+		},
+	}
+	tests := []struct {
+		targetType string
+		want       map[string]string
+	}{
+		{targetTypeALB, map[string]string{
+			"idle_timeout.timeout_seconds":        "300",
+			"access_logs.s3.enabled":              "true",
+			"access_logs.s3.bucket":               "logs",
+			"access_logs.s3.prefix":               "app",
+			"routing.http.desync_mitigation_mode": "strictest",
+		}},
+		{targetTypeNLB, map[string]string{
+			"load_balancing.cross_zone.enabled": "true",
+			"access_logs.s3.enabled":            "true",
+			"access_logs.s3.bucket":             "logs",
+			"access_logs.s3.prefix":             "app",
+		}},
+	}
+	for _, test := range tests {
+		lbAttributes, tgAttributes := attributesV2(context.Background(), test.targetType, attributes)
+		got := map[string]string{}
+		for _, attribute := range lbAttributes {
+			got[*attribute.Key] = *attribute.Value
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s load balancer attributes = %v, want %v", test.targetType, got, test.want)
+		}
+		if len(tgAttributes) != 1 || *tgAttributes[0].Key != "deregistration_delay.timeout_seconds" || *tgAttributes[0].Value != "120" {
+			t.Errorf("%s target group attributes = %v, want a deregistration delay of 120", test.targetType, tgAttributes)
+		}
+	}
+}
+
+func TestMigrateToALBCopiesAttributes(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useALB()
+	tm.elb.attributes["app"] = &elb.LoadBalancerAttributes{
+		ConnectionDraining: &elb.ConnectionDraining{Enabled: aws.Bool(false), Timeout: aws.Int64(300)},
+		ConnectionSettings: &elb.ConnectionSettings{IdleTimeout: aws.Int64(300)},
+	}
+
+	if _, err := replicateV2(context.Background(), tm.c, tm.opts, "app", "app-r"); err != nil {
+		t.Fatalf("replicateV2: %v", err)
+	}
+
+	alb := tm.elbv2.loadBalancers["app-r"]
+	if got := tm.elbv2.lbAttributes[*alb.LoadBalancerArn]; len(got) != 1 || *got[0].Key != "idle_timeout.timeout_seconds" || *got[0].Value != "300" {
+		t.Errorf("load balancer attributes = %v, want an idle timeout of 300", got)
+	}
+	targetGroupArn := *tm.listenersV2("app-r")[80].DefaultActions[0].TargetGroupArn
+	if got := tm.elbv2.attributes[targetGroupArn]; len(got) != 1 || *got[0].Value != "0" {
+		t.Errorf("target group attributes = %v, want no deregistration delay", got)
+	}
+}
+
+func TestReplicateV2RefusesTCPListenerOnALB(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useALB()
+	source := tm.elb.loadBalancers["app"]
+	source.ListenerDescriptions = append(source.ListenerDescriptions, &elb.ListenerDescription{
+		Listener: &elb.Listener{Protocol: aws.String("TCP"), LoadBalancerPort: aws.Int64(5000), InstanceProtocol: aws.String("TCP"), InstancePort: aws.Int64(5000)},
+	})
+
+	replica, err := replicateV2(context.Background(), tm.c, tm.opts, "app", "app-r")
+	if err == nil || !strings.Contains(err.Error(), "--target-type nlb") {
+		t.Fatalf("replicateV2 = %v, want a refusal suggesting an NLB", err)
+	}
+	if replica != nil || len(tm.elbv2.loadBalancers) != 0 {
+		t.Error("an ALB was created for a TCP listener")
+	}
+}
+
+func TestTargetGroupName(t *testing.T) {
+	tests := []struct {
+		elbName string
+		want    string
+	}{
+		{"some-app-r", "some-app-r-http80"},
+		{"a-very-long-load-balancer-name-r", "a-very-long-load-balancer-http80"},
+		{"a-very-long-load-balancer-n-name", "a-very-long-load-balancer-http80"},
+	}
+	for _, test := range tests {
+		got := targetGroupName(test.elbName, "HTTP", 80)
+		if got != test.want || len(got) > 32 {
+			t.Errorf("targetGroupName(%q) = %q, want %q", test.elbName, got, test.want)
+		}
+	}
+}
+
+// addTCPListener gives the source ELB a TCP listener on port 5000 whose
+// instance port has a proxy protocol policy.
+func (tm *testMigration) addTCPListener() {
+	source := tm.elb.loadBalancers["app"]
+	source.ListenerDescriptions = append(source.ListenerDescriptions, &elb.ListenerDescription{
+		Listener: &elb.Listener{Protocol: aws.String("TCP"), LoadBalancerPort: aws.Int64(5000), InstanceProtocol: aws.String("TCP"), InstancePort: aws.Int64(5000)},
+	})
+	source.BackendServerDescriptions = []*elb.BackendServerDescription{{InstancePort: aws.Int64(5000), PolicyNames: aws.StringSlice([]string{"proxy-protocol"})}}
+	tm.elb.policies["app"] = append(tm.elb.policies["app"], &elb.PolicyDescription{
+		PolicyName:     aws.String("proxy-protocol"),
+		PolicyTypeName: aws.String("ProxyProtocolPolicyType"),
+		PolicyAttributeDescriptions: []*elb.PolicyAttributeDescription{
+			{AttributeName: aws.String("ProxyProtocol"), AttributeValue: aws.String("true")},
+		},
+	})
+}
+
+func TestMigrateToNLB(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.opts.targetType = targetTypeNLB
+	tm.addTCPListener()
+
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate: %v", err)
+	}
+
+	nlb, ok := tm.elbv2.loadBalancers["app-r"]
+	if !ok {
+		t.Fatal("NLB app-r was not created")
+	}
+	if *nlb.Type != elbv2.LoadBalancerTypeEnumNetwork {
+		t.Errorf("load balancer type = %s, want network", *nlb.Type)
+	}
+	listeners := tm.listenersV2("app-r")
+	for _, port := range []int64{80, 5000} {
+		listener := listeners[port]
+		if listener == nil || *listener.Protocol != elbv2.ProtocolEnumTcp {
+			t.Errorf("listener on port %d = %v, want a TCP one", port, listener)
+			continue
+		}
+		targetGroup := tm.elbv2.targetGroups[*listener.DefaultActions[0].TargetGroupArn]
+		if *targetGroup.Protocol != elbv2.ProtocolEnumTcp {
+			t.Errorf("target group of port %d uses %s, want TCP", port, *targetGroup.Protocol)
+		}
+	}
+	recordSets := tm.recordSets()
+	if len(recordSets) != 1 || !recordSetPointsTo(recordSets[0], *nlb.DNSName) {
+		t.Errorf("record sets = %v, want only green pointing at the NLB", recordSets)
+	}
+}
+
+func TestReplicateV2NLBDeclined(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.opts.targetType = targetTypeNLB
+	tm.addTCPListener()
+	stdin = bufio.NewReader(strings.NewReader("n\n"))
+
+	_, err := replicateV2(context.Background(), tm.c, tm.opts, "app", "app-r")
+	if !errors.Is(err, errAborted) {
+		t.Fatalf("replicateV2 = %v, want errAborted", err)
+	}
+	if len(tm.elbv2.loadBalancers) != 0 {
+		t.Error("an NLB was created although its shortcomings were declined")
+	}
+}
+
+func TestUnsupportedByNLB(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.addTCPListener()
+
+	unsupported := unsupportedByNLB(tm.elb.loadBalancers["app"], tm.elb.policies["app"])
+	if len(unsupported) != 2 ||
+		!strings.HasPrefix(unsupported[0], "listener 80: HTTP is forwarded as TCP") ||
+		!strings.HasPrefix(unsupported[1], "instance port 5000: proxy protocol policy proxy-protocol") {
+		t.Errorf("unsupportedByNLB = %q, want the HTTP listener and the proxy protocol policy", unsupported)
+	}
+}
+
+func TestReplicateV2RefusesALBInOneZone(t *testing.T) {
+	tests := []struct {
+		subnets []string
+		want    string
+	}{
+		{[]string{"subnet-a"}, "would only have subnet-a"},
+		{[]string{"subnet-a", "subnet-a2"}, "would only have subnet-a, subnet-a2"},
+	}
+	for _, test := range tests {
+		tm := newTestMigration(t)
+		tm.useALB()
+		tm.ec2.addSubnet("subnet-a2", "vpc-1", "us-west-2a", nil)
+		tm.elb.loadBalancers["app"].Subnets = aws.StringSlice(test.subnets)
+
+		_, err := replicateV2(context.Background(), tm.c, tm.opts, "app", "app-r")
+		if err == nil || !strings.Contains(err.Error(), "at least two availability zones") || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("replicateV2 with subnets %v = %v, want a refusal naming them", test.subnets, err)
+		}
+		if len(tm.elbv2.loadBalancers) != 0 {
+			t.Errorf("an ALB was created in the subnets %v", test.subnets)
+		}
+	}
+}
+
+// addSharedListener gives the source ELB an HTTP listener on port 8000 that
+// forwards to instance port 8080 like the one on port 80, and a load balancer
+// cookie stickiness policy for each listener in policies.
+func (tm *testMigration) addSharedListener(policies map[int64]int64) {
+	source := tm.elb.loadBalancers["app"]
+	source.ListenerDescriptions = append(source.ListenerDescriptions, &elb.ListenerDescription{
+		Listener: &elb.Listener{Protocol: aws.String("HTTP"), LoadBalancerPort: aws.Int64(8000), InstanceProtocol: aws.String("HTTP"), InstancePort: aws.Int64(8080)},
+	})
+	for _, listenerDescription := range source.ListenerDescriptions {
+		period, ok := policies[*listenerDescription.Listener.LoadBalancerPort]
+		if !ok {
+			continue
+		}
+		policyName := fmt.Sprintf("sticky-%d", *listenerDescription.Listener.LoadBalancerPort)
+		source.Policies.LBCookieStickinessPolicies = append(source.Policies.LBCookieStickinessPolicies, &elb.LBCookieStickinessPolicy{
+			PolicyName:             aws.String(policyName),
+			CookieExpirationPeriod: aws.Int64(period),
+		})
+		listenerDescription.PolicyNames = aws.StringSlice([]string{policyName})
+	}
+}
+
+func TestSharedStickinessConflicts(t *testing.T) {
+	tests := []struct {
+		policies           map[int64]int64
+		stickinessDuration int64
+		want               []string
+	}{
+		{nil, 0, nil},
+		{map[int64]int64{80: 600, 8000: 600}, 0, nil},
+		{map[int64]int64{80: 600, 8000: 300}, 0, []string{"listener 8000 (lb_cookie 300s) gets lb_cookie 600s of listener 80"}},
+		{map[int64]int64{80: 600, 8000: 300}, 3600, nil},
+		{map[int64]int64{8000: 300}, 0, []string{"listener 8000 (lb_cookie 300s) gets none of listener 80"}},
+	}
+	for _, test := range tests {
+		tm := newTestMigration(t)
+		tm.addSharedListener(test.policies)
+		got := sharedStickinessConflicts(tm.elb.loadBalancers["app"], test.stickinessDuration)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("sharedStickinessConflicts(%v, %d) = %q, want %q", test.policies, test.stickinessDuration, got, test.want)
+		}
+	}
+}
+
+func TestReplicateV2SharedStickinessDeclined(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.useALB()
+	tm.addSharedListener(map[int64]int64{80: 600, 8000: 300})
+	dryRun := &plan{}
+	if _, err := replicateV2(withPlan(context.Background(), dryRun), tm.c, tm.opts, "app", "app-r"); err != nil {
+		t.Fatalf("replicateV2 dry run: %v", err)
+	}
+	if warnings := strings.Join(dryRun.Warnings, "\n"); !strings.Contains(warnings, "listener 8000 (lb_cookie 300s) gets lb_cookie 600s of listener 80") {
+		t.Errorf("warnings = %q, want the differing stickiness", dryRun.Warnings)
+	}
+
+	stdin = bufio.NewReader(strings.NewReader("n\n"))
+	if _, err := replicateV2(context.Background(), tm.c, tm.opts, "app", "app-r"); !errors.Is(err, errAborted) {
+		t.Fatalf("replicateV2 = %v, want errAborted", err)
+	}
+	if len(tm.elbv2.loadBalancers) != 0 {
+		t.Error("an ALB was created although the differing stickiness was declined")
+	}
+}
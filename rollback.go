@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// rollbackAction undoes one change made by a migration.
+type rollbackAction struct {
+	description string
+	undo        func(ctx context.Context) error
+}
+
+// rollbackJournal records how to undo every change a migration has made so
+// far. When a step fails, or the run is interrupted, the changes are undone
+// in reverse order.
+type rollbackJournal struct {
+	mu      sync.Mutex
+	actions []rollbackAction
+}
+
+func (j *rollbackJournal) record(description string, undo func(ctx context.Context) error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.actions = append(j.actions, rollbackAction{description: description, undo: undo})
+}
+
+// discard forgets all recorded actions. It is called once the migration has
+// passed the point where undoing it would lose traffic, e.g. after the
+// source ELB has been deleted.
+func (j *rollbackJournal) discard() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.actions = nil
+}
+
+func (j *rollbackJournal) empty() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.actions) == 0
+}
+
+// rollback runs the recorded actions in reverse order. A failing action does
+// not stop the remaining ones; all failures are returned together.
+func (j *rollbackJournal) rollback(ctx context.Context) error {
+	j.mu.Lock()
+	actions := j.actions
+	j.actions = nil
+	j.mu.Unlock()
+
+	var failures []string
+	for i := len(actions) - 1; i >= 0; i-- {
+		action := actions[i]
+		fmt.Fprintln(stdout(ctx), "Rolling back: "+action.description)
+		if err := action.undo(ctx); err != nil {
+			fmt.Fprintln(stdout(ctx), "Rollback failed: ", err)
+			failures = append(failures, fmt.Sprintf("%s: %v", action.description, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("rollback incomplete, undo manually:\n  %s", strings.Join(failures, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestRollbackJournal(t *testing.T) {
+	var journal rollbackJournal
+	var undone []string
+	undo := func(description string, err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			undone = append(undone, description)
+			return err
+		}
+	}
+	journal.record("first", undo("first", nil))
+	journal.record("second", undo("second", errors.New("still in use")))
+	journal.record("third", undo("third", nil))
+
+	err := journal.rollback(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "second: still in use") {
+		t.Errorf("rollback = %v, want the failure of second", err)
+	}
+	if want := []string{"third", "second", "first"}; !reflect.DeepEqual(undone, want) {
+		t.Errorf("undone %v, want %v", undone, want)
+	}
+	if !journal.empty() {
+		t.Error("the journal still holds actions after rolling back")
+	}
+}
+
+func TestMigrateRollsBackFailedShift(t *testing.T) {
+	tm := newTestMigration(t)
+	// Creating green is the first change, green weight 20 the second.
+	tm.route53.failChange = 3
+
+	err := runMigrate(context.Background(), tm.c, tm.opts)
+	var stepErr *stepError
+	if !errors.As(err, &stepErr) || stepErr.Step != stepShift.name {
+		t.Fatalf("runMigrate = %v, want a failed shift step", err)
+	}
+
+	if tm.hasLoadBalancer("app-r") {
+		t.Error("replica app-r was not deleted")
+	}
+	if !tm.hasLoadBalancer("app") {
+		t.Error("source ELB app was deleted")
+	}
+	if weights := tm.weights(); !reflect.DeepEqual(weights, map[string]int64{"app": 100}) {
+		t.Errorf("weights = %v, want app 100 only", weights)
+	}
+}
+
+func TestMigrateRollsBackWhenInterrupted(t *testing.T) {
+	tm := newTestMigration(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Interrupt the run while the first green weight bakes.
+	sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+
+	err := runMigrate(ctx, tm.c, tm.opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("runMigrate = %v, want context.Canceled", err)
+	}
+
+	if tm.hasLoadBalancer("app-r") {
+		t.Error("replica app-r was not deleted")
+	}
+	if weights := tm.weights(); !reflect.DeepEqual(weights, map[string]int64{"app": 100}) {
+		t.Errorf("weights = %v, want app 100 only", weights)
+	}
+}
+
+func TestMigrateNoRollback(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.route53.failChange = 3
+	tm.opts.noRollback = true
+
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err == nil {
+		t.Fatal("runMigrate succeeded despite the failed change")
+	}
+
+	if !tm.hasLoadBalancer("app-r") {
+		t.Error("replica app-r was deleted despite --no-rollback")
+	}
+	if weights := tm.weights(); !reflect.DeepEqual(weights, map[string]int64{"app": 80, "app-r": 20}) {
+		t.Errorf("weights = %v, want them left at app 80, app-r 20", weights)
+	}
+}
+
+// makeBlueSimple turns the weighted record set of the test zone into a simple
+// one.
+func (tm *testMigration) makeBlueSimple() {
+	blue := tm.recordSets()[0]
+	blue.SetIdentifier = nil
+	blue.Weight = nil
+}
+
+func TestRollbackRestoresSimpleRecordSet(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.makeBlueSimple()
+	tm.opts.statePath = filepath.Join(t.TempDir(), "state.json")
+	tm.becomeUnhealthy(onUnhealthyHalt)
+	if err := runMigrate(context.Background(), tm.c, tm.opts); !errors.Is(err, errShiftHalted) {
+		t.Fatalf("runMigrate = %v, want errShiftHalted", err)
+	}
+
+	tm.opts.command = "rollback"
+	if err := runRollback(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runRollback: %v", err)
+	}
+
+	recordSets := tm.recordSets()
+	if len(recordSets) != 1 || recordSets[0].SetIdentifier != nil || recordSets[0].Weight != nil ||
+		!recordSetPointsTo(recordSets[0], testSourceDNSName) {
+		t.Errorf("record sets = %v, want only the simple one pointing at app", recordSets)
+	}
+	if tm.hasLoadBalancer("app-r") {
+		t.Error("replica app-r was not deleted")
+	}
+	state, err := loadState(tm.opts.statePath)
+	if err != nil || !state.RolledBack {
+		t.Errorf("state = %+v, %v, want it marked rolled back", state, err)
+	}
+}
+
+func TestRollbackWithoutState(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.becomeUnhealthy(onUnhealthyHalt)
+	if err := runMigrate(context.Background(), tm.c, tm.opts); !errors.Is(err, errShiftHalted) {
+		t.Fatalf("runMigrate = %v, want errShiftHalted", err)
+	}
+
+	tm.opts.command = "rollback"
+	tm.opts.statePath = filepath.Join(t.TempDir(), "missing.json")
+	if err := runRollback(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runRollback: %v", err)
+	}
+
+	if weights := tm.weights(); !reflect.DeepEqual(weights, map[string]int64{"app": 100}) {
+		t.Errorf("weights = %v, want app 100 only", weights)
+	}
+	if tm.hasLoadBalancer("app-r") {
+		t.Error("replica app-r was not deleted")
+	}
+}
+
+func TestMigrateFromSimpleRecordSet(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.makeBlueSimple()
+
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate: %v", err)
+	}
+
+	recordSets := tm.recordSets()
+	if len(recordSets) != 1 || aws.StringValue(recordSets[0].SetIdentifier) != "app-r" || aws.Int64Value(recordSets[0].Weight) != 100 ||
+		!recordSetPointsTo(recordSets[0], *tm.elb.loadBalancers["app-r"].DNSName) {
+		t.Errorf("record sets = %v, want only green app-r with weight 100 pointing at the replica", recordSets)
+	}
+	// Route53 does not allow a simple and a weighted record set of one name
+	// side by side, so the conversion and green go in one batch.
+	want := []string{"DELETE " + testCNAME + " CNAME", "CREATE " + testCNAME + " CNAME app", "CREATE " + testCNAME + " CNAME app-r"}
+	if !reflect.DeepEqual(tm.route53.batches[0], want) {
+		t.Errorf("first change batch = %q, want %q", tm.route53.batches[0], want)
+	}
+}
+
+func TestMigrateFromSimpleRecordSetRollsBack(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.makeBlueSimple()
+	// Creating green is the first change, green weight 20 the second.
+	tm.route53.failChange = 2
+
+	var stepErr *stepError
+	if err := runMigrate(context.Background(), tm.c, tm.opts); !errors.As(err, &stepErr) || stepErr.Step != stepShift.name {
+		t.Fatalf("runMigrate = %v, want a failed shift step", err)
+	}
+
+	recordSets := tm.recordSets()
+	if len(recordSets) != 1 || recordSets[0].SetIdentifier != nil || recordSets[0].Weight != nil ||
+		!recordSetPointsTo(recordSets[0], testSourceDNSName) {
+		t.Errorf("record sets = %v, want only the simple one pointing at app", recordSets)
+	}
+	want := [][]string{
+		{"DELETE " + testCNAME + " CNAME", "CREATE " + testCNAME + " CNAME app", "CREATE " + testCNAME + " CNAME app-r"},
+		{"DELETE " + testCNAME + " CNAME app", "DELETE " + testCNAME + " CNAME app-r", "CREATE " + testCNAME + " CNAME"},
+	}
+	if !reflect.DeepEqual(tm.route53.batches, want) {
+		t.Errorf("change batches = %q, want %q", tm.route53.batches, want)
+	}
+}
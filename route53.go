@@ -1,214 +1,234 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
-	"regexp"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
 	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
 )
 
-func findHostedZone(dnsName string) *route53.HostedZone {
-	fmt.Println("Finding hosted zone " + dnsName)
-	svc := route53.New(session.New())
+func findHostedZone(ctx context.Context, svc route53iface.Route53API, dnsName string) (*route53.HostedZone, error) {
+	fmt.Fprintln(stdout(ctx), "Finding hosted zone "+dnsName)
 	targetDNSName := dnsName
 	input := &route53.ListHostedZonesByNameInput{}
 	input.SetDNSName(targetDNSName)
 	result, err := svc.ListHostedZonesByName(input)
 	if err != nil {
-		fmt.Println(err.Error())
+		return nil, newAWSError("ListHostedZonesByName", dnsName, err)
 	}
 
-	targetHostedZone := &route53.HostedZone{}
 	for _, value := range result.HostedZones {
 		if *value.Name == targetDNSName {
-			fmt.Println("Found Target Hosted Zone: ", *value)
-			targetHostedZone = value
+			fmt.Fprintln(stdout(ctx), "Found Target Hosted Zone: ", *value)
+			return value, nil
 		}
 	}
-	if targetHostedZone.Id == nil {
-		fmt.Println("hosted zone not found")
-		return nil
-	}
 
-	return targetHostedZone
+	return nil, fmt.Errorf("%w: %s", errHostedZoneNotFound, dnsName)
 }
 
-func findResourceRecord(targetRecordSetName string, hostedZone *route53.HostedZone, token *string) *route53.ResourceRecordSet {
-	fmt.Print("Finding resource record using name and hosted zone: ", targetRecordSetName, *hostedZone.Name)
-	if token != nil {
-		fmt.Println("Using token: ", *token)
+// listHostedZones returns every hosted zone of the account.
+func listHostedZones(svc route53iface.Route53API) ([]*route53.HostedZone, error) {
+	var zones []*route53.HostedZone
+	err := svc.ListHostedZonesPages(&route53.ListHostedZonesInput{}, func(page *route53.ListHostedZonesOutput, lastPage bool) bool {
+		zones = append(zones, page.HostedZones...)
+		return true
+	})
+	if err != nil {
+		return nil, newAWSError("ListHostedZones", "hosted zones", err)
 	}
+	return zones, nil
+}
 
-	svc := route53.New(session.New())
+// supportedRecordTypes are the record set types that can point at an ELB:
+// CNAME records, and A and AAAA alias records.
+var supportedRecordTypes = []string{route53.RRTypeCname, route53.RRTypeA, route53.RRTypeAaaa}
 
-	recordSetInput := &route53.ListResourceRecordSetsInput{}
-	recordSetInput.SetHostedZoneId(*hostedZone.Id)
-	if token != nil {
-		recordSetInput.SetStartRecordName(*token)
-	} else {
-		recordSetInput.SetStartRecordName(targetRecordSetName)
+// dualstackPrefix is prepended to ELB DNS names by alias records that answer
+// both A and AAAA queries.
+const dualstackPrefix = "dualstack."
+
+func isSupportedRecordType(recordType string) bool {
+	for _, supported := range supportedRecordTypes {
+		if recordType == supported {
+			return true
+		}
 	}
+	return false
+}
 
-	recordSetInput.SetMaxItems("100")
-	recordSetInput.SetStartRecordType("CNAME")
-	listRes, err := svc.ListResourceRecordSets(recordSetInput)
-	if err != nil {
-		fmt.Println(err.Error())
+// matchesRecordType reports whether recordSet has the given type, or any type
+// that can point at an ELB when recordType is empty. A and AAAA record sets
+// only match when they are alias records.
+func matchesRecordType(recordSet *route53.ResourceRecordSet, recordType string) bool {
+	setType := aws.StringValue(recordSet.Type)
+	if recordType != "" && setType != recordType {
+		return false
 	}
-	var recordSet *route53.ResourceRecordSet
-	for _, value := range listRes.ResourceRecordSets {
-		if *value.Name == targetRecordSetName {
-			recordSet = value
-			break
-		}
+	switch setType {
+	case route53.RRTypeCname:
+		return true
+	case route53.RRTypeA, route53.RRTypeAaaa:
+		return recordSet.AliasTarget != nil
 	}
-	if recordSet == nil && listRes.NextRecordName != nil {
-		return findResourceRecord(targetRecordSetName, hostedZone, listRes.NextRecordName)
+	return false
+}
+
+// findResourceRecord returns the first record set named targetRecordSetName
+// of recordType, or of any supported type when recordType is empty.
+func findResourceRecord(ctx context.Context, svc route53iface.Route53API, targetRecordSetName string, recordType string, hostedZone *route53.HostedZone) (*route53.ResourceRecordSet, error) {
+	fmt.Fprintln(stdout(ctx), "Finding resource record using name and hosted zone: ", targetRecordSetName, *hostedZone.Name)
+	recordSets, err := findResourceRecordsByName(ctx, svc, targetRecordSetName, hostedZone)
+	if err != nil {
+		return nil, err
 	}
-	if recordSet != nil {
-		return recordSet
+	for _, recordSet := range recordSets {
+		if matchesRecordType(recordSet, recordType) {
+			return recordSet, nil
+		}
 	}
 
-	return nil
+	return nil, fmt.Errorf("%w: %s in %s", errRecordSetNotFound, targetRecordSetName, *hostedZone.Name)
 }
 
-func cnameBatchChange(changes []*route53.Change, hostedZone route53.HostedZone) *route53.ChangeResourceRecordSetsOutput {
-	fmt.Println("cname batch change...")
-	svc := route53.New(session.New())
+func cnameBatchChange(ctx context.Context, svc route53iface.Route53API, changes []*route53.Change, hostedZone route53.HostedZone) (*route53.ChangeResourceRecordSetsOutput, error) {
+	fmt.Fprintln(stdout(ctx), "cname batch change...")
 	changeSetInput := &route53.ChangeResourceRecordSetsInput{
 		ChangeBatch:  &route53.ChangeBatch{},
 		HostedZoneId: hostedZone.Id,
 	}
 	changeSetInput.ChangeBatch.SetChanges(changes)
+	if planned(ctx, "route53", "ChangeResourceRecordSets", describeChanges(changes), changeSetInput) {
+		return &route53.ChangeResourceRecordSetsOutput{}, nil
+	}
 	result, err := svc.ChangeResourceRecordSets(changeSetInput)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case route53.ErrCodeNoSuchHostedZone:
-				fmt.Println(route53.ErrCodeNoSuchHostedZone, aerr.Error())
-			case route53.ErrCodeNoSuchHealthCheck:
-				fmt.Println(route53.ErrCodeNoSuchHealthCheck, aerr.Error())
-			case route53.ErrCodeInvalidChangeBatch:
-				fmt.Println(route53.ErrCodeInvalidChangeBatch, aerr.Error())
-			case route53.ErrCodeInvalidInput:
-				fmt.Println(route53.ErrCodeInvalidInput, aerr.Error())
-			case route53.ErrCodePriorRequestNotComplete:
-				fmt.Println(route53.ErrCodePriorRequestNotComplete, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			fmt.Println(err.Error())
-		}
-		return nil
+		return nil, newAWSError("ChangeResourceRecordSets", *hostedZone.Name, err)
 	}
 
-	return result
+	return result, nil
 }
 
-func changeResourceRecordSet(action string, resourceRecordSet *route53.ResourceRecordSet, hostedZone route53.HostedZone) *route53.GetChangeOutput {
-	fmt.Println("changeResourceRecordSet: ", action, *resourceRecordSet.Name, *hostedZone.Name)
-	svc := route53.New(session.New())
-	newResourceRecordSet := resourceRecordSet
-	changeSetInput := &route53.ChangeResourceRecordSetsInput{
-		ChangeBatch:  &route53.ChangeBatch{},
-		HostedZoneId: hostedZone.Id,
+// How waitForChange polls a change: first after changePollInterval, then
+// twice as long each time up to maxChangePollInterval, for at most
+// changeTimeout.
+const (
+	changePollInterval    = 2 * time.Second
+	maxChangePollInterval = 30 * time.Second
+	changeTimeout         = 5 * time.Minute
+)
+
+// waitForChange polls Route53 until the change is no longer PENDING, i.e. the
+// name servers answer with it. A nil changeInfo, that of a change planned
+// during a dry run, needs no waiting.
+func waitForChange(ctx context.Context, svc route53iface.Route53API, changeInfo *route53.ChangeInfo) (*route53.GetChangeOutput, error) {
+	changeStatusResult := &route53.GetChangeOutput{ChangeInfo: changeInfo}
+	if changeInfo == nil {
+		return changeStatusResult, nil
 	}
-	change := &route53.Change{}
-	change.SetAction(action)
-	change.SetResourceRecordSet(newResourceRecordSet)
-	changeSetInput.ChangeBatch.SetChanges([]*route53.Change{
-		change,
-	})
-	fmt.Println("ChangeResourceRecordSetsInput: ", changeSetInput)
-	result, err := svc.ChangeResourceRecordSets(changeSetInput)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case route53.ErrCodeNoSuchHostedZone:
-				fmt.Println(route53.ErrCodeNoSuchHostedZone, aerr.Error())
-			case route53.ErrCodeNoSuchHealthCheck:
-				fmt.Println(route53.ErrCodeNoSuchHealthCheck, aerr.Error())
-			case route53.ErrCodeInvalidChangeBatch:
-				fmt.Println(route53.ErrCodeInvalidChangeBatch, aerr.Error())
-			case route53.ErrCodeInvalidInput:
-				fmt.Println(route53.ErrCodeInvalidInput, aerr.Error())
-			case route53.ErrCodePriorRequestNotComplete:
-				fmt.Println(route53.ErrCodePriorRequestNotComplete, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
+	// The timeout includes the time the GetChange calls take, not only the
+	// pauses between them.
+	deadline := timeNow().Add(changeTimeout)
+	interval := changePollInterval
+	for polls := 0; *changeStatusResult.ChangeInfo.Status == route53.ChangeStatusPending; polls++ {
+		if !timeNow().Before(deadline) {
+			return nil, fmt.Errorf("change %s is still %s after %s", *changeInfo.Id, route53.ChangeStatusPending, changeTimeout)
+		}
+		if polls > 0 && interval < maxChangePollInterval {
+			interval *= 2
+			if interval > maxChangePollInterval {
+				interval = maxChangePollInterval
 			}
-		} else {
-			fmt.Println(err.Error())
 		}
-	}
-	status := result.ChangeInfo.Status
-	checkInterval := 5
-	var changeStatusResult route53.GetChangeOutput
-	for *status == "PENDING" {
+		if err := sleep(ctx, interval); err != nil {
+			return nil, err
+		}
 		getChangeInput := &route53.GetChangeInput{
-			Id: result.ChangeInfo.Id,
+			Id: changeInfo.Id,
 		}
-		changeStatus, err := svc.GetChange(getChangeInput)
-		changeStatusResult = *changeStatus
+		var err error
+		changeStatusResult, err = svc.GetChange(getChangeInput)
 		if err != nil {
-			fmt.Println("Error getting change info. Returning last change info result.")
-			return &changeStatusResult
+			return nil, newAWSError("GetChange", *changeInfo.Id, err)
 		}
-		status = changeStatusResult.ChangeInfo.Status
-		fmt.Println("Change status: " + *status)
-		time.Sleep(time.Duration(checkInterval) * time.Second)
+		fmt.Fprintln(stdout(ctx), "Change status: "+*changeStatusResult.ChangeInfo.Status)
 	}
 
-	return &changeStatusResult
+	return changeStatusResult, nil
 }
 
-func findElbNameFromDNSRecordSet(hostedZoneDNS string, targetDNS string) string {
-	fmt.Println("Determining ELB name from DNS...")
-	hostedZone := findHostedZone(hostedZoneDNS)
-	var sourceResourceRecord *route53.ResourceRecordSet
-	if hostedZone != nil {
-		sourceResourceRecord = findResourceRecord(targetDNS, hostedZone, nil)
+// findElbNamesFromDNSRecordSet returns the distinct ELBs the record sets
+// named targetDNS point at, in the order the record sets are listed. While a
+// migration is in progress these are the source ELB and its replica.
+func findElbNamesFromDNSRecordSet(ctx context.Context, svc route53iface.Route53API, elbSvc elbiface.ELBAPI, hostedZoneDNS string, targetDNS string, recordType string) ([]string, error) {
+	fmt.Fprintln(stdout(ctx), "Determining ELB name from DNS...")
+	hostedZone, err := findHostedZone(ctx, svc, hostedZoneDNS)
+	if err != nil {
+		return nil, err
 	}
-	if sourceResourceRecord == nil {
-		fmt.Println("No Route53 ResourceRecord found for ", targetDNS, "Exiting.")
-		os.Exit(0)
+	recordSets, err := findResourceRecordsByName(ctx, svc, targetDNS, hostedZone)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("Found Resource Record: ", sourceResourceRecord)
-	re := regexp.MustCompile(`(internal-)(.*)(-(\d.*)\.(\w{2}\-(.*)-\d)\.elb.amazonaws.com)`)
 
-	captureGroups := re.FindStringSubmatch(*sourceResourceRecord.ResourceRecords[0].Value)
-	fmt.Println("Found ELB Name: ", captureGroups[2])
-	elbName := captureGroups[2]
+	var elbNames []string
+	seen := map[string]bool{}
+	for _, recordSet := range recordSets {
+		if !matchesRecordType(recordSet, recordType) {
+			continue
+		}
+		fmt.Fprintln(stdout(ctx), "Found Resource Record: ", recordSet)
+		value := recordSetValue(recordSet)
+		if value == "" {
+			return nil, fmt.Errorf("record set %s has no values to take the ELB name from", targetDNS)
+		}
+		elbName, err := findElbNameByDNSName(ctx, elbSvc, value)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintln(stdout(ctx), "Found ELB Name: ", elbName)
+		if !seen[elbName] {
+			seen[elbName] = true
+			elbNames = append(elbNames, elbName)
+		}
+	}
+	if len(elbNames) == 0 {
+		return nil, fmt.Errorf("%w: %s in %s", errRecordSetNotFound, targetDNS, *hostedZone.Name)
+	}
 
-	return elbName
+	return elbNames, nil
 }
 
-func deleteRecordSet(dnsName string, hostedZone *route53.HostedZone, recordSet *route53.ResourceRecordSet) {
-	svc := route53.New(session.New())
+func deleteRecordSet(ctx context.Context, svc route53iface.Route53API, dnsName string, hostedZone *route53.HostedZone, recordSet *route53.ResourceRecordSet) error {
 	changeBatchInput := &route53.ChangeResourceRecordSetsInput{
 		HostedZoneId: hostedZone.Id,
 		ChangeBatch: &route53.ChangeBatch{
 			Changes: []*route53.Change{
 				{
-					Action: aws.String("DELETE"),
+					Action:            aws.String("DELETE"),
 					ResourceRecordSet: recordSet,
 				},
 			},
 		},
 	}
+	if planned(ctx, "route53", "ChangeResourceRecordSets", describeChanges(changeBatchInput.ChangeBatch.Changes), changeBatchInput) {
+		return nil
+	}
 
 	response, err := svc.ChangeResourceRecordSets(changeBatchInput)
 	if err != nil {
-		fmt.Println(err)
+		return newAWSError("ChangeResourceRecordSets", "DELETE "+dnsName, err)
 	}
-	fmt.Println(response)
+	fmt.Fprintln(stdout(ctx), response)
+	_, err = waitForChange(ctx, svc, response.ChangeInfo)
+	return err
 }
 
 func createResourceRecordSet(name string, value string, setID string) *route53.ResourceRecordSet {
@@ -230,43 +250,281 @@ func createResourceRecordSet(name string, value string, setID string) *route53.R
 	return newResourceRecordSet
 }
 
-func weightedBlueGreen(blueResourceRecordSet *route53.ResourceRecordSet, greenResourceRecordSet *route53.ResourceRecordSet, zone *route53.HostedZone) {
-	interval := 5
-	bleedAmount := int64(20)
-	greenWeight := int64(0)
-	blueWeight := *blueResourceRecordSet.Weight
+// createAliasRecordSet returns a weighted alias record set of recordType
+// whose target is the ELB with the given DNS name and canonical hosted zone.
+func createAliasRecordSet(name string, recordType string, dnsName string, hostedZoneID string, evaluateTargetHealth bool, setID string) *route53.ResourceRecordSet {
+	newResourceRecordSet := &route53.ResourceRecordSet{}
+	newResourceRecordSet.SetName(name)
+	newResourceRecordSet.SetWeight(0)
+	newResourceRecordSet.SetType(recordType)
+	newResourceRecordSet.SetSetIdentifier(setID)
+	newResourceRecordSet.SetAliasTarget(&route53.AliasTarget{
+		DNSName:              aws.String(dnsName),
+		HostedZoneId:         aws.String(hostedZoneID),
+		EvaluateTargetHealth: aws.Bool(evaluateTargetHealth),
+	})
 
-	for greenWeight < 100 {
-		blueWeight = clamp(*blueResourceRecordSet.Weight-bleedAmount, 0, 100)
-		greenWeight = clamp(*greenResourceRecordSet.Weight+bleedAmount, 0, 100)
-		fmt.Println("blue weight: ", blueWeight)
-		fmt.Println("green weight: ", greenWeight)
+	return newResourceRecordSet
+}
 
-		blueResourceRecordSet.SetWeight(blueWeight)
-		greenResourceRecordSet.SetWeight(greenWeight)
+// blueGreen is a record set that points at the source ELB, blue, and the one
+// of the same name and type that points at its replica, green.
+type blueGreen struct {
+	zone  *route53.HostedZone
+	blue  *route53.ResourceRecordSet
+	green *route53.ResourceRecordSet
+}
 
-		blueChange := &route53.Change{}
-		blueChange.SetAction("UPSERT")
-		blueChange.SetResourceRecordSet(blueResourceRecordSet)
+// greenWeights returns the lowest and the highest weight of the green record
+// sets.
+func greenWeights(pairs []*blueGreen) (int64, int64) {
+	lowest, highest := int64(100), int64(0)
+	for _, pair := range pairs {
+		weight := aws.Int64Value(pair.green.Weight)
+		if weight < lowest {
+			lowest = weight
+		}
+		if weight > highest {
+			highest = weight
+		}
+	}
+	return lowest, highest
+}
 
-		greenChange := &route53.Change{}
-		greenChange.SetAction("UPSERT")
-		greenChange.SetResourceRecordSet(greenResourceRecordSet)
+// weightedBlueGreen gives every green the weights of schedule in turn, and
+// its blue the rest of 100, keeping each for bake before the next. All pairs
+// move in lock-step. Weights every green already has are skipped, so an
+// interrupted shift continues where it stopped. Every change is waited for
+// until Route53 has applied it, after which progress, when not nil, is
+// called. gate, when not nil, is waited for before every change; when it
+// fails with errShiftReverted all weight is moved back to blue first.
+func weightedBlueGreen(ctx context.Context, svc route53iface.Route53API, pairs []*blueGreen, schedule []int64, bake time.Duration, gate *shiftGate, progress func()) error {
+	fmt.Fprintln(stdout(ctx), "Shifting weights: "+formatWeights(schedule))
+	for _, weight := range schedule {
+		if lowest, _ := greenWeights(pairs); weight <= lowest {
+			continue
+		}
+		if err := gate.wait(ctx); err != nil {
+			if _, highest := greenWeights(pairs); errors.Is(err, errShiftReverted) && highest > 0 {
+				fmt.Fprintln(stdout(ctx), "Reverting all traffic to blue.")
+				if revertErr := revertToBlue(ctx, svc, pairs, progress); revertErr != nil {
+					return fmt.Errorf("%w; reverting to blue failed: %v", err, revertErr)
+				}
+			}
+			return err
+		}
+		greenWeight := clamp(weight, 0, 100)
+		fmt.Fprintln(stdout(ctx), "blue weight: ", 100-greenWeight)
+		fmt.Fprintln(stdout(ctx), "green weight: ", greenWeight)
 
-		changes := []*route53.Change{
-			blueChange,
-			greenChange,
+		if err := setWeights(ctx, svc, pairs, greenWeight); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress()
 		}
 
-		batchChangeOutput := cnameBatchChange(changes, *zone)
-		if batchChangeOutput == nil {
-			fmt.Println("Batch change failed. Stopping blue/green.")
+		if greenWeight == 100 {
 			break
 		}
-		fmt.Println(fmt.Sprintf("Waiting %ds for next bleed of amount of %d%%.", interval, bleedAmount))
-		time.Sleep(time.Duration(interval) * time.Second)
-		if blueWeight == 0 && greenWeight == 100 {
-			break
+		if planOf(ctx) == nil {
+			fmt.Fprintf(stdout(ctx), "Baking green weight %d for %s.\n", greenWeight, bake)
+			if err := sleep(ctx, bake); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// revertToBlue gives every blue weight 100 and every green weight 0.
+func revertToBlue(ctx context.Context, svc route53iface.Route53API, pairs []*blueGreen, progress func()) error {
+	if err := setWeights(ctx, svc, pairs, 0); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress()
+	}
+	return nil
+}
+
+// setWeights gives every green greenWeight and its blue the rest of 100, in
+// one change per hosted zone, and waits until Route53 has applied them.
+func setWeights(ctx context.Context, svc route53iface.Route53API, pairs []*blueGreen, greenWeight int64) error {
+	blueWeight := 100 - greenWeight
+	var zones []*route53.HostedZone
+	changes := map[string][]*route53.Change{}
+	for _, pair := range pairs {
+		zoneID := *pair.zone.Id
+		if _, ok := changes[zoneID]; !ok {
+			zones = append(zones, pair.zone)
+		}
+		changes[zoneID] = append(changes[zoneID],
+			&route53.Change{Action: aws.String("UPSERT"), ResourceRecordSet: withWeight(pair.blue, blueWeight)},
+			&route53.Change{Action: aws.String("UPSERT"), ResourceRecordSet: withWeight(pair.green, greenWeight)},
+		)
+	}
+
+	var changeInfos []*route53.ChangeInfo
+	for _, zone := range zones {
+		result, err := cnameBatchChange(ctx, svc, changes[*zone.Id], *zone)
+		if err != nil {
+			return fmt.Errorf("setting blue %d/green %d: %w", blueWeight, greenWeight, err)
+		}
+		// Only update the record sets once Route53 accepted the change, so
+		// they always match what is live.
+		for _, pair := range pairs {
+			if *pair.zone.Id == *zone.Id {
+				pair.blue.SetWeight(blueWeight)
+				pair.green.SetWeight(greenWeight)
+			}
+		}
+		changeInfos = append(changeInfos, result.ChangeInfo)
+	}
+	for _, changeInfo := range changeInfos {
+		if _, err := waitForChange(ctx, svc, changeInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// weightedRecordSet returns a weighted copy of a simple record set.
+func weightedRecordSet(recordSet *route53.ResourceRecordSet, setID string, weight int64) *route53.ResourceRecordSet {
+	weighted := withWeight(recordSet, weight)
+	weighted.SetSetIdentifier(setID)
+	return weighted
+}
+
+// simpleRecordSet returns a copy of a weighted record set without its
+// weighted routing.
+func simpleRecordSet(recordSet *route53.ResourceRecordSet) *route53.ResourceRecordSet {
+	simple := awsutil.CopyOf(recordSet).(*route53.ResourceRecordSet)
+	simple.SetIdentifier = nil
+	simple.Weight = nil
+	return simple
+}
+
+// withWeight returns a copy of recordSet with its weight set to weight.
+func withWeight(recordSet *route53.ResourceRecordSet, weight int64) *route53.ResourceRecordSet {
+	weighted := awsutil.CopyOf(recordSet).(*route53.ResourceRecordSet)
+	weighted.SetWeight(weight)
+	return weighted
+}
+
+func findResourceRecordsByName(ctx context.Context, svc route53iface.Route53API, targetRecordSetName string, hostedZone *route53.HostedZone) ([]*route53.ResourceRecordSet, error) {
+	fmt.Fprintln(stdout(ctx), "Finding all resource records named ", targetRecordSetName)
+
+	recordSetInput := &route53.ListResourceRecordSetsInput{}
+	recordSetInput.SetHostedZoneId(*hostedZone.Id)
+	recordSetInput.SetStartRecordName(targetRecordSetName)
+
+	var recordSets []*route53.ResourceRecordSet
+	err := svc.ListResourceRecordSetsPages(recordSetInput, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		for _, value := range page.ResourceRecordSets {
+			if !sameDNSName(*value.Name, targetRecordSetName) {
+				// Record sets are returned in name order, so there are no more matches.
+				return false
+			}
+			recordSets = append(recordSets, value)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, newAWSError("ListResourceRecordSets", *hostedZone.Name, err)
+	}
+
+	return recordSets, nil
+}
+
+// listResourceRecordSets returns every record set of a hosted zone.
+func listResourceRecordSets(svc route53iface.Route53API, hostedZone *route53.HostedZone) ([]*route53.ResourceRecordSet, error) {
+	recordSetInput := &route53.ListResourceRecordSetsInput{}
+	recordSetInput.SetHostedZoneId(*hostedZone.Id)
+
+	var recordSets []*route53.ResourceRecordSet
+	err := svc.ListResourceRecordSetsPages(recordSetInput, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		recordSets = append(recordSets, page.ResourceRecordSets...)
+		return true
+	})
+	if err != nil {
+		return nil, newAWSError("ListResourceRecordSets", *hostedZone.Name, err)
+	}
+
+	return recordSets, nil
+}
+
+// findResourceRecordBySetIdentifier returns the weighted record set named
+// targetRecordSetName with the given set identifier.
+func findResourceRecordBySetIdentifier(ctx context.Context, svc route53iface.Route53API, targetRecordSetName string, recordType string, hostedZone *route53.HostedZone, setIdentifier string) (*route53.ResourceRecordSet, error) {
+	recordSets, err := findResourceRecordsByName(ctx, svc, targetRecordSetName, hostedZone)
+	if err != nil {
+		return nil, err
+	}
+	for _, recordSet := range recordSets {
+		if aws.StringValue(recordSet.SetIdentifier) == setIdentifier && matchesRecordType(recordSet, recordType) {
+			return recordSet, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s (%s) in %s", errRecordSetNotFound, targetRecordSetName, setIdentifier, *hostedZone.Name)
+}
+
+// recordSetValue returns the DNS name a CNAME or alias record set points at,
+// or "" if it has none.
+func recordSetValue(recordSet *route53.ResourceRecordSet) string {
+	if recordSet.AliasTarget != nil {
+		return aws.StringValue(recordSet.AliasTarget.DNSName)
+	}
+	if len(recordSet.ResourceRecords) > 0 {
+		return aws.StringValue(recordSet.ResourceRecords[0].Value)
+	}
+	return ""
+}
+
+// recordSetPointsTo reports whether the record set resolves to dnsName. Alias
+// targets match with or without the dualstack prefix.
+func recordSetPointsTo(recordSet *route53.ResourceRecordSet, dnsName string) bool {
+	if recordSet.AliasTarget != nil {
+		target, _ := trimDualstackPrefix(aws.StringValue(recordSet.AliasTarget.DNSName))
+		return sameDNSName(target, dnsName)
+	}
+	for _, record := range recordSet.ResourceRecords {
+		if sameDNSName(*record.Value, dnsName) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimDualstackPrefix removes the dualstack prefix from an alias target and
+// reports whether it was there.
+func trimDualstackPrefix(dnsName string) (string, bool) {
+	if len(dnsName) > len(dualstackPrefix) && strings.EqualFold(dnsName[:len(dualstackPrefix)], dualstackPrefix) {
+		return dnsName[len(dualstackPrefix):], true
+	}
+	return dnsName, false
+}
+
+// sameDNSName compares two DNS names ignoring case and the trailing dot.
+func sameDNSName(a string, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
+
+// describeChanges summarizes a change batch as e.g.
+// "UPSERT some-app.test.example.com. (blue) weight 80".
+func describeChanges(changes []*route53.Change) string {
+	var parts []string
+	for _, change := range changes {
+		recordSet := change.ResourceRecordSet
+		part := *change.Action + " " + *recordSet.Name
+		if recordSet.SetIdentifier != nil {
+			part += " (" + *recordSet.SetIdentifier + ")"
+		}
+		if recordSet.Weight != nil {
+			part += fmt.Sprintf(" weight %d", *recordSet.Weight)
 		}
+		parts = append(parts, part)
 	}
+	return strings.Join(parts, ", ")
 }
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+)
+
+const testReplicaDNSName = "internal-app-r-1234567890.us-west-2.elb.amazonaws.com"
+
+// addGreen seeds the fakes with the replica app-r and a green record set
+// that sends it greenWeight, with blue keeping the rest, as a shift in
+// progress would have left them.
+func (tm *testMigration) addGreen(t *testing.T, greenWeight int64) *blueGreen {
+	t.Helper()
+	tm.elb.addLoadBalancer(&elb.LoadBalancerDescription{
+		LoadBalancerName:          aws.String("app-r"),
+		DNSName:                   aws.String(testReplicaDNSName),
+		CanonicalHostedZoneNameID: aws.String("Z1FAKEELBZONE"),
+		Scheme:                    aws.String("internal"),
+		Instances:                 []*elb.Instance{{InstanceId: aws.String("i-1")}, {InstanceId: aws.String("i-2")}},
+		Policies:                  &elb.Policies{},
+	}, nil, nil)
+
+	blue, err := findResourceRecordBySetIdentifier(context.Background(), tm.route53, testCNAME, route53.RRTypeCname, tm.zone, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := &blueGreen{zone: tm.zone, blue: withWeight(blue, 100-greenWeight), green: createResourceRecordSet(testCNAME, testReplicaDNSName, "app-r")}
+	pair.green.SetWeight(greenWeight)
+	_, err = tm.route53.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: tm.zone.Id,
+		ChangeBatch: &route53.ChangeBatch{Changes: []*route53.Change{
+			{Action: aws.String(route53.ChangeActionUpsert), ResourceRecordSet: pair.blue},
+			{Action: aws.String(route53.ChangeActionCreate), ResourceRecordSet: pair.green},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func TestWeightedBlueGreen(t *testing.T) {
+	tm := newTestMigration(t)
+	pair := tm.addGreen(t, 0)
+
+	var greenWeights []int64
+	progress := func() {
+		weights := tm.weights()
+		if weights["app"]+weights["app-r"] != 100 {
+			t.Errorf("weights = %v, want them to add up to 100", weights)
+		}
+		greenWeights = append(greenWeights, weights["app-r"])
+	}
+	if err := weightedBlueGreen(context.Background(), tm.route53, []*blueGreen{pair}, []int64{20, 50, 100}, 0, nil, progress); err != nil {
+		t.Fatalf("weightedBlueGreen: %v", err)
+	}
+
+	if want := []int64{20, 50, 100}; !reflect.DeepEqual(greenWeights, want) {
+		t.Errorf("green weights = %v, want %v", greenWeights, want)
+	}
+	if *pair.blue.Weight != 0 || *pair.green.Weight != 100 {
+		t.Errorf("pair has blue %d/green %d, want 0/100", *pair.blue.Weight, *pair.green.Weight)
+	}
+}
+
+func TestWeightedBlueGreenContinues(t *testing.T) {
+	tm := newTestMigration(t)
+	pair := tm.addGreen(t, 50)
+
+	changes := 0
+	progress := func() { changes++ }
+	if err := weightedBlueGreen(context.Background(), tm.route53, []*blueGreen{pair}, []int64{20, 50, 100}, 0, nil, progress); err != nil {
+		t.Fatalf("weightedBlueGreen: %v", err)
+	}
+
+	if changes != 1 {
+		t.Errorf("made %d changes, want only the one to 100", changes)
+	}
+	if weights := tm.weights(); weights["app"] != 0 || weights["app-r"] != 100 {
+		t.Errorf("weights = %v, want app 0, app-r 100", weights)
+	}
+}
+
+func TestWeightedBlueGreenFailedChange(t *testing.T) {
+	tm := newTestMigration(t)
+	pair := tm.addGreen(t, 0)
+	// The first change is the one addGreen made.
+	tm.route53.failChange = 3
+
+	err := weightedBlueGreen(context.Background(), tm.route53, []*blueGreen{pair}, []int64{20, 50, 100}, 0, nil, nil)
+	if !isAWSErrorCode(err, route53.ErrCodePriorRequestNotComplete) {
+		t.Fatalf("weightedBlueGreen = %v, want the failed change", err)
+	}
+
+	// The weights of the pair still match what is live.
+	if weights := tm.weights(); weights["app-r"] != 20 || *pair.green.Weight != 20 {
+		t.Errorf("weights = %v, pair green %d, want green 20", weights, *pair.green.Weight)
+	}
+}
+
+func TestFindElbNamesFromDNSRecordSet(t *testing.T) {
+	tm := newTestMigration(t)
+
+	elbNames, err := findElbNamesFromDNSRecordSet(context.Background(), tm.route53, tm.elb, testZone, testCNAME, "")
+	if err != nil {
+		t.Fatalf("findElbNamesFromDNSRecordSet: %v", err)
+	}
+	if want := []string{"app"}; !reflect.DeepEqual(elbNames, want) {
+		t.Errorf("ELB names = %v, want %v", elbNames, want)
+	}
+
+	tm.addGreen(t, 20)
+	elbNames, err = findElbNamesFromDNSRecordSet(context.Background(), tm.route53, tm.elb, testZone, testCNAME, "")
+	if err != nil {
+		t.Fatalf("findElbNamesFromDNSRecordSet: %v", err)
+	}
+	if want := []string{"app", "app-r"}; !reflect.DeepEqual(elbNames, want) {
+		t.Errorf("ELB names during a migration = %v, want %v", elbNames, want)
+	}
+}
+
+func TestFindElbNamesFromDNSRecordSetMissingRecord(t *testing.T) {
+	tm := newTestMigration(t)
+
+	_, err := findElbNamesFromDNSRecordSet(context.Background(), tm.route53, tm.elb, testZone, "other.test.example.com.", "")
+	if !errors.Is(err, errRecordSetNotFound) {
+		t.Fatalf("findElbNamesFromDNSRecordSet = %v, want errRecordSetNotFound", err)
+	}
+}
+
+// addOtherRecords seeds the fakes with two more record sets that point at the
+// source ELB: an alias record set in the test zone, and a simple CNAME in a
+// second hosted zone, Z2. A record set of Z2 that points elsewhere is left
+// alone by --all-records.
+func (tm *testMigration) addOtherRecords() {
+	tm.route53.mu.Lock()
+	tm.route53.recordSets[*tm.zone.Id] = append(tm.route53.recordSets[*tm.zone.Id], &route53.ResourceRecordSet{
+		Name: aws.String("alias." + testZone),
+		Type: aws.String(route53.RRTypeA),
+		AliasTarget: &route53.AliasTarget{
+			DNSName:              aws.String("dualstack." + testSourceDNSName + "."),
+			HostedZoneId:         aws.String("Z1FAKEELBZONE"),
+			EvaluateTargetHealth: aws.Bool(false),
+		},
+	})
+	tm.route53.sortRecordSets(*tm.zone.Id)
+	tm.route53.mu.Unlock()
+	tm.route53.addHostedZone("Z2", "other.example.com.",
+		&route53.ResourceRecordSet{
+			Name:            aws.String("www.other.example.com."),
+			Type:            aws.String(route53.RRTypeCname),
+			TTL:             aws.Int64(60),
+			ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(testSourceDNSName)}},
+		},
+		&route53.ResourceRecordSet{
+			Name:            aws.String("api.other.example.com."),
+			Type:            aws.String(route53.RRTypeCname),
+			TTL:             aws.Int64(60),
+			ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("elsewhere.example.com")}},
+		},
+	)
+}
+
+// allWeights returns the weight of every record set of every hosted zone by
+// name, type and set identifier, -1 for a simple record set.
+func (tm *testMigration) allWeights() map[string]int64 {
+	tm.route53.mu.Lock()
+	defer tm.route53.mu.Unlock()
+	weights := map[string]int64{}
+	for _, recordSets := range tm.route53.recordSets {
+		for _, recordSet := range recordSets {
+			weight := int64(-1)
+			if recordSet.Weight != nil {
+				weight = *recordSet.Weight
+			}
+			weights[*recordSet.Name+" "+*recordSet.Type+" "+aws.StringValue(recordSet.SetIdentifier)] = weight
+		}
+	}
+	return weights
+}
+
+func TestMigrateAllRecords(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.addOtherRecords()
+	tm.opts.allRecords = true
+
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate: %v", err)
+	}
+
+	want := map[string]int64{
+		"app.test.example.com. CNAME app-r":  100,
+		"alias.test.example.com. A app-r":    100,
+		"www.other.example.com. CNAME app-r": 100,
+		"api.other.example.com. CNAME ":      -1,
+	}
+	if weights := tm.allWeights(); !reflect.DeepEqual(weights, want) {
+		t.Errorf("record sets = %v, want %v", weights, want)
+	}
+	replicaDNSName := *tm.elb.loadBalancers["app-r"].DNSName
+	for _, recordSet := range tm.route53.recordSets["Z2"] {
+		if *recordSet.Name == "www.other.example.com." && !recordSetPointsTo(recordSet, replicaDNSName) {
+			t.Errorf("www.other.example.com. points at %s, want the replica", recordSetValue(recordSet))
+		}
+	}
+}
+
+func TestMigrateAllRecordsInLockStep(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.addOtherRecords()
+	tm.opts.allRecords = true
+	tm.becomeUnhealthy(onUnhealthyHalt)
+
+	if err := runMigrate(context.Background(), tm.c, tm.opts); !errors.Is(err, errShiftHalted) {
+		t.Fatalf("runMigrate = %v, want errShiftHalted", err)
+	}
+
+	want := map[string]int64{
+		"app.test.example.com. CNAME app":    80,
+		"app.test.example.com. CNAME app-r":  20,
+		"alias.test.example.com. A app":      80,
+		"alias.test.example.com. A app-r":    20,
+		"www.other.example.com. CNAME app":   80,
+		"www.other.example.com. CNAME app-r": 20,
+		"api.other.example.com. CNAME ":      -1,
+	}
+	if weights := tm.allWeights(); !reflect.DeepEqual(weights, want) {
+		t.Errorf("record sets = %v, want %v", weights, want)
+	}
+}
+
+// makeBlueAlias replaces the weighted CNAME of the test zone with weighted
+// alias A and AAAA record sets whose target is the dualstack name of app.
+func (tm *testMigration) makeBlueAlias() {
+	tm.route53.mu.Lock()
+	defer tm.route53.mu.Unlock()
+	var recordSets []*route53.ResourceRecordSet
+	for _, recordType := range []string{route53.RRTypeA, route53.RRTypeAaaa} {
+		recordSets = append(recordSets, &route53.ResourceRecordSet{
+			Name:          aws.String(testCNAME),
+			Type:          aws.String(recordType),
+			SetIdentifier: aws.String("app"),
+			Weight:        aws.Int64(100),
+			AliasTarget: &route53.AliasTarget{
+				DNSName:              aws.String("dualstack." + testSourceDNSName + "."),
+				HostedZoneId:         aws.String("Z1FAKEELBZONE"),
+				EvaluateTargetHealth: aws.Bool(true),
+			},
+		})
+	}
+	tm.route53.recordSets[*tm.zone.Id] = recordSets
+}
+
+func TestMigrateAliasRecordSets(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.makeBlueAlias()
+	tm.useALB()
+	tm.opts.allRecords = true
+
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate: %v", err)
+	}
+
+	want := map[string]int64{
+		"app.test.example.com. A app-r":    100,
+		"app.test.example.com. AAAA app-r": 100,
+	}
+	if weights := tm.allWeights(); !reflect.DeepEqual(weights, want) {
+		t.Errorf("record sets = %v, want %v", weights, want)
+	}
+	replica := tm.elbv2.loadBalancers["app-r"]
+	for _, green := range tm.recordSets() {
+		target := green.AliasTarget
+		if target == nil {
+			t.Errorf("green %s record set = %v, want an alias", *green.Type, green)
+			continue
+		}
+		// The dualstack prefix of blue is kept and the hosted zone is the
+		// ALB's, not the classic ELB's.
+		if !sameDNSName(*target.DNSName, "dualstack."+*replica.DNSName) {
+			t.Errorf("green %s alias target = %s, want dualstack.%s", *green.Type, *target.DNSName, *replica.DNSName)
+		}
+		if *target.HostedZoneId != *replica.CanonicalHostedZoneId || !*target.EvaluateTargetHealth {
+			t.Errorf("green %s alias target = %v, want hosted zone %s evaluating target health", *green.Type, target, *replica.CanonicalHostedZoneId)
+		}
+	}
+}
+
+func TestMigrateAliasRecordSetNeedsRecordType(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.makeBlueAlias()
+
+	err := runMigrate(context.Background(), tm.c, tm.opts)
+	if err == nil || !strings.Contains(err.Error(), "choose one with --record-type") {
+		t.Fatalf("runMigrate = %v, want a refusal to leave the other alias behind", err)
+	}
+
+	tm.opts.recordType = route53.RRTypeA
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate: %v", err)
+	}
+	want := map[string]int64{
+		"app.test.example.com. A app-r":  100,
+		"app.test.example.com. AAAA app": 100,
+	}
+	if weights := tm.allWeights(); !reflect.DeepEqual(weights, want) {
+		t.Errorf("record sets = %v, want %v", weights, want)
+	}
+	green := tm.recordSets()[0]
+	if !sameDNSName(*green.AliasTarget.DNSName, "dualstack."+*tm.elb.loadBalancers["app-r"].DNSName) || *green.AliasTarget.HostedZoneId != "Z1FAKEELBZONE" {
+		t.Errorf("green alias target = %v, want the dualstack name of app-r in its hosted zone", green.AliasTarget)
+	}
+}
+
+// pendingRoute53 reports every change as PENDING until insyncAfter GetChange
+// calls, never if it is 0, and lets each call take callTime on the clock the
+// test gives timeNow.
+type pendingRoute53 struct {
+	route53iface.Route53API
+	insyncAfter int
+	callTime    time.Duration
+	advance     func(time.Duration)
+	calls       int
+}
+
+func (f *pendingRoute53) GetChange(input *route53.GetChangeInput) (*route53.GetChangeOutput, error) {
+	f.calls++
+	f.advance(f.callTime)
+	status := route53.ChangeStatusPending
+	if f.insyncAfter > 0 && f.calls >= f.insyncAfter {
+		status = route53.ChangeStatusInsync
+	}
+	return &route53.GetChangeOutput{ChangeInfo: &route53.ChangeInfo{Id: input.Id, Status: aws.String(status)}}, nil
+}
+
+// useClock makes sleep and timeNow use a clock that only moves when slept on
+// or advanced, and returns the pauses slept.
+func useClock(t *testing.T, svc *pendingRoute53) *[]time.Duration {
+	t.Helper()
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var slept []time.Duration
+	timeNow = func() time.Time { return clock }
+	svc.advance = func(d time.Duration) { clock = clock.Add(d) }
+	sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		clock = clock.Add(d)
+		return ctx.Err()
+	}
+	return &slept
+}
+
+func TestWaitForChange(t *testing.T) {
+	newTestMigration(t)
+	pending := &route53.ChangeInfo{Id: aws.String("/change/C1"), Status: aws.String(route53.ChangeStatusPending)}
+	seconds := func(values ...int) []time.Duration {
+		var durations []time.Duration
+		for _, value := range values {
+			durations = append(durations, time.Duration(value)*time.Second)
+		}
+		return durations
+	}
+
+	// The pauses double from changePollInterval up to maxChangePollInterval.
+	svc := &pendingRoute53{insyncAfter: 7}
+	slept := useClock(t, svc)
+	output, err := waitForChange(context.Background(), svc, pending)
+	if err != nil || *output.ChangeInfo.Status != route53.ChangeStatusInsync {
+		t.Fatalf("waitForChange = %v, %v, want the change INSYNC", output, err)
+	}
+	if want := seconds(2, 4, 8, 16, 30, 30, 30); !reflect.DeepEqual(*slept, want) {
+		t.Errorf("slept %v, want %v", *slept, want)
+	}
+
+	// A change that stays PENDING times out once changeTimeout has passed.
+	svc = &pendingRoute53{}
+	slept = useClock(t, svc)
+	_, err = waitForChange(context.Background(), svc, pending)
+	if err == nil || !strings.Contains(err.Error(), "still PENDING after 5m0s") {
+		t.Fatalf("waitForChange = %v, want a timeout", err)
+	}
+	var total time.Duration
+	for _, d := range *slept {
+		total += d
+	}
+	if total < changeTimeout || total >= changeTimeout+maxChangePollInterval {
+		t.Errorf("slept %s in total, want the timeout of %s", total, changeTimeout)
+	}
+
+	// Slow GetChange calls count towards the timeout as well.
+	svc = &pendingRoute53{callTime: time.Minute}
+	useClock(t, svc)
+	if _, err := waitForChange(context.Background(), svc, pending); err == nil {
+		t.Fatal("waitForChange succeeded for a change that stays PENDING")
+	}
+	if svc.calls != 5 {
+		t.Errorf("GetChange was called %d times, want 5 before the timeout", svc.calls)
+	}
+
+	// Cancelling stops the wait at the next pause.
+	svc = &pendingRoute53{}
+	useClock(t, svc)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := waitForChange(ctx, svc, pending); !errors.Is(err, context.Canceled) {
+		t.Errorf("waitForChange cancelled = %v, want context.Canceled", err)
+	}
+	if svc.calls != 0 {
+		t.Errorf("GetChange was called %d times after cancelling", svc.calls)
+	}
+
+	// A planned change needs no waiting.
+	if output, err := waitForChange(context.Background(), svc, nil); err != nil || output.ChangeInfo != nil {
+		t.Errorf("waitForChange(nil) = %v, %v, want no change info", output, err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// Strategies that decide the weights green is given while traffic is shifted
+// to it.
+const (
+	// shiftLinear adds bleedStep to green's weight at every step.
+	shiftLinear = "linear"
+	// shiftExponential gives green 1, 5, 25 and then 100.
+	shiftExponential = "exponential"
+	// shiftSteps gives green the weights listed in shiftSteps.
+	shiftSteps = "steps"
+	// shiftCanary gives green canaryWeight and then 100.
+	shiftCanary = "canary"
+)
+
+var shiftStrategies = []string{shiftLinear, shiftExponential, shiftSteps, shiftCanary}
+
+var exponentialSchedule = []int64{1, 5, 25, 100}
+
+// aliasTTL is the TTL Route53 uses for alias records that target a load
+// balancer.
+const aliasTTL = 60
+
+// shiftSchedule returns the weights green is given in turn by the
+// environment's shift strategy. The last one is always 100.
+func shiftSchedule(defaults environmentDefaults) []int64 {
+	var schedule []int64
+	switch defaults.ShiftStrategy {
+	case shiftExponential:
+		schedule = exponentialSchedule
+	case shiftSteps:
+		schedule = defaults.ShiftSteps
+	case shiftCanary:
+		schedule = []int64{aws.Int64Value(defaults.CanaryWeight)}
+	default:
+		schedule = linearSchedule(aws.Int64Value(defaults.BleedStep))
+	}
+	if len(schedule) == 0 || schedule[len(schedule)-1] != 100 {
+		schedule = append(append([]int64{}, schedule...), 100)
+	}
+	return schedule
+}
+
+// linearSchedule returns the weights that move step percent at a time.
+func linearSchedule(step int64) []int64 {
+	if step <= 0 {
+		return []int64{100}
+	}
+	var schedule []int64
+	for weight := step; weight < 100; weight += step {
+		schedule = append(schedule, weight)
+	}
+	return append(schedule, 100)
+}
+
+// nextWeight returns the first weight of schedule above current.
+func nextWeight(schedule []int64, current int64) int64 {
+	for _, weight := range schedule {
+		if weight > current {
+			return weight
+		}
+	}
+	return 100
+}
+
+// previousWeight returns the last weight of schedule below current, or 0.
+func previousWeight(schedule []int64, current int64) int64 {
+	previous := int64(0)
+	for _, weight := range schedule {
+		if weight >= current {
+			break
+		}
+		previous = weight
+	}
+	return previous
+}
+
+// validateShiftSteps checks that the weights of a step list increase and
+// stay between 1 and 100.
+func validateShiftSteps(steps []int64) error {
+	previous := int64(0)
+	for _, weight := range steps {
+		if weight <= previous || weight > 100 {
+			return fmt.Errorf("weights must increase from 1 to at most 100, got %s", formatWeights(steps))
+		}
+		previous = weight
+	}
+	return nil
+}
+
+// bakeTime returns how long each weight is kept before the next: bakeTime
+// from the environment, but at least the longest TTL of the record sets, so
+// that resolvers have picked up a weight before it changes again.
+func bakeTime(ctx context.Context, defaults environmentDefaults, recordSets ...*route53.ResourceRecordSet) time.Duration {
+	ttl := int64(0)
+	for _, recordSet := range recordSets {
+		recordTTL := int64(aliasTTL)
+		if recordSet.TTL != nil {
+			recordTTL = aws.Int64Value(recordSet.TTL)
+		}
+		if recordTTL > ttl {
+			ttl = recordTTL
+		}
+	}
+	if defaults.BakeTime > 0 && defaults.BakeTime < ttl {
+		fmt.Fprintf(stdout(ctx), "Baking each weight for the record's TTL of %ds instead of %ds.\n", ttl, defaults.BakeTime)
+	}
+	if defaults.BakeTime > ttl {
+		return time.Duration(defaults.BakeTime) * time.Second
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+func formatWeights(weights []int64) string {
+	formatted := make([]string, len(weights))
+	for i, weight := range weights {
+		formatted[i] = fmt.Sprint(weight)
+	}
+	return strings.Join(formatted, ", ")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestShiftSchedule(t *testing.T) {
+	tests := []struct {
+		defaults environmentDefaults
+		want     []int64
+	}{
+		{environmentDefaults{ShiftStrategy: shiftLinear, BleedStep: aws.Int64(20)}, []int64{20, 40, 60, 80, 100}},
+		{environmentDefaults{ShiftStrategy: shiftLinear, BleedStep: aws.Int64(30)}, []int64{30, 60, 90, 100}},
+		{environmentDefaults{ShiftStrategy: shiftLinear, BleedStep: aws.Int64(100)}, []int64{100}},
+		{environmentDefaults{ShiftStrategy: shiftLinear, BleedStep: aws.Int64(0)}, []int64{100}},
+		{environmentDefaults{ShiftStrategy: shiftExponential}, []int64{1, 5, 25, 100}},
+		{environmentDefaults{ShiftStrategy: shiftSteps, ShiftSteps: []int64{1, 10, 50}}, []int64{1, 10, 50, 100}},
+		{environmentDefaults{ShiftStrategy: shiftSteps, ShiftSteps: []int64{10, 100}}, []int64{10, 100}},
+		{environmentDefaults{ShiftStrategy: shiftSteps}, []int64{100}},
+		{environmentDefaults{ShiftStrategy: shiftCanary, CanaryWeight: aws.Int64(5)}, []int64{5, 100}},
+		{builtinDefaults, []int64{20, 40, 60, 80, 100}},
+	}
+	for _, test := range tests {
+		if got := shiftSchedule(test.defaults); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("shiftSchedule(%s) = %v, want %v", test.defaults.ShiftStrategy, got, test.want)
+		}
+	}
+
+	// The configured steps are not appended to.
+	steps := make([]int64, 2, 3)
+	copy(steps, []int64{1, 10})
+	shiftSchedule(environmentDefaults{ShiftStrategy: shiftSteps, ShiftSteps: steps})
+	if steps[:3][2] != 0 {
+		t.Error("shiftSchedule wrote 100 into the configured steps")
+	}
+}
+
+func TestBakeTime(t *testing.T) {
+	cname := &route53.ResourceRecordSet{Type: aws.String(route53.RRTypeCname), TTL: aws.Int64(300)}
+	alias := &route53.ResourceRecordSet{Type: aws.String(route53.RRTypeA), AliasTarget: &route53.AliasTarget{}}
+	short := &route53.ResourceRecordSet{Type: aws.String(route53.RRTypeCname), TTL: aws.Int64(30)}
+	tests := []struct {
+		bakeTime   int64
+		recordSets []*route53.ResourceRecordSet
+		want       time.Duration
+	}{
+		{0, []*route53.ResourceRecordSet{cname}, 300 * time.Second},
+		{120, []*route53.ResourceRecordSet{cname}, 300 * time.Second},
+		{600, []*route53.ResourceRecordSet{cname}, 600 * time.Second},
+		{0, []*route53.ResourceRecordSet{alias}, 60 * time.Second},
+		{30, []*route53.ResourceRecordSet{alias}, 60 * time.Second},
+		{90, []*route53.ResourceRecordSet{alias}, 90 * time.Second},
+		{0, []*route53.ResourceRecordSet{short}, 30 * time.Second},
+		{0, []*route53.ResourceRecordSet{short, alias, cname}, 300 * time.Second},
+		{0, nil, 0},
+	}
+	for _, test := range tests {
+		defaults := environmentDefaults{BakeTime: test.bakeTime}
+		if got := bakeTime(context.Background(), defaults, test.recordSets...); got != test.want {
+			t.Errorf("bakeTime(%d, %d record sets) = %s, want %s", test.bakeTime, len(test.recordSets), got, test.want)
+		}
+	}
+}
+
+func TestNextAndPreviousWeight(t *testing.T) {
+	schedule := []int64{20, 40, 60, 80, 100}
+	tests := []struct {
+		current        int64
+		next, previous int64
+	}{
+		{0, 20, 0},
+		{20, 40, 0},
+		{30, 40, 20},
+		{80, 100, 60},
+		{100, 100, 80},
+	}
+	for _, test := range tests {
+		if got := nextWeight(schedule, test.current); got != test.next {
+			t.Errorf("nextWeight(%d) = %d, want %d", test.current, got, test.next)
+		}
+		if got := previousWeight(schedule, test.current); got != test.previous {
+			t.Errorf("previousWeight(%d) = %d, want %d", test.current, got, test.previous)
+		}
+	}
+}
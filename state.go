@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const stateVersion = 1
+
+// migrationState is written after every step of a migration, and after every
+// weight change while shifting, so that an interrupted migration can be
+// resumed instead of started over.
+type migrationState struct {
+	Version     int    `json:"version"`
+	Environment string `json:"environment"`
+	Region      string `json:"region"`
+	Zone        string `json:"zone"`
+	ZoneID      string `json:"zoneId,omitempty"`
+	CNAME       string `json:"cname"`
+	RecordType  string `json:"recordType,omitempty"`
+
+	SourceElb  string `json:"sourceElb,omitempty"`
+	ReplicaElb string `json:"replicaElb,omitempty"`
+	// TargetType is the kind of load balancer the replica is.
+	TargetType     string `json:"targetType,omitempty"`
+	TargetRegion   string `json:"targetRegion,omitempty"`
+	TargetRoleArn  string `json:"targetRoleArn,omitempty"`
+	ReplicaDNSName string `json:"replicaDnsName,omitempty"`
+
+	// AllRecords is set when the migration also moves the record sets in
+	// Records.
+	AllRecords bool          `json:"allRecords,omitempty"`
+	Records    []recordState `json:"records,omitempty"`
+
+	BlueSetIdentifier  string `json:"blueSetIdentifier,omitempty"`
+	GreenSetIdentifier string `json:"greenSetIdentifier,omitempty"`
+	// BlueOriginalWeight is the weight blue had before the migration and is
+	// restored on rollback.
+	BlueOriginalWeight int64 `json:"blueOriginalWeight"`
+	// BlueWasSimple is set when blue was a simple record set before the
+	// migration converted it into a weighted one.
+	BlueWasSimple bool `json:"blueWasSimple,omitempty"`
+	// BlueDeleted is set once delete-blue-record deleted blue.
+	BlueDeleted bool  `json:"blueDeleted,omitempty"`
+	BlueWeight  int64 `json:"blueWeight"`
+	GreenWeight int64 `json:"greenWeight"`
+
+	CompletedSteps []string  `json:"completedSteps"`
+	RolledBack     bool      `json:"rolledBack,omitempty"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// recordState is the state of a record set that moves together with the one
+// named by CNAME.
+type recordState struct {
+	Zone               string `json:"zone"`
+	ZoneID             string `json:"zoneId"`
+	Name               string `json:"name"`
+	RecordType         string `json:"recordType"`
+	BlueSetIdentifier  string `json:"blueSetIdentifier,omitempty"`
+	GreenSetIdentifier string `json:"greenSetIdentifier,omitempty"`
+	BlueOriginalWeight int64  `json:"blueOriginalWeight"`
+	BlueWasSimple      bool   `json:"blueWasSimple,omitempty"`
+	BlueDeleted        bool   `json:"blueDeleted,omitempty"`
+	BlueWeight         int64  `json:"blueWeight"`
+	GreenWeight        int64  `json:"greenWeight"`
+}
+
+// defaultStatePath names the state file after the record, or the source ELB
+// when there is no record, so concurrent migrations do not share a file.
+func defaultStatePath(opts *options) string {
+	key := strings.TrimSuffix(opts.cname, ".")
+	if key == "" {
+		key = opts.sourceElb
+	}
+	return "elb-auto-" + key + ".state.json"
+}
+
+func loadState(path string) (*migrationState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := &migrationState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %v", path, err)
+	}
+	if state.Version != stateVersion {
+		return nil, fmt.Errorf("state file %s has version %d, expected %d", path, state.Version, stateVersion)
+	}
+	return state, nil
+}
+
+// saveState writes the state to a temporary file first, so that a crash
+// while writing never leaves a truncated state file behind.
+func saveState(path string, state *migrationState) error {
+	state.Version = stateVersion
+	state.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// cnameRecord returns the state of the record set named by CNAME.
+func (state *migrationState) cnameRecord() recordState {
+	return recordState{
+		Zone:               state.Zone,
+		ZoneID:             state.ZoneID,
+		Name:               state.CNAME,
+		RecordType:         state.RecordType,
+		BlueSetIdentifier:  state.BlueSetIdentifier,
+		GreenSetIdentifier: state.GreenSetIdentifier,
+		BlueOriginalWeight: state.BlueOriginalWeight,
+		BlueWasSimple:      state.BlueWasSimple,
+		BlueDeleted:        state.BlueDeleted,
+		BlueWeight:         state.BlueWeight,
+		GreenWeight:        state.GreenWeight,
+	}
+}
+
+// record returns the saved state of the record set of the given name and
+// type in the hosted zone zoneID. A nil state has none.
+func (state *migrationState) record(zoneID string, name string, recordType string) (recordState, bool) {
+	if state == nil {
+		return recordState{}, false
+	}
+	for _, record := range append([]recordState{state.cnameRecord()}, state.Records...) {
+		if record.ZoneID == zoneID && sameDNSName(record.Name, name) && record.RecordType == recordType {
+			return record, true
+		}
+	}
+	return recordState{}, false
+}
+
+func (state *migrationState) completed(step string) bool {
+	for _, name := range state.CompletedSteps {
+		if name == step {
+			return true
+		}
+	}
+	return false
+}
+
+// finished reports whether the state needs no further work.
+func (state *migrationState) finished(steps []*migrationStep) bool {
+	if state.RolledBack {
+		return true
+	}
+	for _, step := range steps {
+		if !state.completed(step.name) {
+			return false
+		}
+	}
+	return true
+}
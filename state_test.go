@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrateSavesState(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.opts.statePath = filepath.Join(t.TempDir(), "state.json")
+
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runMigrate: %v", err)
+	}
+
+	state, err := loadState(tm.opts.statePath)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if !state.finished(migrateSteps) {
+		t.Errorf("completed steps = %v, want all of them", state.CompletedSteps)
+	}
+	if state.SourceElb != "app" || state.ReplicaElb != "app-r" || state.GreenWeight != 100 {
+		t.Errorf("state = %+v, want app moved to app-r", state)
+	}
+}
+
+func TestMigrateRefusesUnfinishedState(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.opts.statePath = filepath.Join(t.TempDir(), "state.json")
+	tm.opts.noRollback = true
+	tm.route53.failChange = 3
+	if err := runMigrate(context.Background(), tm.c, tm.opts); err == nil {
+		t.Fatal("runMigrate succeeded despite the failed change")
+	}
+
+	err := runMigrate(context.Background(), tm.c, tm.opts)
+	if err == nil || !strings.Contains(err.Error(), "holds an unfinished migration") {
+		t.Fatalf("runMigrate = %v, want a refusal to start over", err)
+	}
+}
+
+func TestMigrateKeepsUnreadableState(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.opts.statePath = filepath.Join(t.TempDir(), "state.json")
+	corrupt := []byte(`{"version": 1, "completedSteps": ["discover", "repl`)
+	if err := ioutil.WriteFile(tm.opts.statePath, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := runMigrate(context.Background(), tm.c, tm.opts)
+	if err == nil || !strings.Contains(err.Error(), "parsing state file") {
+		t.Fatalf("runMigrate = %v, want the parse error", err)
+	}
+
+	data, err := ioutil.ReadFile(tm.opts.statePath)
+	if err != nil || string(data) != string(corrupt) {
+		t.Errorf("state file = %q, %v, want it left as it was", data, err)
+	}
+	if tm.hasLoadBalancer("app-r") {
+		t.Error("the migration started despite the unreadable state file")
+	}
+}
+
+func TestResumeAfterCreateGreenRecord(t *testing.T) {
+	tm := newTestMigration(t)
+	tm.opts.statePath = filepath.Join(t.TempDir(), "state.json")
+	tm.opts.noRollback = true
+	// The shift is declined, so the migration stops after create-green-record.
+	stdin = bufio.NewReader(strings.NewReader("y\nn\n"))
+	if err := runMigrate(context.Background(), tm.c, tm.opts); !errors.Is(err, errAborted) {
+		t.Fatalf("runMigrate = %v, want errAborted", err)
+	}
+	if want := map[string]int64{"app": 100, "app-r": 0}; !reflect.DeepEqual(tm.weights(), want) {
+		t.Fatalf("weights = %v, want %v", tm.weights(), want)
+	}
+
+	stdin = bufio.NewReader(strings.NewReader(strings.Repeat("y\n", 10)))
+	if err := runResume(context.Background(), tm.c, tm.opts); err != nil {
+		t.Fatalf("runResume: %v", err)
+	}
+	if want := map[string]int64{"app-r": 100}; !reflect.DeepEqual(tm.weights(), want) {
+		t.Errorf("weights = %v, want %v", tm.weights(), want)
+	}
+	if tm.hasLoadBalancer("app") {
+		t.Error("source ELB app was not deleted")
+	}
+}
+
+func TestResumePartialDeleteBlueRecord(t *testing.T) {
+	for _, saved := range []bool{true, false} {
+		tm := newTestMigration(t)
+		tm.addOtherRecords()
+		tm.opts.allRecords = true
+		tm.opts.statePath = filepath.Join(t.TempDir(), "state.json")
+		tm.opts.noRollback = true
+		// The deletion of the second blue record set is declined.
+		stdin = bufio.NewReader(strings.NewReader("y\ny\ny\ny\nn\n"))
+		if err := runMigrate(context.Background(), tm.c, tm.opts); !errors.Is(err, errAborted) {
+			t.Fatalf("runMigrate = %v, want errAborted", err)
+		}
+		if !saved {
+			// The first deletion was not saved, as if the tool stopped right
+			// after it.
+			state, err := loadState(tm.opts.statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !state.BlueDeleted {
+				t.Fatal("the deletion of the first blue record set was not saved")
+			}
+			state.BlueDeleted = false
+			if err := saveState(tm.opts.statePath, state); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stdin = bufio.NewReader(strings.NewReader(strings.Repeat("y\n", 10)))
+		if err := runResume(context.Background(), tm.c, tm.opts); err != nil {
+			t.Fatalf("runResume (deletion saved: %v): %v", saved, err)
+		}
+		want := map[string]int64{
+			"app.test.example.com. CNAME app-r":  100,
+			"alias.test.example.com. A app-r":    100,
+			"www.other.example.com. CNAME app-r": 100,
+			"api.other.example.com. CNAME ":      -1,
+		}
+		if weights := tm.allWeights(); !reflect.DeepEqual(weights, want) {
+			t.Errorf("record sets (deletion saved: %v) = %v, want %v", saved, weights, want)
+		}
+		state, err := loadState(tm.opts.statePath)
+		if err != nil || !state.finished(migrateSteps) {
+			t.Errorf("state = %+v, %v, want the migration finished", state, err)
+		}
+	}
+}
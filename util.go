@@ -2,19 +2,91 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"time"
 )
 
-func replConfirmation(text string) {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print(text)
-	inputText, _ := reader.ReadString('\n')
+// sleep pauses between polls of AWS and returns early with the context's
+// error when ctx is cancelled. It is a variable so that runs against fake
+// clients do not have to wait.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// timeNow is the clock that polls are timed by, a variable for the same
+// reason as sleep.
+var timeNow = time.Now
+
+// stdin is shared by all confirmations so that input buffered by one prompt
+// is not lost to the next.
+var stdin = bufio.NewReader(os.Stdin)
+
+type stdoutKey struct{}
+
+// withStdout returns a context whose progress is written to w instead of
+// stdout.
+func withStdout(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, stdoutKey{}, w)
+}
+
+// stdout returns where the progress of ctx is written.
+func stdout(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(stdoutKey{}).(io.Writer); ok {
+		return w
+	}
+	return os.Stdout
+}
+
+type confirmedKey struct{}
+
+// withConfirmed returns a context in which every confirmation is answered
+// with yes. The batch command runs its migrations in one once the whole batch
+// has been confirmed.
+func withConfirmed(ctx context.Context) context.Context {
+	return context.WithValue(ctx, confirmedKey{}, true)
+}
+
+// replConfirmation asks the user to confirm the next step and returns
+// errAborted unless they answer "y", or the context's error if ctx is
+// cancelled while waiting for the answer.
+func replConfirmation(ctx context.Context, text string) error {
+	if planOf(ctx) != nil {
+		fmt.Fprintln(stdout(ctx), text+"y (dry run)")
+		return nil
+	}
+	if confirmed, _ := ctx.Value(confirmedKey{}).(bool); confirmed {
+		fmt.Fprintln(stdout(ctx), text+"y (confirmed for the batch)")
+		return nil
+	}
+	fmt.Fprint(stdout(ctx), text)
+	answer := make(chan string, 1)
+	go func() {
+		inputText, _ := stdin.ReadString('\n')
+		answer <- inputText
+	}()
+	var inputText string
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(stdout(ctx), "")
+		return ctx.Err()
+	case inputText = <-answer:
+	}
 	if inputText != "y\n" {
-		fmt.Println("Stopping...")
-		os.Exit(1)
+		fmt.Fprintln(stdout(ctx), "Stopping...")
+		return errAborted
 	}
+	return nil
 }
 
 func clamp(value int64, min int64, max int64) int64 {